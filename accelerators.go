@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the gpu and tpu quota of services other than compute, like vertex ai training and prediction
+	acceleratorQuotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_accelerator_limit",
+		Help: "The limit of a gpu or tpu quota of a service like vertex ai, per region, accelerator type like nvidia_a100 and workload like custom_model_training; -1 is unlimited.",
+	}, []string{"project", "service", "region", "accelerator", "workload", "quota_metric"})
+
+	acceleratorQuotaUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_accelerator_usage",
+		Help: "The usage of a gpu or tpu quota of a service like vertex ai as last reported to cloud monitoring.",
+	}, []string{"project", "service", "region", "accelerator", "workload", "quota_metric"})
+)
+
+func init() {
+	prometheus.MustRegister(acceleratorQuotaLimit)
+	prometheus.MustRegister(acceleratorQuotaUsage)
+
+	registerQuotaSource("accelerators", func(clients clientProvider, regions []string) quotaSource {
+		return &acceleratorsQuotaSource{clients: clients, regions: regions, services: splitNonEmpty(*acceleratorServices)}
+	})
+}
+
+// acceleratorsQuotaSource exports the gpu and tpu quota of vertex ai and cloud tpu, which live outside the compute api; it takes them
+// from the service usage api like the service-usage collector, but only keeps accelerator quota and labels it by accelerator type
+type acceleratorsQuotaSource struct {
+	clients  clientProvider
+	regions  []string
+	services []string
+}
+
+func (s *acceleratorsQuotaSource) Name() string {
+	return "accelerators"
+}
+
+// Discover doesn't return locations, the quota of all regions is retrieved per service in one call
+func (s *acceleratorsQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *acceleratorsQuotaSource) Permissions() []string {
+	return []string{"serviceusage.quotas.get", "monitoring.timeSeries.list"}
+}
+
+func (s *acceleratorsQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := listAllocationUsage(ctx, httpClient, project)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range s.services {
+		buckets, err := listConsumerQuotaBuckets(ctx, httpClient, project, service)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, bucket := range buckets {
+			workload, accelerator, ok := acceleratorFromQuotaMetric(bucket.Metric)
+			if !ok {
+				continue
+			}
+			region, ok := quotaDimensionsRegion(bucket.Dimensions, s.regions)
+			if !ok {
+				continue
+			}
+
+			value, ok := sanitizeQuotaValue("gcloud", project, bucket.Metric, "limit", float64(bucket.EffectiveLimit))
+			if !ok {
+				continue
+			}
+			setGauge(ctx, acceleratorQuotaLimit, value, project, service, region, accelerator, workload, bucket.Metric)
+
+			if used, ok := usage[serviceQuotaKey{service: service, quotaMetric: bucket.Metric, region: region}]; ok {
+				setGauge(ctx, acceleratorQuotaUsage, used, project, service, region, accelerator, workload, bucket.Metric)
+			}
+		}
+	}
+
+	// accelerator quota of other services isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
+
+// acceleratorFromQuotaMetric splits a quota metric like aiplatform.googleapis.com/custom_model_training_nvidia_a100_gpus into the
+// workload custom_model_training and the accelerator nvidia_a100; quota metrics without a gpu or tpu are skipped
+func acceleratorFromQuotaMetric(metric string) (workload, accelerator string, ok bool) {
+
+	name := metric[strings.LastIndex(metric, "/")+1:]
+
+	index := -1
+	for _, prefix := range []string{"nvidia_", "tpu_"} {
+		if i := strings.Index(name, prefix); i >= 0 && (i == 0 || name[i-1] == '_') {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return "", "", false
+	}
+
+	return strings.TrimSuffix(name[:index], "_"), strings.TrimSuffix(name[index:], "_gpus"), true
+}
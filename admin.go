@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/rs/zerolog/log"
+)
+
+// adminServer serves endpoints to inspect and operate the exporter, on a separate listener from the metrics so it can be locked down independently
+type adminServer struct {
+	ctx      context.Context
+	clients  *clientManager
+	sources  []credentialSource
+	projects []string
+	regions  []string
+}
+
+// initAdminServer serves the admin endpoints on the configured address; it's disabled if the address is empty
+func initAdminServer(server *adminServer) {
+
+	if *adminListenAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", server.handleStatus)
+	mux.HandleFunc("/config", server.handleConfig)
+	mux.HandleFunc("/reload", server.handleReload)
+	mux.HandleFunc("/api/v1/refresh", server.handleRefresh)
+	mux.HandleFunc("/grafana/dashboard", server.handleGrafanaDashboard)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Debug().
+			Str("address", *adminListenAddress).
+			Msg("Serving admin endpoints...")
+
+		if err := http.ListenAndServe(*adminListenAddress, mux); err != nil {
+			log.Fatal().Err(err).Msg("Starting admin listener failed")
+		}
+	}()
+}
+
+func (s *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+
+	credentials := map[string][]string{}
+	for _, source := range s.sources {
+		credentials[source.File] = source.Projects
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"app":                app,
+		"version":            version,
+		"revision":           revision,
+		"buildDate":          buildDate,
+		"goVersion":          goVersion,
+		"projects":           s.projects,
+		"regions":            s.regions,
+		"credentials":        credentials,
+		"failingProjects":    deadProjectsStatus(),
+		"missingPermissions": missingPermissionsStatus(),
+	})
+}
+
+// secretFlags are the flags whose values are redacted on /config
+var secretFlags = map[string]bool{
+	"metrics-bearer-token":      true,
+	"slack-signing-secret":      true,
+	"sentry-dsn":                true,
+	"remote-write-password":     true,
+	"remote-write-bearer-token": true,
+	"datadog-api-key":           true,
+}
+
+// headerFlags are the flags holding headers as key=value, whose values usually carry credentials like an authorization header
+var headerFlags = map[string]*[]string{
+	"otlp-header": otlpHeaders,
+}
+
+// redactHeaders redacts the values of headers given as key=value, keeping the header names
+func redactHeaders(headers []string) string {
+
+	redacted := make([]string, 0, len(headers))
+	for _, header := range headers {
+		redacted = append(redacted, strings.SplitN(header, "=", 2)[0]+"=<redacted>")
+	}
+
+	return fmt.Sprintf("%v", redacted)
+}
+
+// handleConfig returns the effective value of all flags, with secrets redacted
+func (s *adminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+
+	config := map[string]string{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Name == "help" {
+			continue
+		}
+		value := flag.String()
+		if value != "" && secretFlags[flag.Name] {
+			value = "<redacted>"
+		}
+		if headers, ok := headerFlags[flag.Name]; ok {
+			value = redactHeaders(*headers)
+		}
+		config[flag.Name] = value
+	}
+
+	writeJSON(w, config)
+}
+
+// handleReload recreates all google cloud clients, e.g. after credentials were changed in a way the file watcher missed
+func (s *adminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Info().Msg("Reloading google cloud clients as requested via admin endpoint")
+
+	s.clients.reloadAll(s.ctx, s.sources)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Warn().Err(err).Msg("Writing json response failed")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// create gauge for projects that are skipped because they never enabled the compute api
+	projectAPIDisabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_api_disabled",
+		Help: "Whether the project is skipped because the compute api isn't enabled in it; it's checked again every --api-disabled-recheck.",
+	}, []string{"project"})
+
+	// errComputeAPIDisabled marks projects skipped because the compute api isn't enabled
+	errComputeAPIDisabled = errors.New("The compute api isn't enabled in the project")
+
+	// projects without the compute api and the time until which they're skipped
+	computeAPIDisabledUntil      = map[string]time.Time{}
+	computeAPIDisabledUntilMutex sync.Mutex
+)
+
+func init() {
+	prometheus.MustRegister(projectAPIDisabled)
+}
+
+// isComputeAPIDisabledError checks whether an api error is caused by the compute api not being enabled in the project
+func isComputeAPIDisabledError(err error) bool {
+	return isServiceDisabledError(err, "compute.googleapis.com", "Compute Engine API")
+}
+
+// isServiceDisabledError checks whether an api error is caused by a service not being enabled in the project, which is reported as
+// SERVICE_DISABLED, or accessNotConfigured by older endpoints; the service is recognized by its name or title
+func isServiceDisabledError(err error, service, title string) bool {
+
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Code != 403 {
+		return false
+	}
+
+	disabled := strings.Contains(apiErr.Body, "SERVICE_DISABLED") || strings.Contains(apiErr.Message, "SERVICE_DISABLED")
+	for _, item := range apiErr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			disabled = true
+		}
+	}
+	if !disabled {
+		return false
+	}
+
+	return strings.Contains(apiErr.Body, service) || strings.Contains(apiErr.Message, title)
+}
+
+// usesComputeAPI checks whether a source calls the compute api, judging by the permissions it needs; only those sources are skipped
+// while the compute api is disabled in a project, and only their success shows it has been enabled again
+func usesComputeAPI(source quotaSource) bool {
+
+	s, ok := source.(permissionsQuotaSource)
+	if !ok {
+		return false
+	}
+	for _, permission := range s.Permissions() {
+		if strings.HasPrefix(permission, "compute.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleComputeAPIDisabled skips the project until the next recheck, since none of its compute calls can succeed until someone
+// enables the api
+func handleComputeAPIDisabled(project string) {
+
+	computeAPIDisabledUntilMutex.Lock()
+	_, known := computeAPIDisabledUntil[project]
+	computeAPIDisabledUntil[project] = time.Now().Add(*apiDisabledRecheck)
+	computeAPIDisabledUntilMutex.Unlock()
+
+	if !known {
+		log.Warn().Str("project", project).Msgf("The compute api isn't enabled in project %v, skipping it and checking again every %v", project, *apiDisabledRecheck)
+	}
+	projectAPIDisabled.WithLabelValues(project).Set(1)
+}
+
+// isComputeAPIDisabled checks whether the project is still skipped after the compute api turned out to be disabled; once the recheck
+// is due it's attempted again, and the flag is cleared when that succeeds
+func isComputeAPIDisabled(project string) bool {
+
+	computeAPIDisabledUntilMutex.Lock()
+	defer computeAPIDisabledUntilMutex.Unlock()
+
+	disabledUntil, ok := computeAPIDisabledUntil[project]
+
+	return ok && time.Now().Before(disabledUntil)
+}
+
+// clearComputeAPIDisabled stops skipping the project after it was retrieved successfully, e.g. because the api got enabled
+func clearComputeAPIDisabled(project string) {
+
+	computeAPIDisabledUntilMutex.Lock()
+	_, ok := computeAPIDisabledUntil[project]
+	delete(computeAPIDisabledUntil, project)
+	computeAPIDisabledUntilMutex.Unlock()
+
+	if ok {
+		log.Info().Str("project", project).Msgf("The compute api is enabled in project %v again, no longer skipping it", project)
+		projectAPIDisabled.WithLabelValues(project).Set(0)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultAppEngineLimits are the documented app engine limits of paid apps, which aren't exposed by any api; they can be overridden
+// with --appengine-limit, e.g. for free apps
+var defaultAppEngineLimits = map[string]float64{
+	"services_per_app": 210,
+	"versions_per_app": 210,
+}
+
+// configuredAppEngineLimits are the default app engine limits with the --appengine-limit overrides applied
+var configuredAppEngineLimits = defaultAppEngineLimits
+
+var (
+	// create gauges for the limit and actual count of app engine services, versions and instances
+	appEngineLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_appengine_limit",
+		Help: "The app engine limit for services_per_app, versions_per_app or instances_per_version, the maximum instances a version is configured to scale to; service and version are empty for limits that don't apply to them.",
+	}, []string{"project", "service", "version", "metric"})
+
+	appEngineUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_appengine_usage",
+		Help: "The number of services or versions of an app, or instances of a version, counting against the app engine limit.",
+	}, []string{"project", "service", "version", "metric"})
+)
+
+func init() {
+	prometheus.MustRegister(appEngineLimit)
+	prometheus.MustRegister(appEngineUsage)
+
+	registerQuotaSource("appengine", func(clients clientProvider, regions []string) quotaSource {
+		return &appEngineQuotaSource{clients: clients, limits: configuredAppEngineLimits}
+	})
+}
+
+// appEngineVersion is the part of an app engine version of the admin api the appengine collector uses
+type appEngineVersion struct {
+	ID               string `json:"id"`
+	ServingStatus    string `json:"servingStatus"`
+	AutomaticScaling *struct {
+		StandardSchedulerSettings *struct {
+			MaxInstances int64 `json:"maxInstances"`
+		} `json:"standardSchedulerSettings"`
+		MaxTotalInstances int64 `json:"maxTotalInstances"`
+	} `json:"automaticScaling"`
+	BasicScaling *struct {
+		MaxInstances int64 `json:"maxInstances"`
+	} `json:"basicScaling"`
+	ManualScaling *struct {
+		Instances int64 `json:"instances"`
+	} `json:"manualScaling"`
+}
+
+// appEngineQuotaSource exports the number of services and versions of the app of each project against the app engine limits, and the
+// instances of each serving version against the maximum it's configured to scale to
+type appEngineQuotaSource struct {
+	clients clientProvider
+	limits  map[string]float64
+}
+
+func (s *appEngineQuotaSource) Name() string {
+	return "appengine"
+}
+
+// Discover doesn't return locations, an app has a single region
+func (s *appEngineQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *appEngineQuotaSource) Permissions() []string {
+	return []string{"appengine.services.list", "appengine.versions.list", "appengine.instances.list"}
+}
+
+func (s *appEngineQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+	app := fmt.Sprintf("https://appengine.googleapis.com/v1/apps/%v", project)
+
+	services := []string{}
+	err = listAppEnginePages(func(query url.Values) (string, error) {
+		query.Set("fields", "services/id,nextPageToken")
+
+		var response struct {
+			Services []struct {
+				ID string `json:"id"`
+			} `json:"services"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/services?%v", app, query.Encode()), &response)
+		if err != nil {
+			return "", err
+		}
+		for _, service := range response.Services {
+			services = append(services, service.ID)
+		}
+		return response.NextPageToken, nil
+	})
+	// most projects don't have an app
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := 0
+	for _, service := range services {
+		serviceVersions := []appEngineVersion{}
+		err = listAppEnginePages(func(query url.Values) (string, error) {
+			query.Set("view", "FULL")
+
+			var response struct {
+				Versions      []appEngineVersion `json:"versions"`
+				NextPageToken string             `json:"nextPageToken"`
+			}
+			err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/services/%v/versions?%v", app, service, query.Encode()), &response)
+			if err != nil {
+				return "", err
+			}
+			serviceVersions = append(serviceVersions, response.Versions...)
+			return response.NextPageToken, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		versions += len(serviceVersions)
+
+		for _, version := range serviceVersions {
+			if version.ServingStatus != "SERVING" {
+				continue
+			}
+
+			instances := 0
+			err = listAppEnginePages(func(query url.Values) (string, error) {
+				query.Set("fields", "instances/id,nextPageToken")
+
+				var response struct {
+					Instances []struct {
+						ID string `json:"id"`
+					} `json:"instances"`
+					NextPageToken string `json:"nextPageToken"`
+				}
+				err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/services/%v/versions/%v/instances?%v", app, service, version.ID, query.Encode()), &response)
+				if err != nil {
+					return "", err
+				}
+				instances += len(response.Instances)
+				return response.NextPageToken, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			setGauge(ctx, appEngineUsage, float64(instances), project, service, version.ID, "instances_per_version")
+			if maxInstances := appEngineMaxInstances(version); maxInstances > 0 {
+				setGauge(ctx, appEngineLimit, float64(maxInstances), project, service, version.ID, "instances_per_version")
+			}
+		}
+	}
+
+	setGauge(ctx, appEngineLimit, s.limits["services_per_app"], project, "", "", "services_per_app")
+	setGauge(ctx, appEngineUsage, float64(len(services)), project, "", "", "services_per_app")
+	setGauge(ctx, appEngineLimit, s.limits["versions_per_app"], project, "", "", "versions_per_app")
+	setGauge(ctx, appEngineUsage, float64(versions), project, "", "", "versions_per_app")
+
+	// app engine limits aren't compute quota, so they're only exported as metrics
+	return nil, nil
+}
+
+// appEngineMaxInstances returns the maximum number of instances a version is configured to scale to, or 0 if it scales without limit
+func appEngineMaxInstances(version appEngineVersion) int64 {
+
+	switch {
+	case version.ManualScaling != nil:
+		return version.ManualScaling.Instances
+	case version.BasicScaling != nil:
+		return version.BasicScaling.MaxInstances
+	case version.AutomaticScaling != nil && version.AutomaticScaling.StandardSchedulerSettings != nil && version.AutomaticScaling.StandardSchedulerSettings.MaxInstances > 0:
+		return version.AutomaticScaling.StandardSchedulerSettings.MaxInstances
+	case version.AutomaticScaling != nil:
+		return version.AutomaticScaling.MaxTotalInstances
+	default:
+		return 0
+	}
+}
+
+// listAppEnginePages calls list for every page of an app engine admin api list call, until it returns no next page token
+func listAppEnginePages(list func(query url.Values) (string, error)) error {
+
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("pageSize", "100")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		nextPageToken, err := list(query)
+		if err != nil {
+			return err
+		}
+
+		more, err := tokens.next(nextPageToken)
+		if err != nil {
+			return fmt.Errorf("Listing app engine resources failed: %v", err)
+		}
+		if !more {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// parseAppEngineLimits applies overrides given as metric=value, like versions_per_app=15, to the default app engine limits
+func parseAppEngineLimits(overrides []string) (map[string]float64, error) {
+
+	limits := map[string]float64{}
+	for metric, value := range defaultAppEngineLimits {
+		limits[metric] = value
+	}
+
+	for _, o := range overrides {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("App engine limit %v should be formatted as metric=value", o)
+		}
+		if _, ok := defaultAppEngineLimits[parts[0]]; !ok {
+			return nil, fmt.Errorf("App engine limit %v is unknown, it should be services_per_app or versions_per_app", parts[0])
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("App engine limit %v should have a positive value", o)
+		}
+		limits[parts[0]] = value
+	}
+
+	return limits, nil
+}
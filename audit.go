@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// auditLogger writes one json line per outbound google cloud api call; nil when audit logging is disabled
+var auditLogger *zerolog.Logger
+
+// initAuditLogging enables audit logging to the file at path, or to stdout if path is empty
+func initAuditLogging(path string) error {
+
+	var writer io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		writer = file
+	}
+
+	logger := zerolog.New(writer).With().Timestamp().Str("logType", "audit").Logger()
+	auditLogger = &logger
+
+	return nil
+}
+
+// auditTransport logs every request passing through it to the audit logger
+type auditTransport struct {
+	base   http.RoundTripper
+	logger *zerolog.Logger
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+
+	event := t.logger.Log().
+		Str("method", req.Method).
+		Str("host", req.URL.Host).
+		Str("path", req.URL.Path).
+		Str("project", projectFromAPIPath(req.URL.Path)).
+		Dur("latency", latency)
+
+	if err != nil {
+		event.Err(err).Msg("Outbound api call failed")
+		return resp, err
+	}
+
+	event.Int("status", resp.StatusCode).Msg("Outbound api call")
+
+	return resp, err
+}
+
+// locationFromAPIPath extracts the region or zone from api paths like /compute/v1/projects/{project}/regions/{region}, if any
+func locationFromAPIPath(path string) string {
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if (segment == "regions" || segment == "zones" || segment == "locations") && i+1 < len(segments) {
+			return segments[i+1]
+		}
+	}
+
+	return ""
+}
+
+// projectFromAPIPath extracts the project id from api paths like /compute/v1/projects/{project}/regions/{region}
+func projectFromAPIPath(path string) string {
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "projects" && i+1 < len(segments) {
+			return segments[i+1]
+		}
+	}
+
+	return ""
+}
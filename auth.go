@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// bearerTokenValidator checks the Authorization header of incoming requests against a static or file-sourced token
+type bearerTokenValidator struct {
+	token string
+	mutex sync.RWMutex
+}
+
+// newBearerTokenValidator creates a validator for the token, or for the content of tokenFile if set; with both empty no check is done
+func newBearerTokenValidator(token, tokenFile string) (*bearerTokenValidator, error) {
+
+	v := &bearerTokenValidator{
+		token: token,
+	}
+
+	if tokenFile == "" {
+		return v, nil
+	}
+
+	err := v.loadTokenFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	foundation.WatchForFileChanges(tokenFile, func(event fsnotify.Event) {
+		// reload the token so it can be rotated without restarting
+		err := v.loadTokenFile(tokenFile)
+		if err != nil {
+			log.Error().Err(err).Msgf("Reloading bearer token from file %v failed, keeping previous token", tokenFile)
+		}
+	})
+
+	return v, nil
+}
+
+func (v *bearerTokenValidator) loadTokenFile(tokenFile string) error {
+
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.token = strings.TrimSpace(string(data))
+
+	return nil
+}
+
+func (v *bearerTokenValidator) enabled() bool {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.token != ""
+}
+
+func (v *bearerTokenValidator) validate(r *http.Request) bool {
+	return v.validAuthorization(r.Header.Get("Authorization"))
+}
+
+// validAuthorization checks the value of an http authorization header or grpc authorization metadata
+func (v *bearerTokenValidator) validAuthorization(authorization string) bool {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	if v.token == "" {
+		return true
+	}
+
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(v.token)) == 1
+}
+
+// middleware rejects requests without a valid bearer token with a 401
+func (v *bearerTokenValidator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !v.validate(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// streamInterceptor rejects grpc streams without a valid bearer token in their authorization metadata
+func (v *bearerTokenValidator) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	if !v.validAuthorization(authorization) {
+		return status.Error(codes.Unauthenticated, "A valid bearer token is required")
+	}
+
+	return handler(srv, stream)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBearerTokenValidatorValidate(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		valid         bool
+	}{
+		{name: "AcceptsCorrectToken", token: "secret", authorization: "Bearer secret", valid: true},
+		{name: "RejectsMissingToken", token: "secret", authorization: ""},
+		{name: "RejectsWrongToken", token: "secret", authorization: "Bearer other"},
+		{name: "RejectsTokenWithoutBearerScheme", token: "secret", authorization: "secret"},
+		{name: "RejectsBasicAuthentication", token: "secret", authorization: "Basic c2VjcmV0"},
+		{name: "AcceptsAnyRequestWithoutConfiguredToken", token: "", authorization: "", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			v, err := newBearerTokenValidator(tt.token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			if valid := v.validate(r); valid != tt.valid {
+				t.Errorf("Validating %q returned %v, expected %v", tt.authorization, valid, tt.valid)
+			}
+		})
+	}
+}
+
+// contextServerStream is a grpc server stream that only carries the context of the call
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestBearerTokenValidatorStreamInterceptor(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		valid         bool
+	}{
+		{name: "AcceptsCorrectToken", token: "secret", authorization: "Bearer secret", valid: true},
+		{name: "RejectsMissingToken", token: "secret", authorization: ""},
+		{name: "RejectsWrongToken", token: "secret", authorization: "Bearer other"},
+		{name: "AcceptsAnyStreamWithoutConfiguredToken", token: "", authorization: "", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			v, err := newBearerTokenValidator(tt.token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			handled := false
+			err = v.streamInterceptor(nil, &contextServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+				handled = true
+				return nil
+			})
+
+			if handled != tt.valid {
+				t.Errorf("Stream with %q was handled %v, expected %v", tt.authorization, handled, tt.valid)
+			}
+			if !tt.valid && status.Code(err) != codes.Unauthenticated {
+				t.Errorf("Stream with %q returned %v, expected Unauthenticated", tt.authorization, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// create counter for quota increase requests filed automatically, by outcome
+	autoFiledRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_auto_filed_requests_total",
+		Help: "The number of quota increase requests filed automatically after usage stayed above --auto-file-threshold, by outcome: filed or failed.",
+	}, []string{"project", "region", "metric", "outcome"})
+
+	// create gauge for the value of the last quota increase request filed automatically
+	autoFiledRequestedValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_auto_filed_requested_value",
+		Help: "The value requested by the last quota increase request filed automatically for the quota.",
+	}, []string{"project", "region", "metric"})
+)
+
+func init() {
+	prometheus.MustRegister(autoFiledRequestsTotal)
+	prometheus.MustRegister(autoFiledRequestedValue)
+}
+
+// quotaAutoFiler files a quota preference to increase a compute quota once its usage stayed above the threshold for a number of
+// cycles in a row; only quota with a configured cap are increased, to the limit times the multiplier but never beyond the cap
+type quotaAutoFiler struct {
+	clients      clientProvider
+	threshold    float64
+	cycles       int
+	multiplier   float64
+	caps         map[string]float64
+	contactEmail string
+
+	// cycles in a row above the threshold and the value last filed per quota
+	above map[historyKey]int
+	filed map[historyKey]int64
+	mutex sync.Mutex
+}
+
+// newQuotaAutoFiler parses the caps, given as metric=value like cpus=1000
+func newQuotaAutoFiler(clients clientProvider, threshold float64, cycles int, multiplier float64, caps []string, contactEmail string) (*quotaAutoFiler, error) {
+
+	parsedCaps := map[string]float64{}
+	for _, c := range caps {
+		parts := strings.SplitN(c, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Cap %v should be formatted as metric=value", c)
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("Cap %v should have a positive value", c)
+		}
+		parsedCaps[parts[0]] = value
+	}
+
+	return &quotaAutoFiler{
+		clients:      clients,
+		threshold:    threshold,
+		cycles:       cycles,
+		multiplier:   multiplier,
+		caps:         parsedCaps,
+		contactEmail: contactEmail,
+		above:        map[historyKey]int{},
+		filed:        map[historyKey]int64{},
+	}, nil
+}
+
+// run evaluates every published fetch cycle
+func (f *quotaAutoFiler) run(ctx context.Context) {
+
+	updates, _ := quotaUpdates.subscribe()
+	defer quotaUpdates.unsubscribe(updates)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cycle := <-updates:
+			f.evaluate(ctx, cycle)
+		}
+	}
+}
+
+// evaluate counts the cycles each capped quota has been above the threshold and files an increase for those above it long enough
+func (f *quotaAutoFiler) evaluate(ctx context.Context, updates []quotaUpdate) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, update := range updates {
+		cap, ok := f.caps[update.Metric]
+		if !ok || update.Provider != "" || update.Limit <= 0 {
+			continue
+		}
+
+		key := historyKey{update.Provider, update.Project, update.Region, update.Metric}
+		if update.Usage/update.Limit < f.threshold {
+			delete(f.above, key)
+			continue
+		}
+		f.above[key]++
+		if f.above[key] < f.cycles {
+			continue
+		}
+
+		requested := int64(math.Min(math.Ceil(update.Limit*f.multiplier), cap))
+		if float64(requested) <= update.Limit {
+			// the cap is reached
+			continue
+		}
+
+		region := update.Region
+		if region == "" {
+			region = "global"
+		}
+
+		// the values filed are only kept in memory, so after a restart the preference is read first to not file it again
+		if _, ok := f.filed[key]; !ok {
+			preferred, err := f.preferredValue(ctx, update)
+			if err != nil {
+				autoFiledRequestsTotal.WithLabelValues(update.Project, region, update.Metric, "failed").Inc()
+				log.Error().Err(err).Str("project", update.Project).Str("region", region).Str("metric", update.Metric).Msgf("Retrieving quota preference of %v in project %v failed", update.Metric, update.Project)
+				continue
+			}
+			f.filed[key] = preferred
+		}
+		if requested <= f.filed[key] {
+			// this increase has been requested already
+			continue
+		}
+
+		err := f.file(ctx, update, requested)
+		if err != nil {
+			autoFiledRequestsTotal.WithLabelValues(update.Project, region, update.Metric, "failed").Inc()
+			log.Error().Err(err).Str("project", update.Project).Str("region", region).Str("metric", update.Metric).Msgf("Filing quota increase of %v in project %v from %v to %v failed", update.Metric, update.Project, update.Limit, requested)
+			continue
+		}
+
+		f.filed[key] = requested
+		autoFiledRequestsTotal.WithLabelValues(update.Project, region, update.Metric, "filed").Inc()
+		autoFiledRequestedValue.WithLabelValues(update.Project, region, update.Metric).Set(float64(requested))
+		log.Info().Str("project", update.Project).Str("region", region).Str("metric", update.Metric).Float64("usage", update.Usage).Float64("limit", update.Limit).Int64("requested", requested).Msgf("Filed quota increase of %v in project %v from %v to %v after %v cycles above %v of the limit", update.Metric, update.Project, update.Limit, requested, f.above[key], f.threshold)
+	}
+}
+
+// file creates or updates the quota preference of the exporter for the compute quota, so repeated increases of the same quota
+// update a single preference
+func (f *quotaAutoFiler) file(ctx context.Context, update quotaUpdate, requested int64) error {
+
+	httpClient, err := f.clients.httpClient(update.Project)
+	if err != nil {
+		return err
+	}
+
+	quotaID := computeQuotaID(update.Metric, update.Region)
+	dimensions := map[string]string{}
+	if update.Region != "" {
+		dimensions["region"] = update.Region
+	}
+
+	preference := map[string]interface{}{
+		"service":      "compute.googleapis.com",
+		"quotaId":      quotaID,
+		"dimensions":   dimensions,
+		"contactEmail": f.contactEmail,
+		"justification": fmt.Sprintf("Usage of %v has been above %v%% of the limit of %v for %v fetch cycles in a row, filed by estafette-gcloud-quota-exporter",
+			update.Metric, f.threshold*100, update.Limit, f.above[historyKey{update.Provider, update.Project, update.Region, update.Metric}]),
+		"quotaConfig": map[string]interface{}{
+			"preferredValue": strconv.FormatInt(requested, 10),
+		},
+	}
+
+	query := url.Values{}
+	query.Set("allowMissing", "true")
+
+	return sendGoogleJSON(ctx, httpClient, "PATCH", fmt.Sprintf("https://cloudquotas.googleapis.com/v1/projects/%v/locations/global/quotaPreferences/%v?%v", update.Project, quotaPreferenceID(update), query.Encode()), preference, nil)
+}
+
+// preferredValue returns the value of the quota preference of the exporter for the compute quota, or 0 if it hasn't filed one
+func (f *quotaAutoFiler) preferredValue(ctx context.Context, update quotaUpdate) (int64, error) {
+
+	httpClient, err := f.clients.httpClient(update.Project)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		QuotaConfig struct {
+			PreferredValue int64 `json:"preferredValue,string"`
+		} `json:"quotaConfig"`
+	}
+	err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://cloudquotas.googleapis.com/v1/projects/%v/locations/global/quotaPreferences/%v", update.Project, quotaPreferenceID(update)), &response)
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return response.QuotaConfig.PreferredValue, nil
+}
+
+// quotaPreferenceID returns the id of the quota preference the exporter files for the compute quota
+func quotaPreferenceID(update quotaUpdate) string {
+
+	preferenceID := "estafette-" + strings.ToLower(computeQuotaID(update.Metric, update.Region))
+	if update.Region != "" {
+		preferenceID += "-" + update.Region
+	}
+
+	return preferenceID
+}
+
+// computeQuotaID returns the cloud quotas id of a compute quota, like CPUS-per-project-region for the regional cpus quota or
+// NETWORKS-per-project for the global networks quota
+func computeQuotaID(metric, region string) string {
+
+	id := strings.ToUpper(strings.Replace(metric, "_", "-", -1))
+	if region == "" {
+		return id + "-per-project"
+	}
+
+	return id + "-per-project-region"
+}
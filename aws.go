@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerQuotaSource("aws", func(clients clientProvider, regions []string) quotaSource {
+		return newAWSQuotaSource(splitNonEmpty(*awsRegions), splitNonEmpty(*awsServices))
+	})
+}
+
+// awsQuota is a quota as returned by the service quotas api
+type awsQuota struct {
+	ServiceCode string  `json:"ServiceCode"`
+	QuotaCode   string  `json:"QuotaCode"`
+	QuotaName   string  `json:"QuotaName"`
+	Value       float64 `json:"Value"`
+	UsageMetric *struct {
+		MetricNamespace               string            `json:"MetricNamespace"`
+		MetricName                    string            `json:"MetricName"`
+		MetricDimensions              map[string]string `json:"MetricDimensions"`
+		MetricStatisticRecommendation string            `json:"MetricStatisticRecommendation"`
+	} `json:"UsageMetric"`
+}
+
+// awsQuotaSource retrieves the applied quota of aws services with the service quotas api and their usage from cloudwatch; it uses
+// the credentials from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+type awsQuotaSource struct {
+	regions  []string
+	services []string
+	client   *http.Client
+
+	accountID string
+	mutex     sync.Mutex
+}
+
+func newAWSQuotaSource(regions, services []string) *awsQuotaSource {
+	return &awsQuotaSource{
+		regions:  regions,
+		services: services,
+		client:   &http.Client{Transport: &retryTransport{base: http.DefaultTransport, policies: configuredAPIPolicies}},
+	}
+}
+
+func (s *awsQuotaSource) Name() string {
+	return "aws"
+}
+
+// Projects returns the account the credentials belong to
+func (s *awsQuotaSource) Projects(ctx context.Context) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.accountID != "" {
+		return []string{s.accountID}, nil
+	}
+
+	form := url.Values{"Action": {"GetCallerIdentity"}, "Version": {"2011-06-15"}}
+	body, err := s.call(ctx, "https://sts.amazonaws.com/", "us-east-1", "sts", "application/x-www-form-urlencoded", nil, []byte(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Retrieving aws account id failed: %v", err)
+	}
+
+	var identity struct {
+		Account string `xml:"GetCallerIdentityResult>Account"`
+	}
+	err = xml.Unmarshal(body, &identity)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing aws caller identity failed: %v", err)
+	}
+	s.accountID = identity.Account
+
+	return []string{s.accountID}, nil
+}
+
+func (s *awsQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return s.regions, nil
+}
+
+func (s *awsQuotaSource) Collect(ctx context.Context, project string, regions []string) ([]quotaUpdate, error) {
+
+	updates := []quotaUpdate{}
+	for _, region := range regions {
+		for _, service := range s.services {
+			quotas, err := s.listServiceQuotas(ctx, region, service)
+			if err != nil {
+				return updates, err
+			}
+
+			for _, quota := range quotas {
+				update := quotaUpdate{
+					Provider:  "aws",
+					Project:   project,
+					Region:    region,
+					Metric:    toMetricName(quota.ServiceCode + " " + quota.QuotaName),
+					Limit:     quota.Value,
+					Timestamp: time.Now(),
+				}
+
+				hasUsage := false
+				if quota.UsageMetric != nil && quota.UsageMetric.MetricName != "" {
+					update.Usage, hasUsage, err = s.getUsage(ctx, region, quota)
+					if err != nil {
+						return updates, err
+					}
+				}
+
+				update, ok := sanitizeQuotaUpdate(update)
+				if !ok {
+					continue
+				}
+
+				updateProviderQuota(ctx, update, hasUsage)
+				updates = append(updates, update)
+			}
+		}
+	}
+
+	return updates, nil
+}
+
+// listServiceQuotas retrieves all applied quota of the service in the region
+func (s *awsQuotaSource) listServiceQuotas(ctx context.Context, region, service string) ([]awsQuota, error) {
+
+	quotas := []awsQuota{}
+	nextToken := ""
+	tokens := pageTokens{}
+	for {
+		request := map[string]interface{}{"ServiceCode": service}
+		if nextToken != "" {
+			request["NextToken"] = nextToken
+		}
+		requestBody, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := s.call(ctx, fmt.Sprintf("https://servicequotas.%v.amazonaws.com/", region), region, "servicequotas", "application/x-amz-json-1.1",
+			map[string]string{"X-Amz-Target": "ServiceQuotasV20190624.ListServiceQuotas"}, requestBody)
+		if err != nil {
+			return nil, fmt.Errorf("Listing aws %v quota in region %v failed at page %v: %v", service, region, tokens.pages+1, err)
+		}
+
+		var response struct {
+			Quotas    []awsQuota `json:"Quotas"`
+			NextToken string     `json:"NextToken"`
+		}
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing aws %v quota in region %v failed at page %v: %v", service, region, tokens.pages+1, err)
+		}
+
+		quotas = append(quotas, response.Quotas...)
+		more, err := tokens.next(response.NextToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing aws %v quota in region %v failed: %v", service, region, err)
+		}
+		if !more {
+			return quotas, nil
+		}
+		nextToken = response.NextToken
+	}
+}
+
+// getUsage retrieves the latest value of the quota's usage metric from cloudwatch, using the recommended statistic
+func (s *awsQuotaSource) getUsage(ctx context.Context, region string, quota awsQuota) (float64, bool, error) {
+
+	statistic := quota.UsageMetric.MetricStatisticRecommendation
+	if statistic == "" {
+		statistic = "Maximum"
+	}
+
+	now := time.Now().UTC()
+	form := url.Values{
+		"Action":              {"GetMetricStatistics"},
+		"Version":             {"2010-08-01"},
+		"Namespace":           {quota.UsageMetric.MetricNamespace},
+		"MetricName":          {quota.UsageMetric.MetricName},
+		"StartTime":           {now.Add(-15 * time.Minute).Format(time.RFC3339)},
+		"EndTime":             {now.Format(time.RFC3339)},
+		"Period":              {"300"},
+		"Statistics.member.1": {statistic},
+	}
+	dimensions := []string{}
+	for name := range quota.UsageMetric.MetricDimensions {
+		dimensions = append(dimensions, name)
+	}
+	sort.Strings(dimensions)
+	for i, name := range dimensions {
+		form.Set(fmt.Sprintf("Dimensions.member.%v.Name", i+1), name)
+		form.Set(fmt.Sprintf("Dimensions.member.%v.Value", i+1), quota.UsageMetric.MetricDimensions[name])
+	}
+
+	body, err := s.call(ctx, fmt.Sprintf("https://monitoring.%v.amazonaws.com/", region), region, "monitoring", "application/x-www-form-urlencoded", nil, []byte(form.Encode()))
+	if err != nil {
+		return 0, false, fmt.Errorf("Retrieving aws usage for quota %v in region %v failed: %v", quota.QuotaCode, region, err)
+	}
+
+	var response struct {
+		Datapoints []struct {
+			Timestamp   time.Time `xml:"Timestamp"`
+			Maximum     float64   `xml:"Maximum"`
+			Minimum     float64   `xml:"Minimum"`
+			Average     float64   `xml:"Average"`
+			Sum         float64   `xml:"Sum"`
+			SampleCount float64   `xml:"SampleCount"`
+		} `xml:"GetMetricStatisticsResult>Datapoints>member"`
+	}
+	err = xml.Unmarshal(body, &response)
+	if err != nil {
+		return 0, false, fmt.Errorf("Parsing aws usage for quota %v in region %v failed: %v", quota.QuotaCode, region, err)
+	}
+
+	// no datapoints means nothing has been used recently
+	if len(response.Datapoints) == 0 {
+		return 0, true, nil
+	}
+
+	latest := response.Datapoints[0]
+	for _, datapoint := range response.Datapoints {
+		if datapoint.Timestamp.After(latest.Timestamp) {
+			latest = datapoint
+		}
+	}
+
+	switch statistic {
+	case "Minimum":
+		return latest.Minimum, true, nil
+	case "Average":
+		return latest.Average, true, nil
+	case "Sum":
+		return latest.Sum, true, nil
+	case "SampleCount":
+		return latest.SampleCount, true, nil
+	default:
+		return latest.Maximum, true, nil
+	}
+}
+
+// call sends a signed post request to an aws api and returns the response body
+func (s *awsQuotaSource) call(ctx context.Context, endpoint, region, service, contentType string, headers map[string]string, body []byte) ([]byte, error) {
+
+	credentials := awsCredentialsFromEnv()
+	if credentials == nil {
+		return nil, fmt.Errorf("No aws credentials found in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	signAWSRequest(req, body, *credentials, region, service, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Status %v: %v", resp.StatusCode, string(responseBody))
+	}
+
+	return responseBody, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSignAWSRequest signs requests of the aws signature version 4 test suite, see
+// https://docs.aws.amazon.com/general/latest/gr/signature-v4-test-suite.html
+func TestSignAWSRequest(t *testing.T) {
+
+	credentials := awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		authorization string
+	}{
+		{
+			name:          "GetVanilla",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "GetVanillaQueryOrderKeyCase",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signAWSRequest(req, nil, credentials, "us-east-1", "service", now)
+
+			if authorization := req.Header.Get("Authorization"); authorization != tt.authorization {
+				t.Errorf("Authorization header is %v, expected %v", authorization, tt.authorization)
+			}
+			if amzDate := req.Header.Get("x-amz-date"); amzDate != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date header is %v, expected 20150830T123600Z", amzDate)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const azureComputeAPIVersion = "2023-07-01"
+
+func init() {
+	registerQuotaSource("azure", func(clients clientProvider, regions []string) quotaSource {
+		return &azureQuotaSource{
+			subscriptions: splitNonEmpty(*azureSubscriptions),
+			locations:     splitNonEmpty(*azureLocations),
+		}
+	})
+}
+
+// azureQuotaSource retrieves the compute usages per subscription and location; it authenticates as the service principal from the
+// AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET environment variables
+type azureQuotaSource struct {
+	subscriptions []string
+	locations     []string
+	client        *http.Client
+}
+
+func (s *azureQuotaSource) Name() string {
+	return "azure"
+}
+
+// Projects returns the configured subscriptions
+func (s *azureQuotaSource) Projects(ctx context.Context) ([]string, error) {
+	return s.subscriptions, nil
+}
+
+func (s *azureQuotaSource) Discover(ctx context.Context, subscription string) ([]string, error) {
+	return s.locations, nil
+}
+
+func (s *azureQuotaSource) Collect(ctx context.Context, subscription string, locations []string) ([]quotaUpdate, error) {
+
+	client, err := s.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	updates := []quotaUpdate{}
+	for _, location := range locations {
+		nextLink := fmt.Sprintf("https://management.azure.com/subscriptions/%v/providers/Microsoft.Compute/locations/%v/usages?api-version=%v", subscription, location, azureComputeAPIVersion)
+
+		// the usages of a location are only applied once all pages have been retrieved, so a failing page doesn't leave a partial set
+		tokens := pageTokens{}
+		pending := []quotaUpdate{}
+		for nextLink != "" {
+			var page struct {
+				Value []struct {
+					CurrentValue float64 `json:"currentValue"`
+					Limit        float64 `json:"limit"`
+					Name         struct {
+						Value          string `json:"value"`
+						LocalizedValue string `json:"localizedValue"`
+					} `json:"name"`
+				} `json:"value"`
+				NextLink string `json:"nextLink"`
+			}
+			err := getAzureJSON(ctx, client, nextLink, &page)
+			if err != nil {
+				return updates, fmt.Errorf("Retrieving azure compute usages for subscription %v and location %v failed at page %v: %v", subscription, location, tokens.pages+1, err)
+			}
+
+			for _, usage := range page.Value {
+				name := usage.Name.LocalizedValue
+				if name == "" {
+					name = usage.Name.Value
+				}
+
+				update := quotaUpdate{
+					Provider:  "azure",
+					Project:   subscription,
+					Region:    location,
+					Metric:    toMetricName(name),
+					Limit:     usage.Limit,
+					Usage:     usage.CurrentValue,
+					Timestamp: time.Now(),
+				}
+				update, ok := sanitizeQuotaUpdate(update)
+				if !ok {
+					continue
+				}
+
+				pending = append(pending, update)
+			}
+
+			more, err := tokens.next(page.NextLink)
+			if err != nil {
+				return updates, fmt.Errorf("Retrieving azure compute usages for subscription %v and location %v failed: %v", subscription, location, err)
+			}
+			nextLink = ""
+			if more {
+				nextLink = page.NextLink
+			}
+		}
+
+		for _, update := range pending {
+			updateProviderQuota(ctx, update, true)
+		}
+		updates = append(updates, pending...)
+	}
+
+	return updates, nil
+}
+
+// httpClient creates the authenticated client on first use, so a missing service principal only fails when the collector is enabled
+func (s *azureQuotaSource) httpClient() (*http.Client, error) {
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
+	if tenantID == "" || clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("No azure service principal found in AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET")
+	}
+
+	config := clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     fmt.Sprintf("https://login.microsoftonline.com/%v/oauth2/v2.0/token", tenantID),
+		Scopes:       []string{"https://management.azure.com/.default"},
+	}
+
+	// token and api requests both go through the retrying transport
+	base := &http.Client{Transport: &retryTransport{base: http.DefaultTransport, policies: configuredAPIPolicies}}
+	s.client = config.Client(context.WithValue(context.Background(), oauth2.HTTPClient, base))
+
+	return s.client, nil
+}
+
+func getAzureJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %v: %v", resp.StatusCode, string(body))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// splitNonEmpty splits a comma-separated list, leaving out empty items
+func splitNonEmpty(list string) []string {
+
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// runBenchmark fetches synthetic quota for the number of projects and regions without calling any google cloud apis, and reports
+// the duration of fetch cycles, the memory in use and the latency and size of scrapes, to size the exporter before pointing it
+// at a large organization
+func runBenchmark(ctx context.Context, projectCount, regionCount, cycles int, w io.Writer) error {
+
+	computeClient, projects, regions, err := newSimulatedComputeClient(projectCount, regionCount, *simulateDrift)
+	if err != nil {
+		return err
+	}
+
+	clients := newStaticClientManager(computeClient)
+	quotaSources, err := newQuotaSources("compute", clients, regions)
+	if err != nil {
+		return err
+	}
+
+	// per project logging would dominate the measurements
+	log.Logger = log.Logger.Level(zerolog.WarnLevel)
+
+	fmt.Fprintf(w, "Benchmarking %v projects x %v regions for %v cycles\n\n", len(projects), len(regions), cycles)
+
+	handler := promhttp.HandlerFor(metricsGatherer, promhttp.HandlerOpts{})
+	for cycle := 1; cycle <= cycles; cycle++ {
+
+		start := time.Now()
+		fetchQuota(ctx, clients, quotaSources, projects)
+		cycleDuration := time.Since(start)
+
+		runtime.GC()
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+
+		// scrape a few times, since a single scrape is too noisy to go by
+		const scrapes = 5
+		var scrapeDuration time.Duration
+		size, series := 0, 0
+		for i := 0; i < scrapes; i++ {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+			scrapeStart := time.Now()
+			handler.ServeHTTP(recorder, request)
+			scrapeDuration += time.Since(scrapeStart)
+
+			body := recorder.Body.String()
+			size = len(body)
+			series = 0
+			for _, line := range strings.Split(body, "\n") {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					series++
+				}
+			}
+		}
+
+		fmt.Fprintf(w, "cycle %v: fetch %v, heap in use %.1f MiB, scrape %v, exposition %.1f KiB with %v series\n",
+			cycle, cycleDuration.Round(time.Millisecond), float64(memStats.HeapInuse)/(1<<20), (scrapeDuration / scrapes).Round(time.Microsecond), float64(size)/(1<<10), series)
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the slots committed in and assigned from reservations of the project
+	bigQueryCommittedSlots = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigquery_committed_slots",
+		Help: "The number of slots of active capacity commitments per bigquery location.",
+	}, []string{"project", "location"})
+
+	bigQueryReservationSlots = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigquery_reservation_slots",
+		Help: "The baseline number of slots of a bigquery reservation.",
+	}, []string{"project", "location", "reservation"})
+
+	bigQueryReservationAssignments = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigquery_reservation_assignments",
+		Help: "The number of projects, folders or organizations assigned to a bigquery reservation.",
+	}, []string{"project", "location", "reservation"})
+
+	// create gauges for the job limits of bigquery and the jobs counting against them
+	bigQueryJobLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigquery_job_limit",
+		Help: "The bigquery job limit for concurrent_queries or load_jobs_per_day, as set with --bigquery-concurrent-query-limit and --bigquery-load-job-limit.",
+	}, []string{"project", "metric"})
+
+	bigQueryJobUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigquery_job_usage",
+		Help: "The number of running query jobs for concurrent_queries, or load jobs created in the last 24 hours for load_jobs_per_day.",
+	}, []string{"project", "metric"})
+)
+
+func init() {
+	prometheus.MustRegister(bigQueryCommittedSlots)
+	prometheus.MustRegister(bigQueryReservationSlots)
+	prometheus.MustRegister(bigQueryReservationAssignments)
+	prometheus.MustRegister(bigQueryJobLimit)
+	prometheus.MustRegister(bigQueryJobUsage)
+
+	registerQuotaSource("bigquery", func(clients clientProvider, regions []string) quotaSource {
+		return &bigQueryQuotaSource{
+			clients:             clients,
+			locations:           splitNonEmpty(*bigQueryLocations),
+			concurrentQueries:   *bigQueryQueryLimit,
+			loadJobsPerDayLimit: *bigQueryLoadJobLimit,
+		}
+	})
+}
+
+// bigQueryQuotaSource exports the slots of bigquery reservations and the number of running queries and daily load jobs against their
+// limits, which the bigquery api doesn't report; the limits are taken from flags instead
+type bigQueryQuotaSource struct {
+	clients clientProvider
+
+	// bigquery locations like US or europe-west1 to retrieve reservations for; they're separate from compute regions
+	locations []string
+
+	concurrentQueries   int
+	loadJobsPerDayLimit int
+}
+
+func (s *bigQueryQuotaSource) Name() string {
+	return "bigquery"
+}
+
+func (s *bigQueryQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return s.locations, nil
+}
+
+func (s *bigQueryQuotaSource) Permissions() []string {
+	return []string{"bigquery.jobs.listAll", "bigquery.reservations.list", "bigquery.capacityCommitments.list", "bigquery.reservationAssignments.list"}
+}
+
+func (s *bigQueryQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, location := range locations {
+		reservationsEnabled, err := s.collectSlots(ctx, httpClient, project, location)
+		if err != nil {
+			return nil, err
+		}
+		// the reservation api is only enabled in projects administering reservations, so the other locations are skipped as well
+		if !reservationsEnabled {
+			break
+		}
+	}
+
+	// jobs of the last day cover both the running queries and the daily load jobs; queries can't run for more than 6 hours
+	runningQueries := 0.0
+	loadJobs := 0.0
+	minCreationTime := time.Now().Add(-24 * time.Hour)
+
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("allUsers", "true")
+		query.Set("projection", "minimal")
+		query.Set("maxResults", "1000")
+		query.Set("minCreationTime", strconv.FormatInt(minCreationTime.UnixNano()/int64(time.Millisecond), 10))
+		query.Set("fields", "jobs(state,configuration/jobType),nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Jobs []struct {
+				State         string `json:"state"`
+				Configuration struct {
+					JobType string `json:"jobType"`
+				} `json:"configuration"`
+			} `json:"jobs"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%v/jobs?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, job := range response.Jobs {
+			switch job.Configuration.JobType {
+			case "QUERY":
+				if job.State == "RUNNING" {
+					runningQueries++
+				}
+			case "LOAD":
+				loadJobs++
+			}
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing bigquery jobs failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	setGauge(ctx, bigQueryJobLimit, float64(s.concurrentQueries), project, "concurrent_queries")
+	setGauge(ctx, bigQueryJobUsage, runningQueries, project, "concurrent_queries")
+	setGauge(ctx, bigQueryJobLimit, float64(s.loadJobsPerDayLimit), project, "load_jobs_per_day")
+	setGauge(ctx, bigQueryJobUsage, loadJobs, project, "load_jobs_per_day")
+
+	// bigquery limits aren't compute quota, so they're only exported as metrics
+	return nil, nil
+}
+
+// collectSlots exports the capacity commitments and reservations of a location; it returns false if the reservation api isn't enabled
+// in the project, which is the case for all projects that don't administer reservations
+func (s *bigQueryQuotaSource) collectSlots(ctx context.Context, httpClient *http.Client, project, location string) (bool, error) {
+
+	parent := fmt.Sprintf("https://bigqueryreservation.googleapis.com/v1/projects/%v/locations/%v", project, location)
+
+	committedSlots := 0.0
+	err := listBigQueryReservationPages(func(query url.Values) (string, error) {
+		var response struct {
+			CapacityCommitments []struct {
+				SlotCount string `json:"slotCount"`
+				State     string `json:"state"`
+			} `json:"capacityCommitments"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/capacityCommitments?%v", parent, query.Encode()), &response)
+		if err != nil {
+			return "", err
+		}
+		for _, commitment := range response.CapacityCommitments {
+			if commitment.State != "ACTIVE" {
+				continue
+			}
+			if slots, err := strconv.ParseFloat(commitment.SlotCount, 64); err == nil {
+				committedSlots += slots
+			}
+		}
+		return response.NextPageToken, nil
+	})
+	if isServiceDisabledError(err, "bigqueryreservation.googleapis.com", "BigQuery Reservation API") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	setGauge(ctx, bigQueryCommittedSlots, committedSlots, project, location)
+
+	reservations := []string{}
+	err = listBigQueryReservationPages(func(query url.Values) (string, error) {
+		var response struct {
+			Reservations []struct {
+				Name         string `json:"name"`
+				SlotCapacity string `json:"slotCapacity"`
+			} `json:"reservations"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/reservations?%v", parent, query.Encode()), &response)
+		if err != nil {
+			return "", err
+		}
+		for _, reservation := range response.Reservations {
+			id := reservation.Name[strings.LastIndex(reservation.Name, "/")+1:]
+			reservations = append(reservations, id)
+
+			slots, _ := strconv.ParseFloat(reservation.SlotCapacity, 64)
+			setGauge(ctx, bigQueryReservationSlots, slots, project, location, id)
+		}
+		return response.NextPageToken, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, reservation := range reservations {
+		assignments := 0.0
+		err = listBigQueryReservationPages(func(query url.Values) (string, error) {
+			var response struct {
+				Assignments []struct {
+					Name string `json:"name"`
+				} `json:"assignments"`
+				NextPageToken string `json:"nextPageToken"`
+			}
+			err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("%v/reservations/%v/assignments?%v", parent, reservation, query.Encode()), &response)
+			if err != nil {
+				return "", err
+			}
+			assignments += float64(len(response.Assignments))
+			return response.NextPageToken, nil
+		})
+		if err != nil {
+			return false, err
+		}
+		setGauge(ctx, bigQueryReservationAssignments, assignments, project, location, reservation)
+	}
+
+	return true, nil
+}
+
+// listBigQueryReservationPages calls list for every page of a reservation api list call, until it returns no next page token
+func listBigQueryReservationPages(list func(query url.Values) (string, error)) error {
+
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("pageSize", "100")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		nextPageToken, err := list(query)
+		if err != nil {
+			return err
+		}
+
+		more, err := tokens.next(nextPageToken)
+		if err != nil {
+			return fmt.Errorf("Listing bigquery reservations failed: %v", err)
+		}
+		if !more {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the nodes of bigtable clusters per zone and storage type and the quota they count against
+	bigtableNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigtable_nodes",
+		Help: "The serving nodes of all bigtable clusters per zone and storage type, ssd or hdd.",
+	}, []string{"project", "zone", "storage_type"})
+
+	bigtableNodesLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigtable_nodes_limit",
+		Help: "The bigtable node quota per zone and storage type, as set with --bigtable-node-limit.",
+	}, []string{"project", "zone", "storage_type"})
+
+	// create gauge for the nodes of each cluster, to see which cluster consumes the quota
+	bigtableClusterNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_bigtable_cluster_nodes",
+		Help: "The serving nodes of a bigtable cluster.",
+	}, []string{"project", "zone", "storage_type", "instance", "cluster"})
+)
+
+func init() {
+	prometheus.MustRegister(bigtableNodes)
+	prometheus.MustRegister(bigtableNodesLimit)
+	prometheus.MustRegister(bigtableClusterNodes)
+
+	registerQuotaSource("bigtable", func(clients clientProvider, regions []string) quotaSource {
+		return &bigtableQuotaSource{clients: clients, regions: regions, nodeLimit: *bigtableNodeLimit}
+	})
+}
+
+// bigtableQuotaSource exports the serving nodes of bigtable clusters against the node quota per zone; like for spanner the admin api
+// doesn't report the quota, so it's taken from a flag
+type bigtableQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// the node quota per zone and storage type; the limit isn't exported if it's 0
+	nodeLimit int
+}
+
+// bigtableNodesKey identifies the nodes of a zone with a storage type, which have a quota of their own
+type bigtableNodesKey struct {
+	zone        string
+	storageType string
+}
+
+func (s *bigtableQuotaSource) Name() string {
+	return "bigtable"
+}
+
+// Discover doesn't return locations, clusters of all instances are listed at once
+func (s *bigtableQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *bigtableQuotaSource) Permissions() []string {
+	return []string{"bigtable.clusters.list"}
+}
+
+func (s *bigtableQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't undercount the nodes
+	type cluster struct {
+		Name               string `json:"name"`
+		Location           string `json:"location"`
+		ServeNodes         int64  `json:"serveNodes"`
+		DefaultStorageType string `json:"defaultStorageType"`
+	}
+	clusters := []cluster{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Clusters        []cluster `json:"clusters"`
+			FailedLocations []string  `json:"failedLocations"`
+			NextPageToken   string    `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://bigtableadmin.googleapis.com/v2/projects/%v/instances/-/clusters?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		// clusters in failed locations are left out of the list, which would report their zones with fewer serving nodes than they
+		// have, so the whole list is discarded instead
+		if len(response.FailedLocations) > 0 {
+			return nil, fmt.Errorf("Listing bigtable clusters for project %v returned a partial list, locations %v are missing", project, strings.Join(response.FailedLocations, ", "))
+		}
+		clusters = append(clusters, response.Clusters...)
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing bigtable clusters failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	perZone := map[bigtableNodesKey]float64{}
+	for _, c := range clusters {
+		// names are like projects/p/instances/i/clusters/c and locations like projects/p/locations/europe-west1-b
+		segments := strings.Split(c.Name, "/")
+		if len(segments) != 6 {
+			return nil, fmt.Errorf("Parsing bigtable cluster name %v failed", c.Name)
+		}
+		zone := c.Location[strings.LastIndex(c.Location, "/")+1:]
+		if len(s.regions) > 0 && !stringInSlice(s.regions, regionFromLocation(zone)) {
+			continue
+		}
+		storageType := strings.ToLower(c.DefaultStorageType)
+
+		perZone[bigtableNodesKey{zone: zone, storageType: storageType}] += float64(c.ServeNodes)
+		setGauge(ctx, bigtableClusterNodes, float64(c.ServeNodes), project, zone, storageType, segments[3], segments[5])
+	}
+
+	for key, nodes := range perZone {
+		setGauge(ctx, bigtableNodes, nodes, project, key.zone, key.storageType)
+		if s.nodeLimit > 0 {
+			setGauge(ctx, bigtableNodesLimit, float64(s.nodeLimit), project, key.zone, key.storageType)
+		}
+	}
+
+	// bigtable capacity isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
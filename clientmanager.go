@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// tokenReloadInterval is the minimum time between rebuilding clients for the same credentials after token errors
+const tokenReloadInterval = time.Minute
+
+// googleClients are the clients created for a single set of credentials
+type googleClients struct {
+	compute computeClient
+
+	// http is the authenticated client the api specific clients are created from; nil for injected clients
+	http *http.Client
+
+	// err is why the clients couldn't be created when starting lazily; they're replaced once the credentials can be loaded
+	err error
+}
+
+// credentialsUnavailableError is returned for projects whose credentials couldn't be loaded when starting lazily
+type credentialsUnavailableError struct {
+	credentialsFile string
+	err             error
+}
+
+func (e *credentialsUnavailableError) Error() string {
+	if e.credentialsFile == "" {
+		return fmt.Sprintf("Application default credentials are unavailable: %v", e.err)
+	}
+	return fmt.Sprintf("Credentials %v are unavailable: %v", e.credentialsFile, e.err)
+}
+
+func isCredentialsUnavailableError(err error) bool {
+	_, ok := err.(*credentialsUnavailableError)
+	return ok
+}
+
+// newUnavailableClients stands in for clients that couldn't be created, failing all calls with the reason
+func newUnavailableClients(credentialsFile string, err error) *googleClients {
+	unavailableErr := &credentialsUnavailableError{credentialsFile: credentialsFile, err: err}
+	return &googleClients{
+		compute: &unavailableComputeClient{err: unavailableErr},
+		err:     unavailableErr,
+	}
+}
+
+// clientProvider hands out the google cloud clients to use for a project; users get them for every call instead of holding on
+// to them, so they never use clients that are being replaced
+type clientProvider interface {
+	compute(project string) computeClient
+	httpClient(project string) (*http.Client, error)
+
+	// handleError rebuilds the clients of a project if an api call failed because its token couldn't be used
+	handleError(ctx context.Context, project string, err error)
+}
+
+// clientManager owns the google cloud api clients and swaps them safely when credentials are rotated
+type clientManager struct {
+	// clients for the application default credentials, used for all projects not bound to a credential source
+	defaultClients *googleClients
+
+	// clients per credential source, keyed by credentials file
+	sourceClients  map[string]*googleClients
+	projectSources map[string]string
+
+	// static managers hold injected clients that aren't backed by credentials
+	static bool
+
+	// afterReload is called after clients have been recreated, e.g. to check their permissions
+	afterReload func(ctx context.Context)
+
+	// lastTokenReload limits rebuilding clients after token errors to once per tokenReloadInterval per credentials file
+	lastTokenReload map[string]time.Time
+
+	mutex sync.RWMutex
+}
+
+// newClientManager creates the initial clients; failing here is fatal to the caller since there's nothing to fall back to, unless
+// starting lazily, in which case projects using credentials that can't be loaded yet fail until the credentials are reloaded
+func newClientManager(ctx context.Context, sources []credentialSource, useDefaultCredentials, lazy bool) (*clientManager, error) {
+
+	m := &clientManager{
+		sourceClients:   map[string]*googleClients{},
+		projectSources:  map[string]string{},
+		lastTokenReload: map[string]time.Time{},
+	}
+
+	if useDefaultCredentials {
+		clients, err := newGoogleClients(ctx, "")
+		if err != nil {
+			if !lazy {
+				return nil, err
+			}
+			log.Error().Err(err).Msg("Creating google cloud clients for the application default credentials failed, starting without them")
+			clients = newUnavailableClients("", err)
+		}
+		m.defaultClients = clients
+	}
+
+	for _, source := range sources {
+		clients, err := newGoogleClients(ctx, source.File)
+		if err != nil {
+			if !lazy {
+				return nil, err
+			}
+			log.Error().Err(err).Msgf("Creating google cloud clients for credentials %v failed, starting without them", source.File)
+			clients = newUnavailableClients(source.File, err)
+		}
+		m.sourceClients[source.File] = clients
+		for _, project := range source.Projects {
+			m.projectSources[project] = source.File
+		}
+	}
+
+	return m, nil
+}
+
+// newStaticClientManager uses the compute client for all projects, e.g. a fake one; it can't be reloaded
+func newStaticClientManager(client computeClient) *clientManager {
+	return &clientManager{
+		defaultClients:  &googleClients{compute: client},
+		sourceClients:   map[string]*googleClients{},
+		projectSources:  map[string]string{},
+		lastTokenReload: map[string]time.Time{},
+		static:          true,
+	}
+}
+
+// clients returns the clients to use for the project; callers should get them once per fetch cycle instead of holding on to them
+func (m *clientManager) clients(project string) *googleClients {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if file, ok := m.projectSources[project]; ok {
+		return m.sourceClients[file]
+	}
+
+	return m.defaultClients
+}
+
+// compute returns the compute client to use for the project
+func (m *clientManager) compute(project string) computeClient {
+
+	clients := m.clients(project)
+	if clients == nil || clients.compute == nil {
+		return &unavailableComputeClient{err: fmt.Errorf("No compute client available for project %v", project)}
+	}
+
+	return clients.compute
+}
+
+// httpClient returns the authenticated http client to create other api clients from for the project; it fails for injected clients
+func (m *clientManager) httpClient(project string) (*http.Client, error) {
+
+	clients := m.clients(project)
+	if clients != nil && clients.err != nil {
+		return nil, clients.err
+	}
+	if clients == nil || clients.http == nil {
+		return nil, fmt.Errorf("No authenticated google cloud client available for project %v", project)
+	}
+
+	return clients.http, nil
+}
+
+// reload builds new clients for the credentials file - or the application default credentials if empty - without holding the lock
+// and only swaps them in if that succeeded, keeping the old clients otherwise
+func (m *clientManager) reload(ctx context.Context, credentialsFile string) {
+
+	if m.static {
+		log.Info().Msg("Google cloud clients are static, skipping reload")
+		return
+	}
+
+	clients, err := newGoogleClients(ctx, credentialsFile)
+	if err != nil {
+		log.Error().Err(err).Msgf("Recreating google cloud clients after change to credentials %v failed, keeping current clients", credentialsFile)
+		return
+	}
+
+	m.mutex.Lock()
+	if credentialsFile == "" {
+		m.defaultClients = clients
+	} else {
+		m.sourceClients[credentialsFile] = clients
+	}
+	m.mutex.Unlock()
+
+	log.Info().Msgf("Recreated google cloud clients after change to credentials %v", credentialsFile)
+
+	m.mutex.RLock()
+	afterReload := m.afterReload
+	m.mutex.RUnlock()
+	if afterReload != nil {
+		afterReload(ctx)
+	}
+}
+
+// onReload sets the function to call after clients have been recreated; file watches may already be reloading at that point
+func (m *clientManager) onReload(afterReload func(ctx context.Context)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.afterReload = afterReload
+}
+
+// handleError rebuilds the clients for the project's credentials if the call failed on its token, e.g. because the token source
+// got stuck on a revoked key, at most once per tokenReloadInterval
+func (m *clientManager) handleError(ctx context.Context, project string, err error) {
+
+	if m.static || !isTokenError(err) {
+		return
+	}
+
+	m.mutex.Lock()
+	credentialsFile := m.projectSources[project]
+	if time.Since(m.lastTokenReload[credentialsFile]) < tokenReloadInterval {
+		m.mutex.Unlock()
+		return
+	}
+	m.lastTokenReload[credentialsFile] = time.Now()
+	m.mutex.Unlock()
+
+	log.Warn().Err(err).Msgf("Api call for project %v failed on its token, recreating google cloud clients", project)
+	m.reload(ctx, credentialsFile)
+}
+
+// isTokenError checks whether a call failed because no valid token could be retrieved or the token was rejected
+func isTokenError(err error) bool {
+
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if _, ok := err.(*oauth2.RetrieveError); ok {
+		return true
+	}
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// reloadAll rebuilds the clients for the default credentials, if in use, and all credential sources
+func (m *clientManager) reloadAll(ctx context.Context, sources []credentialSource) {
+
+	m.mutex.RLock()
+	useDefaultCredentials := m.defaultClients != nil
+	m.mutex.RUnlock()
+
+	if useDefaultCredentials {
+		m.reload(ctx, "")
+	}
+	for _, source := range sources {
+		m.reload(ctx, source.File)
+	}
+}
+
+func newGoogleClients(ctx context.Context, credentialsFile string) (*googleClients, error) {
+
+	client, err := newGoogleClient(ctx, credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	computeService, err := compute.New(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &googleClients{
+		compute: &googleComputeClient{service: computeService},
+		http:    client,
+	}, nil
+}
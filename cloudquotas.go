@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the effective limit and usage of quota as reported by the cloud quotas api
+	cloudQuotasLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudquotas_limit",
+		Help: "The effective limit of a quota as reported by the cloud quotas api, with region global for quota that isn't regional; -1 is unlimited.",
+	}, []string{"project", "service", "quota_id", "metric", "unit", "region"})
+
+	cloudQuotasUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudquotas_usage",
+		Help: "The usage of an allocation quota of the cloud quotas api as last reported to cloud monitoring; rate quota usage isn't exported.",
+	}, []string{"project", "service", "quota_id", "metric", "unit", "region"})
+
+	// create gauge for whether an increase can be requested for a quota
+	cloudQuotasAdjustable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudquotas_adjustable",
+		Help: "Whether an increase of the quota can be requested (1) or the quota is fixed or ineligible (0).",
+	}, []string{"project", "service", "quota_id"})
+)
+
+func init() {
+	prometheus.MustRegister(cloudQuotasLimit)
+	prometheus.MustRegister(cloudQuotasUsage)
+	prometheus.MustRegister(cloudQuotasAdjustable)
+
+	registerQuotaSource("cloudquotas", func(clients clientProvider, regions []string) quotaSource {
+		return &cloudQuotasQuotaSource{clients: clients, regions: regions, services: splitNonEmpty(*cloudQuotasServices)}
+	})
+}
+
+// cloudQuotasQuotaSource retrieves the quota infos of the cloud quotas api, which has the authoritative effective limit and whether
+// it's adjustable for services the compute api doesn't report on; the api doesn't report usage, so the usage of allocation quota is
+// taken from cloud monitoring like the service-usage collector does
+type cloudQuotasQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// services to retrieve quota infos for; all enabled services if empty
+	services []string
+}
+
+func (s *cloudQuotasQuotaSource) Name() string {
+	return "cloudquotas"
+}
+
+// Discover doesn't return locations, quota infos have the values of all locations
+func (s *cloudQuotasQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *cloudQuotasQuotaSource) Permissions() []string {
+	return []string{"cloudquotas.quotas.get", "serviceusage.services.list", "monitoring.timeSeries.list"}
+}
+
+func (s *cloudQuotasQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	services := s.services
+	if len(services) == 0 {
+		services, err = listEnabledServices(ctx, httpClient, project)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	usage, err := listAllocationUsage(ctx, httpClient, project)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range services {
+
+		tokens := pageTokens{}
+		pageToken := ""
+		for {
+			query := url.Values{}
+			query.Set("pageSize", "100")
+			if pageToken != "" {
+				query.Set("pageToken", pageToken)
+			}
+
+			var response struct {
+				QuotaInfos []struct {
+					QuotaID                  string `json:"quotaId"`
+					Metric                   string `json:"metric"`
+					MetricUnit               string `json:"metricUnit"`
+					IsFixed                  bool   `json:"isFixed"`
+					QuotaIncreaseEligibility struct {
+						IsEligible bool `json:"isEligible"`
+					} `json:"quotaIncreaseEligibility"`
+					DimensionsInfos []struct {
+						Dimensions map[string]string `json:"dimensions"`
+						Details    struct {
+							Value string `json:"value"`
+						} `json:"details"`
+					} `json:"dimensionsInfos"`
+				} `json:"quotaInfos"`
+				NextPageToken string `json:"nextPageToken"`
+			}
+			err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://cloudquotas.googleapis.com/v1/projects/%v/locations/global/services/%v/quotaInfos?%v", project, service, query.Encode()), &response)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, info := range response.QuotaInfos {
+				adjustable := 0.0
+				if info.QuotaIncreaseEligibility.IsEligible && !info.IsFixed {
+					adjustable = 1
+				}
+				setGauge(ctx, cloudQuotasAdjustable, adjustable, project, service, info.QuotaID)
+
+				for _, dimensionsInfo := range info.DimensionsInfos {
+					region, ok := quotaDimensionsRegion(dimensionsInfo.Dimensions, s.regions)
+					if !ok {
+						continue
+					}
+
+					limit, err := strconv.ParseInt(dimensionsInfo.Details.Value, 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("Parsing value %v of quota %v of service %v failed: %v", dimensionsInfo.Details.Value, info.QuotaID, service, err)
+					}
+					value, ok := sanitizeQuotaValue("gcloud", project, info.Metric, "limit", float64(limit))
+					if !ok {
+						continue
+					}
+					setGauge(ctx, cloudQuotasLimit, value, project, service, info.QuotaID, info.Metric, info.MetricUnit, region)
+
+					if used, ok := usage[serviceQuotaKey{service: service, quotaMetric: info.Metric, region: region}]; ok {
+						setGauge(ctx, cloudQuotasUsage, used, project, service, info.QuotaID, info.Metric, info.MetricUnit, region)
+					}
+				}
+			}
+
+			more, err := tokens.next(response.NextPageToken)
+			if err != nil {
+				return nil, fmt.Errorf("Listing quota infos of service %v failed: %v", service, err)
+			}
+			if !more {
+				break
+			}
+			pageToken = response.NextPageToken
+		}
+	}
+
+	// like the service-usage collector, quota of other services is only exported as metrics
+	return nil, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cloudSQLMaxDiskSizeGB is the largest disk a cloud sql instance can have, which applies when automatic storage increases aren't
+// limited
+const cloudSQLMaxDiskSizeGB = 65536
+
+var (
+	// create gauges for the number of cloud sql instances and the per project instance quota
+	cloudSQLInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudsql_instances",
+		Help: "The number of cloud sql instances per region, including read replicas, which count against the instance quota as well.",
+	}, []string{"project", "region"})
+
+	cloudSQLInstancesLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudsql_instances_limit",
+		Help: "The maximum number of cloud sql instances in a project, as set with --cloudsql-instance-limit.",
+	}, []string{"project"})
+
+	// create gauges for the disk size of each instance and the size it can grow to
+	cloudSQLDiskSizeGB = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudsql_disk_size_gb",
+		Help: "The provisioned disk size of a cloud sql instance.",
+	}, []string{"project", "region", "instance"})
+
+	cloudSQLDiskSizeLimitGB = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudsql_disk_size_limit_gb",
+		Help: "The disk size a cloud sql instance can grow to: the automatic storage increase limit if set, the maximum disk size otherwise, or the provisioned size if storage isn't increased automatically.",
+	}, []string{"project", "region", "instance"})
+
+	// create gauge for the connection limit of instances that set one
+	cloudSQLMaxConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_cloudsql_max_connections",
+		Help: "The max_connections database flag of a cloud sql instance; instances using the default for their machine type aren't exported.",
+	}, []string{"project", "region", "instance"})
+)
+
+func init() {
+	prometheus.MustRegister(cloudSQLInstances)
+	prometheus.MustRegister(cloudSQLInstancesLimit)
+	prometheus.MustRegister(cloudSQLDiskSizeGB)
+	prometheus.MustRegister(cloudSQLDiskSizeLimitGB)
+	prometheus.MustRegister(cloudSQLMaxConnections)
+
+	registerQuotaSource("cloudsql", func(clients clientProvider, regions []string) quotaSource {
+		return &cloudSQLQuotaSource{clients: clients, regions: regions, instanceLimit: *cloudSQLInstanceLimit}
+	})
+}
+
+// cloudSQLInstance is the part of a cloud sql instance of the sql admin api the cloudsql collector uses
+type cloudSQLInstance struct {
+	Name     string `json:"name"`
+	Region   string `json:"region"`
+	Settings struct {
+		DataDiskSizeGb         string `json:"dataDiskSizeGb"`
+		StorageAutoResize      bool   `json:"storageAutoResize"`
+		StorageAutoResizeLimit string `json:"storageAutoResizeLimit"`
+		DatabaseFlags          []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"databaseFlags"`
+	} `json:"settings"`
+}
+
+// cloudSQLQuotaSource exports the number of cloud sql instances against the per project instance quota, which the sql admin api doesn't
+// report, and the disk and connection limits of each instance
+type cloudSQLQuotaSource struct {
+	clients       clientProvider
+	regions       []string
+	instanceLimit int
+}
+
+func (s *cloudSQLQuotaSource) Name() string {
+	return "cloudsql"
+}
+
+// Discover doesn't return locations, instances in all regions are listed at once
+func (s *cloudSQLQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *cloudSQLQuotaSource) Permissions() []string {
+	return []string{"cloudsql.instances.list"}
+}
+
+func (s *cloudSQLQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't undercount the instances
+	instances := []cloudSQLInstance{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("fields", "items(name,region,settings(dataDiskSizeGb,storageAutoResize,storageAutoResizeLimit,databaseFlags)),nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Items         []cloudSQLInstance `json:"items"`
+			NextPageToken string             `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://sqladmin.googleapis.com/v1/projects/%v/instances?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, response.Items...)
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing cloud sql instances failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	// the quota is per project, so instances in regions that aren't configured still count towards it
+	setGauge(ctx, cloudSQLInstancesLimit, float64(s.instanceLimit), project)
+
+	perRegion := map[string]float64{}
+	for _, instance := range instances {
+		perRegion[instance.Region]++
+		if len(s.regions) > 0 && !stringInSlice(s.regions, instance.Region) {
+			continue
+		}
+
+		diskSize, err := strconv.ParseFloat(instance.Settings.DataDiskSizeGb, 64)
+		if err == nil {
+			setGauge(ctx, cloudSQLDiskSizeGB, diskSize, project, instance.Region, instance.Name)
+
+			diskSizeLimit := diskSize
+			if instance.Settings.StorageAutoResize {
+				diskSizeLimit = cloudSQLMaxDiskSizeGB
+				if limit, err := strconv.ParseFloat(instance.Settings.StorageAutoResizeLimit, 64); err == nil && limit > 0 {
+					diskSizeLimit = limit
+				}
+			}
+			setGauge(ctx, cloudSQLDiskSizeLimitGB, diskSizeLimit, project, instance.Region, instance.Name)
+		}
+
+		for _, flag := range instance.Settings.DatabaseFlags {
+			if flag.Name != "max_connections" {
+				continue
+			}
+			if maxConnections, err := strconv.ParseFloat(flag.Value, 64); err == nil {
+				setGauge(ctx, cloudSQLMaxConnections, maxConnections, project, instance.Region, instance.Name)
+			}
+		}
+	}
+
+	for region, count := range perRegion {
+		setGauge(ctx, cloudSQLInstances, count, project, region)
+	}
+
+	// the instance quota isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
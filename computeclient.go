@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// computeClient is the part of the compute engine api used for retrieving quota, so it can be replaced by a fake; GetProject and
+// ListRegions report whether the response is unchanged since the previous call, so the quota doesn't have to be exported again
+type computeClient interface {
+	GetProject(ctx context.Context, project string) (p *compute.Project, notModified bool, err error)
+	GetRegion(ctx context.Context, project, region string) (*compute.Region, error)
+	ListRegions(ctx context.Context, project string) (regions []*compute.Region, notModified bool, err error)
+
+	// the aggregated lists return the resources of all zones and regions, keyed by scope like zones/europe-west1-b
+	ListInstances(ctx context.Context, project string) (map[string][]*compute.Instance, error)
+	ListDisks(ctx context.Context, project string) (map[string][]*compute.Disk, error)
+	ListAddresses(ctx context.Context, project string) (map[string][]*compute.Address, error)
+	GetMachineType(ctx context.Context, project, zone, machineType string) (*compute.MachineType, error)
+}
+
+// googleComputeClient calls the compute engine api
+type googleComputeClient struct {
+	service *compute.Service
+}
+
+// GetProject returns only the quota of the project; the rest of the project resource, like its metadata, can be large and isn't used;
+// the last response is cached with its etag, so unchanged quota is served from the cache without deserializing it again
+func (c *googleComputeClient) GetProject(ctx context.Context, project string) (*compute.Project, bool, error) {
+
+	key := "projects.get/" + project
+	p, err := c.service.Projects.Get(project).Fields("quotas").IfNoneMatch(computeETags.etag(key)).Context(ctx).Do()
+	if googleapi.IsNotModified(err) {
+		if cached, ok := computeETags.hit("projects.get", key); ok {
+			return cached.(*compute.Project), true, nil
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	computeETags.set(key, p.Header.Get("Etag"), p)
+
+	return p, false, nil
+}
+
+// GetRegion returns only the name and quota of the region, cached with its etag like GetProject
+func (c *googleComputeClient) GetRegion(ctx context.Context, project, region string) (*compute.Region, error) {
+
+	key := "regions.get/" + project + "/" + region
+	r, err := c.service.Regions.Get(project, region).Fields("name", "quotas").IfNoneMatch(computeETags.etag(key)).Context(ctx).Do()
+	if googleapi.IsNotModified(err) {
+		if cached, ok := computeETags.hit("regions.get", key); ok {
+			return cached.(*compute.Region), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	computeETags.set(key, r.Header.Get("Etag"), r)
+
+	return r, nil
+}
+
+// ListRegions returns all regions available to the project with their quota, following page tokens; a list that fits on a single
+// page is cached with its etag like GetProject
+func (c *googleComputeClient) ListRegions(ctx context.Context, project string) ([]*compute.Region, bool, error) {
+
+	key := "regions.list/" + project
+	list := func() *compute.RegionsListCall {
+		return c.service.Regions.List(project).Fields("items/name", "items/quotas", "nextPageToken")
+	}
+
+	page, err := list().IfNoneMatch(computeETags.etag(key)).Context(ctx).Do()
+	if googleapi.IsNotModified(err) {
+		if cached, ok := computeETags.hit("regions.list", key); ok {
+			return cached.([]*compute.Region), true, nil
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	regions := page.Items
+	etag := page.Header.Get("Etag")
+	tokens := pageTokens{}
+	for {
+		more, err := tokens.next(page.NextPageToken)
+		if err != nil {
+			return nil, false, err
+		}
+		if !more {
+			break
+		}
+
+		// the etag only covers the first page, so a multi page list can't be requested conditionally
+		etag = ""
+		page, err = list().PageToken(page.NextPageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, false, err
+		}
+		regions = append(regions, page.Items...)
+	}
+	computeETags.set(key, etag, regions)
+
+	return regions, false, nil
+}
+
+// ListInstances returns the name, machine type and status of all instances per scope
+func (c *googleComputeClient) ListInstances(ctx context.Context, project string) (map[string][]*compute.Instance, error) {
+
+	instances := map[string][]*compute.Instance{}
+	err := c.service.Instances.AggregatedList(project).Fields("items/*/instances/name", "items/*/instances/machineType", "items/*/instances/status", "nextPageToken").Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		for scope, list := range page.Items {
+			instances[scope] = append(instances[scope], list.Instances...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+// ListDisks returns the size and type of all disks per scope
+func (c *googleComputeClient) ListDisks(ctx context.Context, project string) (map[string][]*compute.Disk, error) {
+
+	disks := map[string][]*compute.Disk{}
+	err := c.service.Disks.AggregatedList(project).Fields("items/*/disks/sizeGb", "items/*/disks/type", "nextPageToken").Pages(ctx, func(page *compute.DiskAggregatedList) error {
+		for scope, list := range page.Items {
+			disks[scope] = append(disks[scope], list.Disks...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return disks, nil
+}
+
+// ListAddresses returns the address type of all addresses per scope
+func (c *googleComputeClient) ListAddresses(ctx context.Context, project string) (map[string][]*compute.Address, error) {
+
+	addresses := map[string][]*compute.Address{}
+	err := c.service.Addresses.AggregatedList(project).Fields("items/*/addresses/addressType", "nextPageToken").Pages(ctx, func(page *compute.AddressAggregatedList) error {
+		for scope, list := range page.Items {
+			addresses[scope] = append(addresses[scope], list.Addresses...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+// GetMachineType returns only the vcpus of the machine type
+func (c *googleComputeClient) GetMachineType(ctx context.Context, project, zone, machineType string) (*compute.MachineType, error) {
+	return c.service.MachineTypes.Get(project, zone, machineType).Fields("guestCpus").Context(ctx).Do()
+}
+
+// unavailableComputeClient fails all calls, standing in for a client whose credentials couldn't be loaded
+type unavailableComputeClient struct {
+	err error
+}
+
+func (c *unavailableComputeClient) GetProject(ctx context.Context, project string) (*compute.Project, bool, error) {
+	return nil, false, c.err
+}
+
+func (c *unavailableComputeClient) GetRegion(ctx context.Context, project, region string) (*compute.Region, error) {
+	return nil, c.err
+}
+
+func (c *unavailableComputeClient) ListRegions(ctx context.Context, project string) ([]*compute.Region, bool, error) {
+	return nil, false, c.err
+}
+
+func (c *unavailableComputeClient) ListInstances(ctx context.Context, project string) (map[string][]*compute.Instance, error) {
+	return nil, c.err
+}
+
+func (c *unavailableComputeClient) ListDisks(ctx context.Context, project string) (map[string][]*compute.Disk, error) {
+	return nil, c.err
+}
+
+func (c *unavailableComputeClient) ListAddresses(ctx context.Context, project string) (map[string][]*compute.Address, error) {
+	return nil, c.err
+}
+
+func (c *unavailableComputeClient) GetMachineType(ctx context.Context, project, zone, machineType string) (*compute.MachineType, error) {
+	return nil, c.err
+}
+
+// fakeComputeClient serves quota kept in memory; unknown projects and regions return a not found error like the real api, and like
+// an api without etags it never reports a response as not modified
+type fakeComputeClient struct {
+	projects     map[string]*compute.Project
+	regions      map[string]map[string]*compute.Region
+	instances    map[string]map[string][]*compute.Instance
+	disks        map[string]map[string][]*compute.Disk
+	addresses    map[string]map[string][]*compute.Address
+	machineTypes map[string]int64
+	errors       map[string]error
+	mutex        sync.RWMutex
+}
+
+func newFakeComputeClient() *fakeComputeClient {
+	return &fakeComputeClient{
+		projects:     map[string]*compute.Project{},
+		regions:      map[string]map[string]*compute.Region{},
+		instances:    map[string]map[string][]*compute.Instance{},
+		disks:        map[string]map[string][]*compute.Disk{},
+		addresses:    map[string]map[string][]*compute.Address{},
+		machineTypes: map[string]int64{},
+		errors:       map[string]error{},
+	}
+}
+
+// SetProjectQuota sets the global quota returned for the project
+func (c *fakeComputeClient) SetProjectQuota(project string, quotas []*compute.Quota) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.projects[project] = &compute.Project{Name: project, Quotas: quotas}
+	if _, ok := c.regions[project]; !ok {
+		c.regions[project] = map[string]*compute.Region{}
+	}
+}
+
+// SetRegionQuota sets the regional quota returned for the project and region; the project has to be set first
+func (c *fakeComputeClient) SetRegionQuota(project, region string, quotas []*compute.Quota) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.regions[project]; !ok {
+		c.regions[project] = map[string]*compute.Region{}
+	}
+	c.regions[project][region] = &compute.Region{Name: region, Quotas: quotas}
+}
+
+// SetInstances sets the instances returned for the project and scope, like zones/europe-west1-b
+func (c *fakeComputeClient) SetInstances(project, scope string, instances []*compute.Instance) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.instances[project]; !ok {
+		c.instances[project] = map[string][]*compute.Instance{}
+	}
+	c.instances[project][scope] = instances
+}
+
+// SetDisks sets the disks returned for the project and scope
+func (c *fakeComputeClient) SetDisks(project, scope string, disks []*compute.Disk) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.disks[project]; !ok {
+		c.disks[project] = map[string][]*compute.Disk{}
+	}
+	c.disks[project][scope] = disks
+}
+
+// SetAddresses sets the addresses returned for the project and scope
+func (c *fakeComputeClient) SetAddresses(project, scope string, addresses []*compute.Address) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.addresses[project]; !ok {
+		c.addresses[project] = map[string][]*compute.Address{}
+	}
+	c.addresses[project][scope] = addresses
+}
+
+// SetMachineType sets the vcpus of a machine type, which are the same in all projects and zones
+func (c *fakeComputeClient) SetMachineType(machineType string, guestCPUs int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.machineTypes[machineType] = guestCPUs
+}
+
+// SetError makes all calls for the project fail with the error; nil clears it
+func (c *fakeComputeClient) SetError(project string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err == nil {
+		delete(c.errors, project)
+		return
+	}
+	c.errors[project] = err
+}
+
+func (c *fakeComputeClient) GetProject(ctx context.Context, project string) (*compute.Project, bool, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err, ok := c.errors[project]; ok {
+		return nil, false, err
+	}
+
+	p, ok := c.projects[project]
+	if !ok {
+		return nil, false, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'projects/%v' was not found", project)}
+	}
+
+	return p, false, nil
+}
+
+func (c *fakeComputeClient) GetRegion(ctx context.Context, project, region string) (*compute.Region, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err, ok := c.errors[project]; ok {
+		return nil, err
+	}
+
+	r, ok := c.regions[project][region]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'projects/%v/regions/%v' was not found", project, region)}
+	}
+
+	return r, nil
+}
+
+func (c *fakeComputeClient) ListRegions(ctx context.Context, project string) ([]*compute.Region, bool, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err, ok := c.errors[project]; ok {
+		return nil, false, err
+	}
+
+	if _, ok := c.projects[project]; !ok {
+		return nil, false, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'projects/%v' was not found", project)}
+	}
+
+	regions := []*compute.Region{}
+	for _, region := range c.regions[project] {
+		regions = append(regions, region)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Name < regions[j].Name
+	})
+
+	return regions, false, nil
+}
+
+func (c *fakeComputeClient) ListInstances(ctx context.Context, project string) (map[string][]*compute.Instance, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err := c.projectError(project); err != nil {
+		return nil, err
+	}
+
+	instances := map[string][]*compute.Instance{}
+	for scope, list := range c.instances[project] {
+		instances[scope] = list
+	}
+
+	return instances, nil
+}
+
+func (c *fakeComputeClient) ListDisks(ctx context.Context, project string) (map[string][]*compute.Disk, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err := c.projectError(project); err != nil {
+		return nil, err
+	}
+
+	disks := map[string][]*compute.Disk{}
+	for scope, list := range c.disks[project] {
+		disks[scope] = list
+	}
+
+	return disks, nil
+}
+
+func (c *fakeComputeClient) ListAddresses(ctx context.Context, project string) (map[string][]*compute.Address, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err := c.projectError(project); err != nil {
+		return nil, err
+	}
+
+	addresses := map[string][]*compute.Address{}
+	for scope, list := range c.addresses[project] {
+		addresses[scope] = list
+	}
+
+	return addresses, nil
+}
+
+func (c *fakeComputeClient) GetMachineType(ctx context.Context, project, zone, machineType string) (*compute.MachineType, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err := c.projectError(project); err != nil {
+		return nil, err
+	}
+
+	guestCPUs, ok := c.machineTypes[machineType]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'projects/%v/zones/%v/machineTypes/%v' was not found", project, zone, machineType)}
+	}
+
+	return &compute.MachineType{Name: machineType, GuestCpus: guestCPUs}, nil
+}
+
+// projectError returns the error set for the project, or a not found error if the project doesn't exist; it has to be called with
+// the mutex held
+func (c *fakeComputeClient) projectError(project string) error {
+
+	if err, ok := c.errors[project]; ok {
+		return err
+	}
+	if _, ok := c.projects[project]; !ok {
+		return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource 'projects/%v' was not found", project)}
+	}
+
+	return nil
+}
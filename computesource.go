@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerQuotaSource("compute", func(clients clientProvider, regions []string) quotaSource {
+		return &computeQuotaSource{clients: clients, regions: regions, projectRegions: map[string][]string{}}
+	})
+}
+
+// computeQuotaSource retrieves the global and regional compute engine quota
+type computeQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// projectRegions caches the configured regions that are actually available to each project
+	projectRegions map[string][]string
+	mutex          sync.Mutex
+}
+
+func (s *computeQuotaSource) Name() string {
+	return "compute"
+}
+
+// Discover cross-checks the configured regions against the regions available to the project once, warning about and skipping
+// regions that are mistyped or not available to it instead of failing on them every cycle
+func (s *computeQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+
+	s.mutex.Lock()
+	regions, ok := s.projectRegions[project]
+	s.mutex.Unlock()
+	if ok {
+		return regions, nil
+	}
+
+	regions, err := availableRegions(ctx, s.clients.compute(project), project, s.regions)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.projectRegions[project] = regions
+	s.mutex.Unlock()
+
+	return regions, nil
+}
+
+func (s *computeQuotaSource) Collect(ctx context.Context, project string, regions []string) ([]quotaUpdate, error) {
+
+	computeClient := s.clients.compute(project)
+
+	p, notModified, err := computeClient.GetProject(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// unchanged quota keeps the series exported from the same response before instead of staging them all again; the updates are
+	// still returned, since they make up the published quota of the cycle
+	quotas := normalizeQuotaUnits(sanitizeQuotas(p.Quotas, project))
+	if !notModified || !keepSeries(ctx, globalQuotaLimit, globalQuotaUsage) {
+		updateGlobalQuota(ctx, quotas, project)
+	}
+	updates := toQuotaUpdates(quotas, project, "", time.Now())
+
+	// a single list call returns the quota of all regions, only the configured ones are kept
+	available, notModified, err := computeClient.ListRegions(ctx, project)
+	if err != nil {
+		return updates, err
+	}
+	keepRegions := notModified && keepSeries(ctx, regionalQuotaLimit, regionalQuotaUsage)
+
+	for _, r := range available {
+		if !stringInSlice(regions, r.Name) {
+			continue
+		}
+
+		quotas := normalizeQuotaUnits(sanitizeQuotas(r.Quotas, project))
+		if !keepRegions {
+			updateRegionalQuota(ctx, quotas, project, r.Name)
+		}
+		updates = append(updates, toQuotaUpdates(quotas, project, r.Name, time.Now())...)
+	}
+
+	return updates, nil
+}
+
+// availableRegions returns the configured regions that are available to the project, warning about the ones that aren't
+func availableRegions(ctx context.Context, client computeClient, project string, configured []string) ([]string, error) {
+
+	available, _, err := client.ListRegions(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	availableNames := []string{}
+	for _, region := range available {
+		availableNames = append(availableNames, region.Name)
+	}
+
+	regions := []string{}
+	for _, region := range configured {
+		if stringInSlice(availableNames, region) {
+			regions = append(regions, region)
+		} else {
+			loggerFromContext(ctx).Warn().Msgf("Region %v isn't available to project %v, skipping it", region, project)
+		}
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestComputeQuotaSourceCollect(t *testing.T) {
+
+	global := func(metric string) prometheus.Labels {
+		return prometheus.Labels{"project": "compute-project", "metric": metric}
+	}
+	regional := func(region, metric string) prometheus.Labels {
+		return prometheus.Labels{"project": "compute-project", "region": region, "metric": metric}
+	}
+
+	tests := []struct {
+		name     string
+		regions  []string
+		setup    func(c *fakeComputeClient)
+		expected []expectedSeries
+		absent   []expectedSeries
+		err      bool
+	}{
+		{
+			name:    "ExportsGlobalAndRegionalQuota",
+			regions: []string{"europe-west1", "europe-west4"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+				c.SetRegionQuota("compute-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+				c.SetRegionQuota("compute-project", "europe-west4", testQuotas(map[string][2]float64{"CPUS": {48, 0}}))
+			},
+			expected: []expectedSeries{
+				{gauge: globalQuotaLimit, labels: global("networks"), value: 15},
+				{gauge: globalQuotaUsage, labels: global("networks"), value: 3},
+				{gauge: regionalQuotaLimit, labels: regional("europe-west1", "cpus"), value: 24},
+				{gauge: regionalQuotaUsage, labels: regional("europe-west1", "cpus"), value: 8},
+				{gauge: regionalQuotaLimit, labels: regional("europe-west4", "cpus"), value: 48},
+			},
+		},
+		{
+			name:    "SkipsRegionsThatArentConfigured",
+			regions: []string{"europe-west1"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+				c.SetRegionQuota("compute-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+				c.SetRegionQuota("compute-project", "us-central1", testQuotas(map[string][2]float64{"CPUS": {72, 0}}))
+			},
+			expected: []expectedSeries{
+				{gauge: regionalQuotaLimit, labels: regional("europe-west1", "cpus"), value: 24},
+			},
+			absent: []expectedSeries{
+				{gauge: regionalQuotaLimit, labels: regional("us-central1", "cpus")},
+			},
+		},
+		{
+			name:    "ClampsNegativeQuotaToZero",
+			regions: []string{"europe-west1"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"FIREWALLS": {-5, -2}, "ROUTES": {-1, 4}}))
+				c.SetRegionQuota("compute-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+			},
+			expected: []expectedSeries{
+				{gauge: globalQuotaLimit, labels: global("firewalls"), value: 0},
+				{gauge: globalQuotaUsage, labels: global("firewalls"), value: 0},
+				{gauge: globalQuotaLimit, labels: global("routes"), value: -1},
+			},
+		},
+		{
+			name:    "SkipsQuotaThatIsntANumber",
+			regions: []string{"europe-west1"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}, "FIREWALLS": {100, math.NaN()}}))
+				c.SetRegionQuota("compute-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, math.Inf(1)}}))
+			},
+			expected: []expectedSeries{
+				{gauge: globalQuotaLimit, labels: global("networks"), value: 15},
+			},
+			absent: []expectedSeries{
+				{gauge: globalQuotaLimit, labels: global("firewalls")},
+				{gauge: regionalQuotaLimit, labels: regional("europe-west1", "cpus")},
+			},
+		},
+		{
+			name:    "SkipsConfiguredRegionsThatArentAvailable",
+			regions: []string{"europe-west1", "europe-wes4"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+				c.SetRegionQuota("compute-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+			},
+			expected: []expectedSeries{
+				{gauge: regionalQuotaLimit, labels: regional("europe-west1", "cpus"), value: 24},
+			},
+			absent: []expectedSeries{
+				{gauge: regionalQuotaLimit, labels: regional("europe-wes4", "cpus")},
+			},
+		},
+		{
+			name:    "FailsForUnknownProject",
+			regions: []string{"europe-west1"},
+			setup:   func(c *fakeComputeClient) {},
+			err:     true,
+		},
+		{
+			name:    "FailsIfTheApiFails",
+			regions: []string{"europe-west1"},
+			setup: func(c *fakeComputeClient) {
+				c.SetProjectQuota("compute-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+				c.SetError("compute-project", errors.New("backend error"))
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resetTestState(globalQuotaLimit, globalQuotaUsage, regionalQuotaLimit, regionalQuotaUsage)
+			client := newFakeComputeClient()
+			tt.setup(client)
+			source := &computeQuotaSource{clients: newStaticClientManager(client), regions: tt.regions, projectRegions: map[string][]string{}}
+
+			errs := runTestCycle(t, source, "compute-project")
+
+			assertTestCycle(t, errs["compute-project"], tt.err, tt.expected, tt.absent)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"sync"
+)
+
+// forEachConcurrently calls f for all items with at most concurrency calls running at the same time, and returns when all are done
+func forEachConcurrently(items []string, concurrency int, f func(item string)) {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	var waitGroup sync.WaitGroup
+	for _, item := range items {
+		semaphore <- struct{}{}
+		waitGroup.Add(1)
+		go func(item string) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			f(item)
+		}(item)
+	}
+	waitGroup.Wait()
+}
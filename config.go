@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+)
+
+// configError is a problem with a single configuration option, naming both the flag and its environment variable so it can be
+// fixed regardless of how the exporter is configured
+type configError struct {
+	flag    string
+	message string
+}
+
+func (e configError) Error() string {
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Name == e.flag && flag.Envar != "" {
+			return fmt.Sprintf("--%v (%v): %v", e.flag, flag.Envar, e.message)
+		}
+	}
+	return fmt.Sprintf("--%v: %v", e.flag, e.message)
+}
+
+// validateConfig checks the parsed configuration for out of range values and conflicting options beyond what the flag types
+// enforce, and returns all problems at once so a bad rollout fails at startup instead of half applying
+func validateConfig() error {
+
+	errs := []error{}
+	check := func(ok bool, flag, format string, a ...interface{}) {
+		if !ok {
+			errs = append(errs, configError{flag: flag, message: fmt.Sprintf(format, a...)})
+		}
+	}
+
+	// conflicting options
+	check(*recordDir == "" || *replayDir == "", "replay-dir", "can't be combined with --record-dir")
+	check(!*simulate || *replayDir == "", "replay-dir", "can't be combined with --simulate, which doesn't call any apis")
+	check(!*simulate || *recordDir == "", "record-dir", "can't be combined with --simulate, which doesn't call any apis")
+	check(!*simulate || *googleComputeProjects == "", "google-compute-projects", "can't be combined with --simulate, use --simulate-projects instead")
+	check(!*staggerFetches || !*scrapeTimeFetch, "stagger-fetches", "can't be combined with --scrape-time-fetch, which fetches all projects while the scrape waits")
+	check(!*leaderElect || !*scrapeTimeFetch, "leader-elect", "can't be combined with --scrape-time-fetch, which fetches on every replica that's scraped")
+	check(!*leaderElect || !*once, "leader-elect", "can't be combined with --once, which fetches a single time")
+	check(!*simulate || len(*credentialSources) == 0, "credentials", "can't be combined with --simulate, which doesn't use credentials")
+	check(!*autoFileQuotaIncreases || !*normalizeUnits, "auto-file-quota-increases", "can't be combined with --normalize-units, which renames the metrics the caps refer to")
+	check(!*autoFileQuotaIncreases || !*simulate, "auto-file-quota-increases", "can't be combined with --simulate, which would file requests for simulated usage")
+	check(!*autoFileQuotaIncreases || !*downscopeTokens, "auto-file-quota-increases", "can't be combined with --downscope-tokens, whose read-only tokens can't file requests")
+
+	// out of range values
+	check(*simulateProjects > 0, "simulate-projects", "should be at least 1, but is %v", *simulateProjects)
+	check(*simulateDrift >= 0 && *simulateDrift <= 1, "simulate-drift", "should be a fraction between 0 and 1, but is %v", *simulateDrift)
+	check(*metricsRateLimit >= 0, "metrics-rate-limit", "can't be negative, but is %v", *metricsRateLimit)
+	check(*apiQPS >= 0, "gcp-api-qps", "can't be negative, but is %v", *apiQPS)
+	check(*apiBurst > 0, "gcp-api-burst", "should be at least 1, but is %v", *apiBurst)
+	check(*apiRetries >= 0, "gcp-api-retries", "can't be negative, but is %v", *apiRetries)
+	check(*logSampleLimit >= 0, "log-sample-limit", "can't be negative, but is %v", *logSampleLimit)
+	check(*deadProjectAfter > 0, "dead-project-after", "should be at least 1, but is %v", *deadProjectAfter)
+	check(*deadProjectRecheck > 0, "dead-project-recheck", "should be positive, but is %v", *deadProjectRecheck)
+	check(*apiDisabledRecheck > 0, "api-disabled-recheck", "should be positive, but is %v", *apiDisabledRecheck)
+	check(*projectLifecycleRecheck > 0, "project-lifecycle-recheck", "should be positive, but is %v", *projectLifecycleRecheck)
+	check(*fetchInterval > 0, "fetch-interval", "should be positive, but is %v", *fetchInterval)
+	check(*fetchJitterPercent >= 0 && *fetchJitterPercent < 100, "fetch-jitter-percent", "should be between 0 and 99, but is %v", *fetchJitterPercent)
+	check(*metricTTL >= 0, "metric-ttl", "can't be negative, but is %v", *metricTTL)
+	check(*totalShards > 0, "total-shards", "should be at least 1, but is %v", *totalShards)
+	check(*shard >= 0 && *shard < *totalShards, "shard", "should be between 0 and --total-shards - 1, but is %v", *shard)
+	check(!*leaderElect || *leaderElectRenewDeadline < *leaderElectLeaseDuration, "leader-elect-renew-deadline", "should be shorter than --leader-elect-lease-duration %v, but is %v", *leaderElectLeaseDuration, *leaderElectRenewDeadline)
+	check(!*leaderElect || *leaderElectRetryPeriod > 0, "leader-elect-retry-period", "should be positive, but is %v", *leaderElectRetryPeriod)
+	check(*maxConcurrency > 0, "max-concurrency", "should be at least 1, but is %v", *maxConcurrency)
+	check(*livenessMaxMissedCycles >= 0, "liveness-max-missed-cycles", "can't be negative, but is %v", *livenessMaxMissedCycles)
+	check(!*dashboardEnabled || *historyRetention > 0, "history-retention", "should be positive when --dashboard is enabled, but is %v", *historyRetention)
+	check(!*autoFileQuotaIncreases || len(*autoFileCaps) > 0, "auto-file-cap", "should be set at least once when --auto-file-quota-increases is enabled")
+	check(!*autoFileQuotaIncreases || *autoFileContactEmail != "", "auto-file-contact-email", "should be set when --auto-file-quota-increases is enabled")
+	check(*autoFileThreshold > 0 && *autoFileThreshold <= 1, "auto-file-threshold", "should be a fraction between 0 and 1, but is %v", *autoFileThreshold)
+	check(*autoFileCycles > 0, "auto-file-cycles", "should be at least 1, but is %v", *autoFileCycles)
+	check(*autoFileMultiplier > 1, "auto-file-multiplier", "should be above 1, but is %v", *autoFileMultiplier)
+	check(*bigQueryQueryLimit > 0, "bigquery-concurrent-query-limit", "should be at least 1, but is %v", *bigQueryQueryLimit)
+	check(*bigQueryLoadJobLimit > 0, "bigquery-load-job-limit", "should be at least 1, but is %v", *bigQueryLoadJobLimit)
+	check(*cloudSQLInstanceLimit > 0, "cloudsql-instance-limit", "should be at least 1, but is %v", *cloudSQLInstanceLimit)
+	check(*spannerNodeLimit >= 0, "spanner-node-limit", "can't be negative, but is %v", *spannerNodeLimit)
+	check(*bigtableNodeLimit >= 0, "bigtable-node-limit", "can't be negative, but is %v", *bigtableNodeLimit)
+	check(*iamServiceAccountLimit > 0, "iam-service-account-limit", "should be at least 1, but is %v", *iamServiceAccountLimit)
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Errorf("Configuration has %v problems:\n  %v", len(errs), strings.Join(messages, "\n  "))
+}
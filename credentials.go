@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// credentialSource binds a credentials file to the projects it's used for
+type credentialSource struct {
+	File     string
+	Projects []string
+}
+
+// parseCredentialSources parses values in the form /path/to/key.json=project-a,project-b
+func parseCredentialSources(values []string) (sources []credentialSource, err error) {
+
+	boundProjects := map[string]string{}
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Credential source %q is not in the form /path/to/key.json=project-a,project-b", value)
+		}
+
+		source := credentialSource{
+			File: parts[0],
+		}
+		projects, err := parseProjects(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Credential source %q has invalid projects: %v", value, err)
+		}
+		for _, project := range projects {
+			if file, ok := boundProjects[project]; ok {
+				return nil, fmt.Errorf("Project %v is bound to both %v and %v", project, file, source.File)
+			}
+			boundProjects[project] = source.File
+			source.Projects = append(source.Projects, project)
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// credentialsFile holds the fields of a google credentials json file needed to create a token source
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newTokenSource creates a token source from the credentials file, or from the application default credentials if credentialsFilePath is empty;
+// if configured the tokens are downscoped to read-only and/or exchanged for tokens of an impersonated service account
+func newTokenSource(ctx context.Context, credentialsFilePath string) (oauth2.TokenSource, error) {
+
+	if *impersonateServiceAccount == "" {
+		return newSourceTokenSource(ctx, credentialsFilePath, tokenScopes())
+	}
+
+	// the source credential needs the cloud-platform scope to call the iam credentials api
+	source, err := newSourceTokenSource(ctx, credentialsFilePath, []string{compute.CloudPlatformScope})
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.ReuseTokenSource(nil, &impersonatedTokenSource{
+		ctx:            ctx,
+		client:         oauth2.NewClient(ctx, source),
+		serviceAccount: *impersonateServiceAccount,
+		scopes:         tokenScopes(),
+		lifetime:       *impersonationLifetime,
+	}), nil
+}
+
+// newSourceTokenSource creates a token source with the given scopes from the credentials file, or from the application default credentials if credentialsFilePath is empty
+func newSourceTokenSource(ctx context.Context, credentialsFilePath string, scopes []string) (oauth2.TokenSource, error) {
+
+	if credentialsFilePath == "" {
+		// the application default credentials of the old oauth2 library don't understand external accounts, so read the file ourselves
+		credentialsFilePath = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if credentialsFilePath == "" {
+			return google.DefaultTokenSource(ctx, scopes...)
+		}
+	}
+
+	data, err := ioutil.ReadFile(credentialsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var f credentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Parsing credentials file %v failed: %v", credentialsFilePath, err)
+	}
+
+	switch f.Type {
+	case "service_account":
+		config, err := google.JWTConfigFromJSON(data, scopes...)
+		if err != nil {
+			return nil, err
+		}
+		return config.TokenSource(ctx), nil
+
+	case "authorized_user":
+		config := &oauth2.Config{
+			ClientID:     f.ClientID,
+			ClientSecret: f.ClientSecret,
+			Endpoint:     google.Endpoint,
+			Scopes:       scopes,
+		}
+		return config.TokenSource(ctx, &oauth2.Token{RefreshToken: f.RefreshToken}), nil
+
+	case "external_account":
+		return newExternalAccountTokenSource(ctx, data, scopes)
+	}
+
+	return nil, fmt.Errorf("Credentials file %v has unsupported type %q", credentialsFilePath, f.Type)
+}
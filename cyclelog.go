@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// log verbosities, each including the logs of the previous one
+const (
+	logVerbosityCycle   = "cycle"
+	logVerbosityProject = "project"
+	logVerbosityAPI     = "api"
+)
+
+type loggerContextKey struct{}
+
+// withCycleLogger returns a context carrying a logger that adds a new cycle id to every line, so all logs of a fetch cycle - including
+// those of its api calls - can be correlated
+func withCycleLogger(ctx context.Context) (context.Context, *zerolog.Logger, string) {
+
+	cycleID := randomHex(8)
+	logger := log.With().Str("cycleID", cycleID).Logger()
+
+	return context.WithValue(ctx, loggerContextKey{}, &logger), &logger, cycleID
+}
+
+// loggerFromContext returns the cycle logger in the context, or the global logger outside of fetch cycles
+func loggerFromContext(ctx context.Context) *zerolog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*zerolog.Logger); ok {
+		return logger
+	}
+	return &log.Logger
+}
+
+// logVerbosityIncludes checks whether the configured verbosity includes the logs of the verbosity
+func logVerbosityIncludes(verbosity string) bool {
+	switch *logVerbosity {
+	case logVerbosityAPI:
+		return true
+	case logVerbosityProject:
+		return verbosity != logVerbosityAPI
+	default:
+		return verbosity == logVerbosityCycle
+	}
+}
+
+// apiLogTransport logs every api call attempt with the cycle id of the request context, when the log verbosity is api
+type apiLogTransport struct {
+	base http.RoundTripper
+}
+
+func (t *apiLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if !logVerbosityIncludes(logVerbosityAPI) {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	event := loggerFromContext(req.Context()).Info()
+	if err != nil || resp.StatusCode >= 400 {
+		event = loggerFromContext(req.Context()).Warn()
+	}
+	event = event.
+		Str("service", serviceFromRequest(req)).
+		Str("method", req.Method).
+		Str("path", req.URL.Path).
+		Str("project", projectFromAPIPath(req.URL.Path)).
+		Dur("duration", time.Since(start))
+
+	if err != nil {
+		event.Err(err).Str("outcome", "error").Msg("Api call failed")
+		return resp, err
+	}
+	outcome := "success"
+	if resp.StatusCode >= 400 {
+		outcome = "error"
+	}
+	event.Int("status", resp.StatusCode).Str("outcome", outcome).Msg("Api call")
+
+	return resp, err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dashboardRow is a single quota in the html dashboard
+type dashboardRow struct {
+	Region        string
+	Metric        string
+	Usage         float64
+	Limit         float64
+	Utilization   float64
+	Exhaustion    time.Duration
+	HasExhaustion bool
+	Sparkline     template.HTML
+}
+
+// dashboardHandler serves a self-contained html page with the utilization history of a project's quota, for teams without grafana
+type dashboardHandler struct {
+	history *historyStore
+}
+
+func (h *dashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	projects := h.history.projects()
+	sort.Strings(projects)
+
+	project := r.URL.Query().Get("project")
+	if project == "" && len(projects) > 0 {
+		project = projects[0]
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy != "utilization" {
+		sortBy = "exhaustion"
+	}
+
+	rows := []dashboardRow{}
+	for key, points := range h.history.project(project) {
+		last := points[len(points)-1]
+		if last.Limit < 0 {
+			continue
+		}
+		exhaustion, hasExhaustion := timeToExhaustion(points)
+		rows = append(rows, dashboardRow{
+			Region:        key.Region,
+			Metric:        key.Metric,
+			Usage:         last.Usage,
+			Limit:         last.Limit,
+			Utilization:   utilization(quotaUpdate{Usage: last.Usage, Limit: last.Limit}),
+			Exhaustion:    exhaustion.Round(time.Minute),
+			HasExhaustion: hasExhaustion,
+			Sparkline:     sparkline(points),
+		})
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if sortBy == "exhaustion" && rows[i].HasExhaustion != rows[j].HasExhaustion {
+			return rows[i].HasExhaustion
+		}
+		if sortBy == "exhaustion" && rows[i].HasExhaustion && rows[i].Exhaustion != rows[j].Exhaustion {
+			return rows[i].Exhaustion < rows[j].Exhaustion
+		}
+		if rows[i].Utilization != rows[j].Utilization {
+			return rows[i].Utilization > rows[j].Utilization
+		}
+		return rows[i].Region+rows[i].Metric < rows[j].Region+rows[j].Metric
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, map[string]interface{}{
+		"Projects":  projects,
+		"Project":   project,
+		"Sort":      sortBy,
+		"Rows":      rows,
+		"Retention": h.history.retention,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Rendering dashboard failed")
+	}
+}
+
+// sparkline draws the utilization history as inline svg, so the page doesn't need any javascript or external assets
+func sparkline(points []historyPoint) template.HTML {
+
+	const width, height = 200.0, 30.0
+
+	if len(points) < 2 {
+		return ""
+	}
+
+	first := points[0].Timestamp
+	span := points[len(points)-1].Timestamp.Sub(first).Seconds()
+	if span <= 0 {
+		return ""
+	}
+
+	coordinates := []string{}
+	for _, point := range points {
+		u := utilization(quotaUpdate{Usage: point.Usage, Limit: point.Limit})
+		if u > 1 {
+			u = 1
+		}
+		x := point.Timestamp.Sub(first).Seconds() / span * width
+		y := height - u*height
+		coordinates = append(coordinates, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+
+	return template.HTML(fmt.Sprintf(`<svg width="%v" height="%v" viewBox="0 0 %v %v"><rect width="%v" height="%v" fill="#f4f4f4"/><polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="%v"/></svg>`, width, height, width, height, width, height, strings.Join(coordinates, " ")))
+}
+
+func formatExhaustion(row dashboardRow) string {
+	if !row.HasExhaustion {
+		return "-"
+	}
+	if row.Exhaustion <= 0 {
+		return "exhausted"
+	}
+	if row.Exhaustion >= 48*time.Hour {
+		return fmt.Sprintf("%.0fd", row.Exhaustion.Hours()/24)
+	}
+	return row.Exhaustion.String()
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percentage": func(f float64) string { return fmt.Sprintf("%.1f%%", 100*f) },
+	"exhaustion": formatExhaustion,
+	"scope": func(region string) string {
+		if region == "" {
+			return "global"
+		}
+		return region
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Quota {{.Project}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; text-align: left; border-bottom: 1px solid #ddd; }
+td.number { text-align: right; }
+tr.warning { background: #fff3cd; }
+tr.critical { background: #f8d7da; }
+</style>
+</head>
+<body>
+<h1>Quota {{.Project}}</h1>
+<p>
+Project:
+{{range .Projects}}<a href="?project={{.}}&sort={{$.Sort}}">{{.}}</a> {{end}}
+</p>
+<p>History of the last {{.Retention}}; time to exhaustion is extrapolated from the usage trend in that window.</p>
+<table>
+<tr>
+<th>Scope</th>
+<th>Metric</th>
+<th>Usage</th>
+<th>Limit</th>
+<th><a href="?project={{.Project}}&sort=utilization">Utilization</a></th>
+<th><a href="?project={{.Project}}&sort=exhaustion">Time to exhaustion</a></th>
+<th>History</th>
+</tr>
+{{range .Rows}}<tr{{if ge .Utilization 0.9}} class="critical"{{else if ge .Utilization 0.8}} class="warning"{{end}}>
+<td>{{scope .Region}}</td>
+<td>{{.Metric}}</td>
+<td class="number">{{.Usage}}</td>
+<td class="number">{{.Limit}}</td>
+<td class="number">{{percentage .Utilization}}</td>
+<td class="number">{{exhaustion .}}</td>
+<td>{{.Sparkline}}</td>
+</tr>
+{{else}}<tr><td colspan="7">No quota has been fetched yet.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// datadogSink submits the metrics as gauges to the datadog series api, turning labels into tags
+type datadogSink struct {
+	apiKey     string
+	site       string
+	prefix     string
+	tags       []string
+	tagMapping map[string]string
+	client     *http.Client
+}
+
+// newDatadogSink creates a sink for the datadog site, like datadoghq.com or datadoghq.eu; tagMapping renames labels to tag keys, given as label=tag
+func newDatadogSink(apiKey, site, prefix string, tags, tagMapping []string) (*datadogSink, error) {
+
+	sink := &datadogSink{
+		apiKey:     apiKey,
+		site:       site,
+		prefix:     prefix,
+		tags:       tags,
+		tagMapping: map[string]string{},
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, mapping := range tagMapping {
+		labelTag := strings.SplitN(mapping, "=", 2)
+		if len(labelTag) != 2 || labelTag[0] == "" || labelTag[1] == "" {
+			return nil, fmt.Errorf("Datadog tag mapping %q is not in the form label=tag", mapping)
+		}
+		sink.tagMapping[labelTag[0]] = labelTag[1]
+	}
+
+	return sink, nil
+}
+
+func (s *datadogSink) Name() string {
+	return "datadog " + s.site
+}
+
+type datadogSeries struct {
+	Metric string       `json:"metric"`
+	Points [][2]float64 `json:"points"`
+	Type   string       `json:"type"`
+	Tags   []string     `json:"tags,omitempty"`
+}
+
+func (s *datadogSink) Push(ctx context.Context, families []*dto.MetricFamily) error {
+
+	timestamp := float64(time.Now().Unix())
+
+	series := []datadogSeries{}
+	for _, flat := range flattenFamilies(families) {
+
+		tags := append([]string{}, s.tags...)
+		labels := []string{}
+		for label := range flat.labels {
+			if label != "__name__" {
+				labels = append(labels, label)
+			}
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			tag := label
+			if mapped, ok := s.tagMapping[label]; ok {
+				tag = mapped
+			}
+			tags = append(tags, tag+":"+flat.labels[label])
+		}
+
+		series = append(series, datadogSeries{
+			Metric: s.prefix + flat.labels["__name__"],
+			Points: [][2]float64{{timestamp, flat.value}},
+			Type:   "gauge",
+			Tags:   tags,
+		})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"series": series})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://api.%v/api/v1/series", s.site), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Datadog api returned status %v: %v", resp.StatusCode, string(message))
+	}
+
+	return nil
+}
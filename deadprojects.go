@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// create gauge for projects that are considered deleted or unreachable
+	projectDead = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_dead",
+		Help: "Whether the project is considered deleted or unreachable after persistently returning not found or permission denied.",
+	}, []string{"project"})
+
+	// create gauge for when a project started failing
+	projectFirstFailure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_first_failure_timestamp_seconds",
+		Help: "The time a project first returned not found or permission denied in the current streak of failures.",
+	}, []string{"project"})
+
+	// errProjectDead marks dead projects that are skipped until their next recheck
+	errProjectDead = errors.New("Project is considered deleted or unreachable")
+
+	deadProjects      = map[string]*deadProject{}
+	deadProjectsMutex sync.Mutex
+)
+
+func init() {
+	prometheus.MustRegister(projectDead)
+	prometheus.MustRegister(projectFirstFailure)
+}
+
+// deadProject tracks a project that returns not found or permission denied for every collector
+type deadProject struct {
+	Project       string    `json:"project"`
+	Reason        string    `json:"reason"`
+	FirstFailure  time.Time `json:"firstFailure"`
+	Failures      int       `json:"failures"`
+	Dead          bool      `json:"dead"`
+	LastAttempted time.Time `json:"lastAttempted"`
+}
+
+// isDeadProjectError checks whether the error indicates the project was deleted or access to it was revoked; it's only conclusive for
+// errors of sources calling nothing but compute, since other apis return the same codes for a missing permission or disabled api
+func isDeadProjectError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == 403 || apiErr.Code == 404) && !isVPCServiceControlsError(err) && !isComputeAPIDisabledError(err)
+}
+
+// callsOnlyComputeAPI checks whether all permissions a source needs are compute permissions, like for the compute collector getting
+// the project and its regions
+func callsOnlyComputeAPI(source quotaSource) bool {
+
+	s, ok := source.(permissionsQuotaSource)
+	if !ok || len(s.Permissions()) == 0 {
+		return false
+	}
+	for _, permission := range s.Permissions() {
+		if !strings.HasPrefix(permission, "compute.") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDeadProjectSkipped checks whether a dead project should be skipped in this cycle; once per --dead-project-recheck it's attempted again
+func isDeadProjectSkipped(project string) bool {
+
+	deadProjectsMutex.Lock()
+	defer deadProjectsMutex.Unlock()
+
+	p, ok := deadProjects[project]
+	if !ok || !p.Dead {
+		return false
+	}
+	if time.Since(p.LastAttempted) < *deadProjectRecheck {
+		return true
+	}
+	p.LastAttempted = time.Now()
+
+	return false
+}
+
+// isProjectDead checks whether a project is considered deleted or unreachable, also in the cycles it's attempted again
+func isProjectDead(project string) bool {
+
+	deadProjectsMutex.Lock()
+	defer deadProjectsMutex.Unlock()
+
+	p, ok := deadProjects[project]
+
+	return ok && p.Dead
+}
+
+// updateDeadProjects counts the cycles in which the compute collectors failed for a project with not found or permission denied, and
+// marks it dead after --dead-project-after of those cycles in a row; a single successful compute call revives it
+func updateDeadProjects(outcomes map[string]error, succeeded map[string]bool) {
+
+	deadProjectsMutex.Lock()
+	defer deadProjectsMutex.Unlock()
+
+	now := time.Now()
+	for project, err := range outcomes {
+
+		if succeeded[project] {
+			if p, ok := deadProjects[project]; ok {
+				if p.Dead {
+					log.Info().Msgf("Project %v is reachable again after failing since %v", project, p.FirstFailure.Format(time.RFC3339))
+				}
+				delete(deadProjects, project)
+				projectDead.DeleteLabelValues(project)
+				projectFirstFailure.DeleteLabelValues(project)
+			}
+			continue
+		}
+
+		if err == nil || !isDeadProjectError(err) {
+			continue
+		}
+
+		p, ok := deadProjects[project]
+		if !ok {
+			p = &deadProject{Project: project, FirstFailure: now}
+			deadProjects[project] = p
+			projectFirstFailure.WithLabelValues(project).Set(float64(now.Unix()))
+		}
+		p.Reason = errorReason(err)
+		p.Failures++
+		p.LastAttempted = now
+
+		if !p.Dead && p.Failures >= *deadProjectAfter {
+			p.Dead = true
+			projectDead.WithLabelValues(project).Set(1)
+			log.Error().Err(err).Msgf("Project %v is considered deleted or unreachable after failing %v cycles in a row since %v, only checking it every %v from now on", project, p.Failures, p.FirstFailure.Format(time.RFC3339), *deadProjectRecheck)
+		}
+	}
+}
+
+// deadProjectsStatus lists the failing projects for the status page, dead or not yet
+func deadProjectsStatus() []deadProject {
+
+	deadProjectsMutex.Lock()
+	defer deadProjectsMutex.Unlock()
+
+	status := []deadProject{}
+	for _, p := range deadProjects {
+		status = append(status, *p)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Project < status[j].Project
+	})
+
+	return status
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const cloudPlatformReadOnlyScope = "https://www.googleapis.com/auth/cloud-platform.read-only"
+
+// tokenScopes returns the oauth scopes to request tokens with; credential access boundaries only apply to cloud storage, so
+// downscoping for the quota apis is done by requesting read-only scopes
+func tokenScopes() []string {
+	if *downscopeTokens {
+		return []string{cloudPlatformReadOnlyScope, compute.ComputeReadonlyScope}
+	}
+	return []string{compute.CloudPlatformScope}
+}
+
+// impersonatedTokenSource exchanges the source credential for short-lived tokens of another service account using the iam credentials api
+type impersonatedTokenSource struct {
+	ctx            context.Context
+	client         *http.Client
+	serviceAccount string
+	scopes         []string
+	lifetime       time.Duration
+}
+
+func (ts *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+
+	url := fmt.Sprintf("https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%v:generateAccessToken", ts.serviceAccount)
+
+	token, err := generateAccessToken(ts.ctx, ts.client, url, ts.scopes, ts.lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("Impersonating service account %v failed: %v", ts.serviceAccount, err)
+	}
+
+	return token, nil
+}
+
+// generateAccessToken calls the iam credentials generateAccessToken endpoint at url with a client authenticated as the impersonating principal
+func generateAccessToken(ctx context.Context, client *http.Client, url string, scopes []string, lifetime time.Duration) (*oauth2.Token, error) {
+
+	body, err := json.Marshal(map[string]interface{}{
+		"scope":    scopes,
+		"lifetime": fmt.Sprintf("%vs", int(lifetime.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken: response.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      response.ExpireTime,
+	}, nil
+}
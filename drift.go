@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauge for the usage counted from the actual resources
+	actualQuotaUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_actual_usage",
+		Help: "The usage for regional quota as counted from the actual instances, disks and addresses.",
+	}, []string{"project", "region", "metric"})
+
+	// create gauge for the difference between the counted and the reported usage
+	quotaUsageDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_usage_drift",
+		Help: "The usage counted from the actual resources minus the usage reported by the quota api; positive if the reported usage lags behind.",
+	}, []string{"project", "region", "metric"})
+)
+
+func init() {
+	prometheus.MustRegister(actualQuotaUsage)
+	prometheus.MustRegister(quotaUsageDrift)
+
+	registerQuotaSource("drift", func(clients clientProvider, regions []string) quotaSource {
+		return &driftQuotaSource{clients: clients, regions: regions, projectRegions: map[string][]string{}}
+	})
+}
+
+// driftQuotaSource reconciles the reported regional quota usage with counts of the actual resources, since reported usage can lag
+// behind and give false confidence
+type driftQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// projectRegions caches the configured regions that are actually available to each project
+	projectRegions map[string][]string
+	mutex          sync.Mutex
+}
+
+func (s *driftQuotaSource) Name() string {
+	return "drift"
+}
+
+// Discover skips the configured regions that aren't available to the project, like the compute source does
+func (s *driftQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+
+	s.mutex.Lock()
+	regions, ok := s.projectRegions[project]
+	s.mutex.Unlock()
+	if ok {
+		return regions, nil
+	}
+
+	regions, err := availableRegions(ctx, s.clients.compute(project), project, s.regions)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.projectRegions[project] = regions
+	s.mutex.Unlock()
+
+	return regions, nil
+}
+
+func (s *driftQuotaSource) Permissions() []string {
+	return []string{"compute.instances.list", "compute.disks.list", "compute.addresses.list", "compute.regions.list"}
+}
+
+func (s *driftQuotaSource) Collect(ctx context.Context, project string, regions []string) ([]quotaUpdate, error) {
+
+	computeClient := s.clients.compute(project)
+
+	// actual usage per region and quota metric
+	actual := map[string]map[string]float64{}
+	add := func(scope, metric string, value float64) {
+		region := regionFromScope(scope)
+		if actual[region] == nil {
+			actual[region] = map[string]float64{}
+		}
+		actual[region][metric] += value
+	}
+
+	instances, err := computeClient.ListInstances(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	for scope, list := range instances {
+		add(scope, "INSTANCES", float64(len(list)))
+	}
+
+	disks, err := computeClient.ListDisks(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	for scope, list := range disks {
+		for _, disk := range list {
+			switch disk.Type[strings.LastIndex(disk.Type, "/")+1:] {
+			case "pd-standard":
+				add(scope, "DISKS_TOTAL_GB", float64(disk.SizeGb))
+			case "pd-ssd", "pd-balanced", "pd-extreme":
+				// balanced and extreme persistent disks count against the ssd quota as well
+				add(scope, "SSD_TOTAL_GB", float64(disk.SizeGb))
+			}
+		}
+	}
+
+	addresses, err := computeClient.ListAddresses(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	for scope, list := range addresses {
+		for _, address := range list {
+			if address.AddressType != "INTERNAL" {
+				add(scope, "STATIC_ADDRESSES", 1)
+			}
+		}
+	}
+
+	// a single list call returns the reported usage of all regions, only the discovered ones are kept
+	available, _, err := computeClient.ListRegions(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range available {
+		if !stringInSlice(regions, r.Name) {
+			continue
+		}
+
+		region := r.Name
+		for _, quota := range r.Quotas {
+			switch quota.Metric {
+			case "INSTANCES", "DISKS_TOTAL_GB", "SSD_TOTAL_GB", "STATIC_ADDRESSES":
+				metric, counted := normalizeUnit(quota.Metric, actual[region][quota.Metric])
+				_, reported := normalizeUnit(quota.Metric, quota.Usage)
+				metricName := quotaMetricName(metric)
+				setGauge(ctx, actualQuotaUsage, counted, project, region, metricName)
+				setGauge(ctx, quotaUsageDrift, counted-reported, project, region, metricName)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// regionFromScope returns the region of aggregated list scopes like zones/europe-west1-b or regions/europe-west1
+func regionFromScope(scope string) string {
+
+	if strings.HasPrefix(scope, "zones/") {
+		zone := strings.TrimPrefix(scope, "zones/")
+		if i := strings.LastIndex(zone, "-"); i > 0 {
+			return zone[:i]
+		}
+		return zone
+	}
+
+	return strings.TrimPrefix(scope, "regions/")
+}
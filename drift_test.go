@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestDriftQuotaSourceCollect(t *testing.T) {
+
+	disk := func(diskType string, sizeGb int64) *compute.Disk {
+		return &compute.Disk{Type: "https://www.googleapis.com/compute/v1/projects/drift-project/zones/europe-west1-b/diskTypes/" + diskType, SizeGb: sizeGb}
+	}
+	metric := func(metric string) prometheus.Labels {
+		return prometheus.Labels{"project": "drift-project", "region": "europe-west1", "metric": metric}
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(c *fakeComputeClient)
+		expected []expectedSeries
+		absent   []expectedSeries
+		err      bool
+	}{
+		{
+			name: "CountsInstancesOfAllZonesOfTheRegion",
+			setup: func(c *fakeComputeClient) {
+				c.SetInstances("drift-project", "zones/europe-west1-b", []*compute.Instance{{Name: "a"}, {Name: "b"}})
+				c.SetInstances("drift-project", "zones/europe-west1-c", []*compute.Instance{{Name: "c"}})
+				c.SetInstances("drift-project", "zones/us-central1-a", []*compute.Instance{{Name: "d"}})
+			},
+			expected: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("instances"), value: 3},
+				{gauge: quotaUsageDrift, labels: metric("instances"), value: 1},
+			},
+		},
+		{
+			name: "CountsStandardAndSSDDisksAgainstTheirQuota",
+			setup: func(c *fakeComputeClient) {
+				c.SetDisks("drift-project", "zones/europe-west1-b", []*compute.Disk{disk("pd-standard", 500), disk("pd-ssd", 100), disk("pd-ssd", 200)})
+				c.SetDisks("drift-project", "regions/europe-west1", []*compute.Disk{disk("pd-ssd", 50)})
+			},
+			expected: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("disks_total_gb"), value: 500},
+				{gauge: quotaUsageDrift, labels: metric("disks_total_gb"), value: 0},
+				{gauge: actualQuotaUsage, labels: metric("ssd_total_gb"), value: 350},
+				{gauge: quotaUsageDrift, labels: metric("ssd_total_gb"), value: 50},
+			},
+		},
+		{
+			name: "CountsBalancedAndExtremeDisksAgainstTheSSDQuota",
+			setup: func(c *fakeComputeClient) {
+				c.SetDisks("drift-project", "zones/europe-west1-b", []*compute.Disk{disk("pd-ssd", 100), disk("pd-balanced", 200)})
+				c.SetDisks("drift-project", "regions/europe-west1", []*compute.Disk{disk("pd-extreme", 50)})
+			},
+			expected: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("ssd_total_gb"), value: 350},
+				{gauge: quotaUsageDrift, labels: metric("ssd_total_gb"), value: 50},
+			},
+		},
+		{
+			name: "CountsOnlyExternalStaticAddresses",
+			setup: func(c *fakeComputeClient) {
+				c.SetAddresses("drift-project", "regions/europe-west1", []*compute.Address{{AddressType: "EXTERNAL"}, {AddressType: "INTERNAL"}, {AddressType: "EXTERNAL"}})
+			},
+			expected: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("static_addresses"), value: 2},
+				{gauge: quotaUsageDrift, labels: metric("static_addresses"), value: 1},
+			},
+		},
+		{
+			name:  "SkipsQuotaThatIsntCounted",
+			setup: func(c *fakeComputeClient) {},
+			expected: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("instances"), value: 0},
+				{gauge: quotaUsageDrift, labels: metric("instances"), value: -2},
+			},
+			absent: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("cpus")},
+			},
+		},
+		{
+			name: "SkipsConfiguredRegionsThatArentAvailable",
+			setup: func(c *fakeComputeClient) {
+				delete(c.regions["drift-project"], "europe-west1")
+			},
+			absent: []expectedSeries{
+				{gauge: actualQuotaUsage, labels: metric("instances")},
+				{gauge: quotaUsageDrift, labels: metric("instances")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resetTestState(actualQuotaUsage, quotaUsageDrift)
+			client := newFakeComputeClient()
+			client.SetProjectQuota("drift-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+			client.SetRegionQuota("drift-project", "europe-west1", testQuotas(map[string][2]float64{
+				"INSTANCES":        {100, 2},
+				"DISKS_TOTAL_GB":   {4096, 500},
+				"SSD_TOTAL_GB":     {2048, 300},
+				"STATIC_ADDRESSES": {8, 1},
+				"CPUS":             {72, 12},
+			}))
+			tt.setup(client)
+			source := &driftQuotaSource{clients: newStaticClientManager(client), regions: []string{"europe-west1"}, projectRegions: map[string][]string{}}
+
+			errs := runTestCycle(t, source, "drift-project")
+
+			assertTestCycle(t, errs["drift-project"], tt.err, tt.expected, tt.absent)
+		})
+	}
+}
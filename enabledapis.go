@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauge for whether apis relevant to quota are enabled, to explain missing collector data
+	apiEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_api_enabled",
+		Help: "Whether the google cloud api is enabled (1) or not (0) in the project.",
+	}, []string{"project", "service"})
+
+	// relevantAPIs are the apis that collectors depend on or that have quota of their own
+	relevantAPIs = []string{
+		"compute.googleapis.com",
+		"container.googleapis.com",
+		"sqladmin.googleapis.com",
+		"bigquery.googleapis.com",
+		"spanner.googleapis.com",
+		"bigtableadmin.googleapis.com",
+		"file.googleapis.com",
+		"redis.googleapis.com",
+		"aiplatform.googleapis.com",
+		"tpu.googleapis.com",
+		"iam.googleapis.com",
+		"appengine.googleapis.com",
+		"serviceusage.googleapis.com",
+		"cloudquotas.googleapis.com",
+	}
+)
+
+func init() {
+	prometheus.MustRegister(apiEnabled)
+
+	registerQuotaSource("enabled-apis", func(clients clientProvider, regions []string) quotaSource {
+		return &enabledAPIsQuotaSource{clients: clients}
+	})
+}
+
+// enabledAPIsQuotaSource exports which apis relevant to quota are enabled per project, using the service usage api
+type enabledAPIsQuotaSource struct {
+	clients clientProvider
+}
+
+func (s *enabledAPIsQuotaSource) Name() string {
+	return "enabled-apis"
+}
+
+// Discover doesn't return locations, enabled apis are a project wide setting
+func (s *enabledAPIsQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *enabledAPIsQuotaSource) Permissions() []string {
+	return []string{"serviceusage.services.get"}
+}
+
+func (s *enabledAPIsQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// batchGet accepts at most 20 services per call
+	for start := 0; start < len(relevantAPIs); start += 20 {
+		end := start + 20
+		if end > len(relevantAPIs) {
+			end = len(relevantAPIs)
+		}
+
+		query := url.Values{}
+		for _, service := range relevantAPIs[start:end] {
+			query.Add("names", fmt.Sprintf("projects/%v/services/%v", project, service))
+		}
+
+		var response struct {
+			Services []struct {
+				Name  string `json:"name"`
+				State string `json:"state"`
+			} `json:"services"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://serviceusage.googleapis.com/v1/projects/%v/services:batchGet?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, service := range response.Services {
+			enabled := 0.0
+			if service.State == "ENABLED" {
+				enabled = 1
+			}
+			setGauge(ctx, apiEnabled, enabled, project, service.Name[strings.LastIndex(service.Name, "/")+1:])
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	clouderrorreporting "google.golang.org/api/clouderrorreporting/v1beta1"
+	"google.golang.org/api/googleapi"
+)
+
+// errorReporter ships errors to an external service that tracks and deduplicates them
+type errorReporter interface {
+	Name() string
+	Report(ctx context.Context, report errorReport) error
+}
+
+// errorReport is a collection error or panic with the context it happened in
+type errorReport struct {
+	Message   string
+	Stack     string
+	Tags      map[string]string
+	File      string
+	Line      int
+	Function  string
+	Timestamp time.Time
+	// Fingerprint groups reports of the same problem, independent of details like request ids in the message
+	Fingerprint []string
+}
+
+var errorReporters []errorReporter
+
+// initErrorReporting enables the configured error reporters; the cloud error reporting client uses the application default credentials
+func initErrorReporting(ctx context.Context, sentryDSN, errorReportingProject string) error {
+
+	if sentryDSN != "" {
+		reporter, err := newSentryReporter(sentryDSN)
+		if err != nil {
+			return err
+		}
+		errorReporters = append(errorReporters, reporter)
+	}
+
+	if errorReportingProject != "" {
+		client, err := newGoogleClient(ctx, "")
+		if err != nil {
+			return err
+		}
+		service, err := clouderrorreporting.New(client)
+		if err != nil {
+			return err
+		}
+		errorReporters = append(errorReporters, &cloudErrorReporter{service: service, project: errorReportingProject})
+	}
+
+	for _, reporter := range errorReporters {
+		log.Info().Msgf("Reporting collection errors to %v", reporter.Name())
+	}
+
+	return nil
+}
+
+// reportError sends the error to all reporters; it blocks until they're done, so it can be called right before exiting
+func reportError(ctx context.Context, err error, stack string, tags map[string]string) {
+
+	if len(errorReporters) == 0 || err == nil {
+		return
+	}
+
+	report := errorReport{
+		Message:   err.Error(),
+		Stack:     stack,
+		Tags:      tags,
+		Timestamp: time.Now(),
+	}
+
+	// report the location of the caller
+	if pc, file, line, ok := runtime.Caller(1); ok {
+		report.File = file
+		report.Line = line
+		if f := runtime.FuncForPC(pc); f != nil {
+			report.Function = f.Name()
+		}
+	}
+
+	errorClass := fmt.Sprintf("%T", err)
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		errorClass = "googleapi " + strconv.Itoa(apiErr.Code)
+	}
+	report.Fingerprint = []string{tags["collector"], tags["project"], errorClass}
+
+	// a detached context with a deadline, so a report still gets out if the cycle is cancelled but never hangs the caller
+	reportCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, reporter := range errorReporters {
+		if reportErr := reporter.Report(reportCtx, report); reportErr != nil {
+			loggerFromContext(ctx).Warn().Err(reportErr).Msgf("Reporting error to %v failed", reporter.Name())
+		}
+	}
+}
+
+// sentryReporter sends events to sentry's store endpoint
+type sentryReporter struct {
+	storeURL  string
+	publicKey string
+	client    *http.Client
+}
+
+// newSentryReporter parses a dsn like https://public-key@o0.ingest.sentry.io/project-id
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing sentry dsn failed: %v", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("Sentry dsn has no public key")
+	}
+
+	projectID := strings.Trim(u.Path, "/")
+	path := ""
+	if i := strings.LastIndex(projectID, "/"); i >= 0 {
+		path = "/" + projectID[:i]
+		projectID = projectID[i+1:]
+	}
+	if projectID == "" {
+		return nil, fmt.Errorf("Sentry dsn has no project id")
+	}
+
+	return &sentryReporter{
+		storeURL:  fmt.Sprintf("%v://%v%v/api/%v/store/", u.Scheme, u.Host, path, projectID),
+		publicKey: u.User.Username(),
+		client:    &http.Client{},
+	}, nil
+}
+
+func (r *sentryReporter) Name() string {
+	return "sentry"
+}
+
+func (r *sentryReporter) Report(ctx context.Context, report errorReport) error {
+
+	event := map[string]interface{}{
+		"event_id":    randomHex(16),
+		"timestamp":   report.Timestamp.UTC().Format("2006-01-02T15:04:05"),
+		"level":       "error",
+		"logger":      "estafette-gcloud-quota-exporter",
+		"platform":    "go",
+		"release":     version,
+		"message":     report.Message,
+		"tags":        report.Tags,
+		"fingerprint": report.Fingerprint,
+		"culprit":     report.Function,
+	}
+	if report.Stack != "" {
+		event["extra"] = map[string]string{"stack": report.Stack}
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=estafette-gcloud-quota-exporter/%v, sentry_key=%v", version, r.publicKey))
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Sentry returned status %v: %v", resp.StatusCode, string(message))
+	}
+
+	return nil
+}
+
+// cloudErrorReporter reports errors to google cloud error reporting in the project
+type cloudErrorReporter struct {
+	service *clouderrorreporting.Service
+	project string
+}
+
+func (r *cloudErrorReporter) Name() string {
+	return "cloud error reporting in project " + r.project
+}
+
+func (r *cloudErrorReporter) Report(ctx context.Context, report errorReport) error {
+
+	// error reporting groups by the stack trace if there is one, otherwise by the report location
+	message := report.Message
+	if report.Stack != "" {
+		message += "\n" + report.Stack
+	}
+
+	tags := []string{}
+	for key, value := range report.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	if len(tags) > 0 {
+		message = fmt.Sprintf("%v [%v]", message, strings.Join(tags, " "))
+	}
+
+	_, err := r.service.Projects.Events.Report("projects/"+r.project, &clouderrorreporting.ReportedErrorEvent{
+		EventTime: report.Timestamp.UTC().Format(time.RFC3339Nano),
+		Message:   message,
+		ServiceContext: &clouderrorreporting.ServiceContext{
+			Service: "estafette-gcloud-quota-exporter",
+			Version: version,
+		},
+		Context: &clouderrorreporting.ErrorContext{
+			User: report.Tags["project"],
+			ReportLocation: &clouderrorreporting.SourceLocation{
+				FilePath:     report.File,
+				LineNumber:   int64(report.Line),
+				FunctionName: report.Function,
+			},
+		},
+	}).Context(ctx).Do()
+
+	return err
+}
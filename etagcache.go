@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create counter for conditional requests answered with 304 not modified
+	etagCacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_etag_cache_hits_total",
+		Help: "The number of compute api calls answered with 304 not modified, served from the response cached with its etag.",
+	}, []string{"call"})
+
+	// computeETags is shared by all compute clients, so it survives clients being recreated on credential changes
+	computeETags = &etagCache{entries: map[string]etagCacheEntry{}}
+)
+
+func init() {
+	prometheus.MustRegister(etagCacheHitsTotal)
+}
+
+// etagCache keeps the last response per call and resource with its etag, to make conditional requests
+type etagCache struct {
+	entries map[string]etagCacheEntry
+	mutex   sync.RWMutex
+}
+
+type etagCacheEntry struct {
+	etag  string
+	value interface{}
+}
+
+// etag returns the etag to send as If-None-Match for the key, or an empty string if nothing is cached
+func (c *etagCache) etag(key string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.entries[key].etag
+}
+
+// hit returns the cached response for the key after a 304 not modified
+func (c *etagCache) hit(call, key string) (interface{}, bool) {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+
+	if ok {
+		etagCacheHitsTotal.WithLabelValues(call).Inc()
+	}
+
+	return entry.value, ok
+}
+
+// set caches the response for the key; responses without etag can't be requested conditionally, so they aren't kept
+func (c *etagCache) set(key, etag string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if etag == "" {
+		delete(c.entries, key)
+		return
+	}
+	c.entries[key] = etagCacheEntry{etag: etag, value: value}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// externalAccountConfig holds the fields of an external_account credentials file used for workload identity federation, see
+// https://google.aip.dev/auth/4117
+type externalAccountConfig struct {
+	Audience                       string                   `json:"audience"`
+	SubjectTokenType               string                   `json:"subject_token_type"`
+	TokenURL                       string                   `json:"token_url"`
+	ServiceAccountImpersonationURL string                   `json:"service_account_impersonation_url"`
+	CredentialSource               externalCredentialSource `json:"credential_source"`
+}
+
+type externalCredentialSource struct {
+	// file or url sourced oidc / saml tokens
+	File    string            `json:"file"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+
+	// aws sourced tokens
+	EnvironmentID               string `json:"environment_id"`
+	RegionURL                   string `json:"region_url"`
+	RegionalCredVerificationURL string `json:"regional_cred_verification_url"`
+	IMDSv2SessionTokenURL       string `json:"imdsv2_session_token_url"`
+}
+
+// externalAccountTokenSource exchanges a token from an external identity provider for a google access token via the security token service
+type externalAccountTokenSource struct {
+	ctx    context.Context
+	config externalAccountConfig
+	scopes []string
+	client *http.Client
+}
+
+func newExternalAccountTokenSource(ctx context.Context, data []byte, scopes []string) (oauth2.TokenSource, error) {
+
+	var config externalAccountConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	if config.TokenURL == "" {
+		config.TokenURL = "https://sts.googleapis.com/v1/token"
+	}
+	if config.CredentialSource.File == "" && config.CredentialSource.URL == "" && config.CredentialSource.EnvironmentID == "" {
+		return nil, fmt.Errorf("External account credentials have no file, url or environment_id credential source")
+	}
+	if config.CredentialSource.EnvironmentID != "" && !strings.HasPrefix(config.CredentialSource.EnvironmentID, "aws") {
+		return nil, fmt.Errorf("External account credential source environment %v is not supported", config.CredentialSource.EnvironmentID)
+	}
+
+	return oauth2.ReuseTokenSource(nil, &externalAccountTokenSource{
+		ctx:    ctx,
+		config: config,
+		scopes: scopes,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}), nil
+}
+
+func (ts *externalAccountTokenSource) Token() (*oauth2.Token, error) {
+
+	subjectToken, err := ts.subjectToken()
+	if err != nil {
+		return nil, fmt.Errorf("Retrieving external subject token failed: %v", err)
+	}
+
+	// when impersonating the federated token only needs to be able to call the iam credentials api
+	scopes := ts.scopes
+	if ts.config.ServiceAccountImpersonationURL != "" {
+		scopes = []string{compute.CloudPlatformScope}
+	}
+
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {ts.config.Audience},
+		"scope":                {strings.Join(scopes, " ")},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {ts.config.SubjectTokenType},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ts.client.Do(req.WithContext(ts.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Exchanging external token failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, fmt.Errorf("Exchanging external token failed: %v", err)
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	token := &oauth2.Token{
+		AccessToken: response.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	}
+
+	if ts.config.ServiceAccountImpersonationURL == "" {
+		return token, nil
+	}
+
+	client := oauth2.NewClient(ts.ctx, oauth2.StaticTokenSource(token))
+
+	return generateAccessToken(ts.ctx, client, ts.config.ServiceAccountImpersonationURL, ts.scopes, time.Hour)
+}
+
+// subjectToken retrieves the token issued by the external identity provider
+func (ts *externalAccountTokenSource) subjectToken() (string, error) {
+
+	source := ts.config.CredentialSource
+
+	if source.EnvironmentID != "" {
+		return ts.awsSubjectToken()
+	}
+
+	var data []byte
+	var err error
+
+	if source.File != "" {
+		data, err = ioutil.ReadFile(source.File)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		data, err = ts.get(source.URL, source.Headers)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if source.Format.Type != "json" {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	token, ok := fields[source.Format.SubjectTokenFieldName].(string)
+	if !ok || token == "" {
+		return "", fmt.Errorf("Field %v with subject token is missing", source.Format.SubjectTokenFieldName)
+	}
+
+	return token, nil
+}
+
+// awsSubjectToken creates a signed GetCallerIdentity request, which google verifies with aws to establish the identity
+func (ts *externalAccountTokenSource) awsSubjectToken() (string, error) {
+
+	source := ts.config.CredentialSource
+
+	headers := map[string]string{}
+	if source.IMDSv2SessionTokenURL != "" {
+		sessionToken, err := ts.request(http.MethodPut, source.IMDSv2SessionTokenURL, map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "300"})
+		if err != nil {
+			return "", fmt.Errorf("Retrieving aws metadata session token failed: %v", err)
+		}
+		headers["X-aws-ec2-metadata-token"] = string(sessionToken)
+	}
+
+	region := getEnvFirst("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		zone, err := ts.get(source.RegionURL, headers)
+		if err != nil {
+			return "", fmt.Errorf("Retrieving aws region failed: %v", err)
+		}
+		// the metadata server returns the availability zone, like us-east-1b
+		region = strings.TrimSpace(string(zone))
+		region = region[:len(region)-1]
+	}
+
+	credentials := awsCredentialsFromEnv()
+	if credentials == nil {
+		role, err := ts.get(source.URL, headers)
+		if err != nil {
+			return "", fmt.Errorf("Retrieving aws role name failed: %v", err)
+		}
+		data, err := ts.get(source.URL+"/"+strings.TrimSpace(string(role)), headers)
+		if err != nil {
+			return "", fmt.Errorf("Retrieving aws role credentials failed: %v", err)
+		}
+		credentials = &awsCredentials{}
+		if err := json.Unmarshal(data, credentials); err != nil {
+			return "", err
+		}
+	}
+
+	verificationURL := strings.Replace(source.RegionalCredVerificationURL, "{region}", region, -1)
+	req, err := http.NewRequest(http.MethodPost, verificationURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("x-goog-cloud-target-resource", ts.config.Audience)
+	signAWSRequest(req, nil, *credentials, region, "sts", time.Now())
+
+	type header struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	signedHeaders := []header{{Key: "host", Value: req.URL.Host}}
+	for key := range req.Header {
+		signedHeaders = append(signedHeaders, header{Key: key, Value: req.Header.Get(key)})
+	}
+
+	token, err := json.Marshal(map[string]interface{}{
+		"url":     verificationURL,
+		"method":  req.Method,
+		"headers": signedHeaders,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return url.QueryEscape(string(token)), nil
+}
+
+func (ts *externalAccountTokenSource) get(url string, headers map[string]string) ([]byte, error) {
+	return ts.request(http.MethodGet, url, headers)
+}
+
+func (ts *externalAccountTokenSource) request(method, url string, headers map[string]string) ([]byte, error) {
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := ts.client.Do(req.WithContext(ts.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v %v returned status %v: %v", method, url, resp.StatusCode, string(data))
+	}
+
+	return data, nil
+}
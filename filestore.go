@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the filestore instances and their capacity per region and tier
+	filestoreInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_filestore_instances",
+		Help: "The number of filestore instances per region and tier.",
+	}, []string{"project", "region", "tier"})
+
+	filestoreCapacityGB = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_filestore_capacity_gb",
+		Help: "The provisioned capacity of all file shares of filestore instances per region and tier.",
+	}, []string{"project", "region", "tier"})
+
+	// create gauge for the filestore quota, which is named after tier and kind, like standard_capacity_gb
+	filestoreQuotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_filestore_limit",
+		Help: "The limit of a filestore quota as reported by the service usage api, with region global for quota that isn't regional; -1 is unlimited.",
+	}, []string{"project", "region", "quota_metric", "unit"})
+)
+
+func init() {
+	prometheus.MustRegister(filestoreInstances)
+	prometheus.MustRegister(filestoreCapacityGB)
+	prometheus.MustRegister(filestoreQuotaLimit)
+
+	registerQuotaSource("filestore", func(clients clientProvider, regions []string) quotaSource {
+		return &filestoreQuotaSource{clients: clients, regions: regions}
+	})
+}
+
+// filestoreQuotaSource exports the filestore instances and capacity per region and tier next to the filestore quota; the usage isn't
+// reported to cloud monitoring, so it's counted from the instances instead
+type filestoreQuotaSource struct {
+	clients clientProvider
+	regions []string
+}
+
+// filestoreKey identifies the filestore instances of a tier in a region
+type filestoreKey struct {
+	region string
+	tier   string
+}
+
+func (s *filestoreQuotaSource) Name() string {
+	return "filestore"
+}
+
+// Discover doesn't return locations, instances in all locations are listed at once
+func (s *filestoreQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *filestoreQuotaSource) Permissions() []string {
+	return []string{"file.instances.list", "serviceusage.quotas.get"}
+}
+
+func (s *filestoreQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[filestoreKey]float64{}
+	capacityGB := map[filestoreKey]float64{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("fields", "instances(name,tier,fileShares/capacityGb),unreachable,nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Instances []struct {
+				Name       string `json:"name"`
+				Tier       string `json:"tier"`
+				FileShares []struct {
+					CapacityGb string `json:"capacityGb"`
+				} `json:"fileShares"`
+			} `json:"instances"`
+			Unreachable   []string `json:"unreachable"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://file.googleapis.com/v1/projects/%v/locations/-/instances?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		// instances in unreachable locations are left out of the list; since the usage is counted from the instances, exporting it
+		// would understate the capacity in use against the quota
+		if len(response.Unreachable) > 0 {
+			return nil, fmt.Errorf("Listing filestore instances for project %v returned a partial list, locations %v are missing", project, strings.Join(response.Unreachable, ", "))
+		}
+
+		for _, instance := range response.Instances {
+			// names are like projects/p/locations/europe-west1-b/instances/i, with a zone or region as location
+			segments := strings.Split(instance.Name, "/")
+			if len(segments) != 6 {
+				return nil, fmt.Errorf("Parsing filestore instance name %v failed", instance.Name)
+			}
+			region := regionFromLocation(segments[3])
+			if len(s.regions) > 0 && !stringInSlice(s.regions, region) {
+				continue
+			}
+
+			key := filestoreKey{region: region, tier: strings.ToLower(instance.Tier)}
+			instances[key]++
+			for _, share := range instance.FileShares {
+				if capacity, err := strconv.ParseFloat(share.CapacityGb, 64); err == nil {
+					capacityGB[key] += capacity
+				}
+			}
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing filestore instances failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	buckets, err := listConsumerQuotaBuckets(ctx, httpClient, project, "file.googleapis.com")
+	if err != nil {
+		return nil, err
+	}
+
+	for key, count := range instances {
+		setGauge(ctx, filestoreInstances, count, project, key.region, key.tier)
+		setGauge(ctx, filestoreCapacityGB, capacityGB[key], project, key.region, key.tier)
+	}
+
+	for _, bucket := range buckets {
+		region, ok := quotaDimensionsRegion(bucket.Dimensions, s.regions)
+		if !ok {
+			continue
+		}
+		value, ok := sanitizeQuotaValue("gcloud", project, bucket.Metric, "limit", float64(bucket.EffectiveLimit))
+		if !ok {
+			continue
+		}
+		setGauge(ctx, filestoreQuotaLimit, value, project, region, bucket.Metric, bucket.Unit)
+	}
+
+	// filestore quota isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
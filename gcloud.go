@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// configuredAPIPolicies holds the timeout and retry settings applied to google cloud api calls
+var configuredAPIPolicies apiPolicies
+
+// apiLimiter caps the rate of google cloud api calls over all projects if --gcp-api-qps is set
+var apiLimiter *tokenBucket
+
+// rateLimitTransport waits for a token of the shared limiter before each call
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *tokenBucket
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newGoogleClient creates an authenticated http client for the google cloud apis with all configured transport wrappers applied;
+// it uses the application default credentials if credentialsFile is empty, and no credentials at all when replaying recorded responses
+func newGoogleClient(ctx context.Context, credentialsFile string) (*http.Client, error) {
+
+	if *replayDir != "" {
+		return &http.Client{Transport: &replayTransport{dir: *replayDir}}, nil
+	}
+
+	tokenSource, err := newTokenSource(ctx, credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client := oauth2.NewClient(ctx, tokenSource)
+
+	client.Transport = &userAgentTransport{base: client.Transport, userAgent: buildUserAgent(*userAgent, *deploymentName)}
+
+	if auditLogger != nil {
+		client.Transport = &auditTransport{base: client.Transport, logger: auditLogger}
+	}
+
+	client.Transport = &apiLogTransport{base: client.Transport}
+
+	// trace each attempt, so retries show up as separate spans
+	if tracer != nil {
+		client.Transport = &tracingTransport{base: client.Transport}
+	}
+
+	// limit each attempt, so retries count against the rate as well
+	if apiLimiter != nil {
+		client.Transport = &rateLimitTransport{base: client.Transport, limiter: apiLimiter}
+	}
+
+	client.Transport = &retryTransport{base: client.Transport, policies: configuredAPIPolicies}
+
+	if *recordDir != "" {
+		client.Transport = &recordTransport{base: client.Transport, dir: *recordDir}
+	}
+
+	return client, nil
+}
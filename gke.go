@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// defaultGKELimits are the documented gke limits, which aren't exposed by any api; they can be overridden with --gke-limit for
+// projects that got them raised
+var defaultGKELimits = map[string]float64{
+	"clusters_per_location":    100,
+	"nodes_per_cluster":        15000,
+	"autopilot_nodes":          5000,
+	"node_pools_per_cluster":   15,
+	"nodes_per_node_pool_zone": 1000,
+}
+
+// configuredGKELimits are the default gke limits with the --gke-limit overrides applied
+var configuredGKELimits = defaultGKELimits
+
+var (
+	// create gauges for the limit and actual count of gke resources with a fixed ceiling
+	gkeLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_gke_limit",
+		Help: "The gke limit for clusters per location, nodes per cluster, node pools per cluster or nodes per node pool; cluster and nodepool are empty for limits that don't apply to them.",
+	}, []string{"project", "location", "cluster", "nodepool", "metric"})
+
+	gkeUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_gke_usage",
+		Help: "The number of clusters per location, nodes per cluster, node pools per cluster or nodes per node pool counting against the gke limit.",
+	}, []string{"project", "location", "cluster", "nodepool", "metric"})
+)
+
+func init() {
+	prometheus.MustRegister(gkeLimit)
+	prometheus.MustRegister(gkeUsage)
+
+	registerQuotaSource("gke", func(clients clientProvider, regions []string) quotaSource {
+		return &gkeQuotaSource{clients: clients, regions: regions, limits: configuredGKELimits}
+	})
+}
+
+// gkeClusterList is the part of the clusters list of the gke api the gke collector uses
+type gkeClusterList struct {
+	Clusters []struct {
+		Name      string `json:"name"`
+		Location  string `json:"location"`
+		Autopilot *struct {
+			Enabled bool `json:"enabled"`
+		} `json:"autopilot"`
+		NodePools []struct {
+			Name              string   `json:"name"`
+			InstanceGroupUrls []string `json:"instanceGroupUrls"`
+		} `json:"nodePools"`
+	} `json:"clusters"`
+	MissingZones []string `json:"missingZones"`
+}
+
+// gkeQuotaSource exports the gke specific ceilings next to the actual counts, since the compute quota doesn't show when a project is
+// about to run out of clusters in a location or a cluster out of nodes or node pools
+type gkeQuotaSource struct {
+	clients clientProvider
+	regions []string
+	limits  map[string]float64
+}
+
+func (s *gkeQuotaSource) Name() string {
+	return "gke"
+}
+
+// Discover doesn't return locations, clusters in all locations are retrieved in one call
+func (s *gkeQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *gkeQuotaSource) Permissions() []string {
+	return []string{"container.clusters.list", "compute.instanceGroupManagers.list"}
+}
+
+func (s *gkeQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := compute.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// the vendored container client predates cluster locations and autopilot, so the rest api is called directly
+	var clusters gkeClusterList
+	err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://container.googleapis.com/v1/projects/%v/locations/-/clusters", project), &clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	// clusters in unreachable zones are left out of the list, which would report fewer clusters and nodes against the quota than
+	// there are, so the whole list is discarded instead
+	if len(clusters.MissingZones) > 0 {
+		return nil, fmt.Errorf("Listing clusters for project %v returned a partial list, zones %v are missing", project, strings.Join(clusters.MissingZones, ", "))
+	}
+
+	// the node count of a node pool is the target size of its instance groups, retrieve them all at once instead of per node pool
+	targetSizes := map[string]int64{}
+	err = computeService.InstanceGroupManagers.AggregatedList(project).Fields("items/*/instanceGroupManagers/selfLink", "items/*/instanceGroupManagers/targetSize", "nextPageToken").Pages(ctx, func(page *compute.InstanceGroupManagerAggregatedList) error {
+		for _, list := range page.Items {
+			for _, igm := range list.InstanceGroupManagers {
+				targetSizes[instanceGroupManagerPath(igm.SelfLink)] = igm.TargetSize
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clustersPerLocation := map[string]float64{}
+	for _, cluster := range clusters.Clusters {
+		if len(s.regions) > 0 && !stringInSlice(s.regions, regionFromLocation(cluster.Location)) {
+			continue
+		}
+		clustersPerLocation[cluster.Location]++
+
+		nodesLimit := s.limits["nodes_per_cluster"]
+		if cluster.Autopilot != nil && cluster.Autopilot.Enabled {
+			nodesLimit = s.limits["autopilot_nodes"]
+		}
+
+		clusterNodes := 0.0
+		for _, pool := range cluster.NodePools {
+			poolNodes := 0.0
+			for _, url := range pool.InstanceGroupUrls {
+				poolNodes += float64(targetSizes[instanceGroupManagerPath(url)])
+			}
+			clusterNodes += poolNodes
+
+			// the node limit applies per zone, so a node pool spanning zones can have that many nodes in each
+			setGauge(ctx, gkeLimit, s.limits["nodes_per_node_pool_zone"]*float64(len(pool.InstanceGroupUrls)), project, cluster.Location, cluster.Name, pool.Name, "nodes_per_node_pool")
+			setGauge(ctx, gkeUsage, poolNodes, project, cluster.Location, cluster.Name, pool.Name, "nodes_per_node_pool")
+		}
+
+		setGauge(ctx, gkeLimit, nodesLimit, project, cluster.Location, cluster.Name, "", "nodes_per_cluster")
+		setGauge(ctx, gkeUsage, clusterNodes, project, cluster.Location, cluster.Name, "", "nodes_per_cluster")
+
+		// autopilot manages node pools itself, so the node pool limit doesn't apply
+		if cluster.Autopilot == nil || !cluster.Autopilot.Enabled {
+			setGauge(ctx, gkeLimit, s.limits["node_pools_per_cluster"], project, cluster.Location, cluster.Name, "", "node_pools_per_cluster")
+			setGauge(ctx, gkeUsage, float64(len(cluster.NodePools)), project, cluster.Location, cluster.Name, "", "node_pools_per_cluster")
+		}
+	}
+
+	for location, count := range clustersPerLocation {
+		setGauge(ctx, gkeLimit, s.limits["clusters_per_location"], project, location, "", "", "clusters_per_location")
+		setGauge(ctx, gkeUsage, count, project, location, "", "", "clusters_per_location")
+	}
+
+	// the gke limits aren't compute quota, so they're only exported as metrics
+	return nil, nil
+}
+
+// parseGKELimits applies overrides given as metric=value, like nodes_per_cluster=5000, to the default gke limits
+func parseGKELimits(overrides []string) (map[string]float64, error) {
+
+	limits := map[string]float64{}
+	for metric, value := range defaultGKELimits {
+		limits[metric] = value
+	}
+
+	for _, o := range overrides {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Gke limit %v should be formatted as metric=value", o)
+		}
+		if _, ok := defaultGKELimits[parts[0]]; !ok {
+			return nil, fmt.Errorf("Gke limit %v is unknown, it should be one of clusters_per_location, nodes_per_cluster, autopilot_nodes, node_pools_per_cluster or nodes_per_node_pool_zone", parts[0])
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("Gke limit %v should have a positive value", o)
+		}
+		limits[parts[0]] = value
+	}
+
+	return limits, nil
+}
+
+// instanceGroupManagerPath returns the part of an instance group or instance group manager url from the project on, so the urls of
+// node pools match the self links of instance group managers regardless of api version
+func instanceGroupManagerPath(url string) string {
+
+	path := url[strings.Index(url, "/projects/")+1:]
+	return strings.Replace(path, "/instanceGroups/", "/instanceGroupManagers/", 1)
+}
+
+// regionFromLocation returns the region of a zone like europe-west1-b, or the location itself if it's a region
+func regionFromLocation(location string) string {
+
+	if strings.Count(location, "-") < 2 {
+		return location
+	}
+
+	return location[:strings.LastIndex(location, "-")]
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+)
+
+var (
+	// create gauge for the number of nodes the autoscaler could still add to a node pool
+	gkeAutoscalerHeadroom = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_gke_autoscaler_headroom_nodes",
+		Help: "The number of nodes the autoscaler could still add to the node pool, given its maximum size and the remaining cpu and ip address quota.",
+	}, []string{"project", "cluster", "location", "nodepool"})
+
+	// create gauge for the headroom per constraint, to see which one limits the node pool
+	gkeAutoscalerHeadroomByConstraint = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_gke_autoscaler_headroom_nodes_by_constraint",
+		Help: "The number of nodes that could be added to the node pool when only taking one constraint into account: max_node_count, cpus or in_use_addresses.",
+	}, []string{"project", "cluster", "location", "nodepool", "constraint"})
+)
+
+func init() {
+	prometheus.MustRegister(gkeAutoscalerHeadroom)
+	prometheus.MustRegister(gkeAutoscalerHeadroomByConstraint)
+
+	registerQuotaSource("gke-autoscaler", func(clients clientProvider, regions []string) quotaSource {
+		return &gkeAutoscalerQuotaSource{clients: clients}
+	})
+}
+
+// gkeAutoscalerQuotaSource combines the maximum size and machine type of autoscaled gke node pools with the remaining regional quota
+// into the number of nodes the autoscaler could actually still add; every node pool is considered on its own, so pools in the same
+// region compete for the same headroom
+type gkeAutoscalerQuotaSource struct {
+	clients clientProvider
+}
+
+func (s *gkeAutoscalerQuotaSource) Name() string {
+	return "gke-autoscaler"
+}
+
+// Discover doesn't return locations, clusters in all locations are retrieved in one call
+func (s *gkeAutoscalerQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *gkeAutoscalerQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+	containerService, err := container.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := compute.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := containerService.Projects.Zones.Clusters.List(project, "-").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	// the clusters list isn't paged, but it's partial if some zones couldn't be reached
+	if len(clusters.MissingZones) > 0 {
+		return nil, fmt.Errorf("Listing clusters for project %v returned a partial list, zones %v are missing", project, strings.Join(clusters.MissingZones, ", "))
+	}
+
+	// regional quota is shared by all clusters in the region, so retrieve it once
+	regionQuota := map[string]map[string]*compute.Quota{}
+
+	for _, cluster := range clusters.Clusters {
+		for _, pool := range cluster.NodePools {
+			if pool.Autoscaling == nil || !pool.Autoscaling.Enabled || len(pool.InstanceGroupUrls) == 0 || pool.Config == nil {
+				continue
+			}
+
+			// there's a managed instance group per zone, each of which can grow to the maximum node count
+			currentNodes := int64(0)
+			region := ""
+			zone := ""
+			for _, url := range pool.InstanceGroupUrls {
+				igmProject, igmZone, igmName, err := parseInstanceGroupManagerURL(url)
+				if err != nil {
+					return nil, err
+				}
+				igm, err := computeService.InstanceGroupManagers.Get(igmProject, igmZone, igmName).Context(ctx).Do()
+				if err != nil {
+					return nil, err
+				}
+				currentNodes += igm.TargetSize
+				zone = igmZone
+				region = zone[:strings.LastIndex(zone, "-")]
+			}
+			maxNodes := pool.Autoscaling.MaxNodeCount * int64(len(pool.InstanceGroupUrls))
+
+			machineType, err := computeService.MachineTypes.Get(project, zone, pool.Config.MachineType).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+
+			quotas, ok := regionQuota[region]
+			if !ok {
+				r, err := s.clients.compute(project).GetRegion(ctx, project, region)
+				if err != nil {
+					return nil, err
+				}
+				quotas = map[string]*compute.Quota{}
+				for _, quota := range r.Quotas {
+					quotas[quota.Metric] = quota
+				}
+				regionQuota[region] = quotas
+			}
+
+			headroom := map[string]float64{
+				"max_node_count":   math.Max(0, float64(maxNodes-currentNodes)),
+				"cpus":             nodesWithinQuota(cpuQuota(quotas, pool.Config.MachineType), float64(machineType.GuestCpus)),
+				"in_use_addresses": nodesWithinQuota(quotas["IN_USE_ADDRESSES"], 1),
+			}
+
+			nodes := math.Inf(1)
+			for constraint, value := range headroom {
+				setGauge(ctx, gkeAutoscalerHeadroomByConstraint, value, project, cluster.Name, cluster.Zone, pool.Name, constraint)
+				nodes = math.Min(nodes, value)
+			}
+			setGauge(ctx, gkeAutoscalerHeadroom, nodes, project, cluster.Name, cluster.Zone, pool.Name)
+		}
+	}
+
+	// the headroom is derived from quota, not a quota itself, so it's only exported as metrics
+	return nil, nil
+}
+
+// cpuQuota returns the cpu quota a machine type counts against: the family specific one like N2_CPUS if it exists, otherwise CPUS
+func cpuQuota(quotas map[string]*compute.Quota, machineType string) *compute.Quota {
+
+	family := strings.ToUpper(strings.SplitN(machineType, "-", 2)[0])
+	if quota, ok := quotas[family+"_CPUS"]; ok {
+		return quota
+	}
+
+	return quotas["CPUS"]
+}
+
+// nodesWithinQuota returns how many nodes using perNode of the quota fit in the remaining quota; missing or unlimited quota doesn't
+// constrain the node pool
+func nodesWithinQuota(quota *compute.Quota, perNode float64) float64 {
+
+	if quota == nil || quota.Limit < 0 || perNode <= 0 {
+		return math.Inf(1)
+	}
+
+	return math.Max(0, math.Floor((quota.Limit-quota.Usage)/perNode))
+}
+
+// parseInstanceGroupManagerURL splits urls like https://www.googleapis.com/compute/v1/projects/p/zones/z/instanceGroupManagers/name
+func parseInstanceGroupManagerURL(url string) (project, zone, name string, err error) {
+
+	segments := strings.Split(url, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		switch segments[i] {
+		case "projects":
+			project = segments[i+1]
+		case "zones":
+			zone = segments[i+1]
+		case "instanceGroupManagers", "instanceGroups":
+			name = segments[i+1]
+		}
+	}
+
+	if project == "" || zone == "" || name == "" || !strings.Contains(zone, "-") {
+		return "", "", "", fmt.Errorf("Parsing instance group manager url %v failed", url)
+	}
+
+	return project, zone, name, nil
+}
@@ -3,7 +3,6 @@ module github.com/estafette/estafette-gcloud-quota-exporter
 go 1.12
 
 require (
-	cloud.google.com/go v0.0.0-20171208125104-b97d3642c5ff // indirect
 	github.com/alecthomas/assert v0.0.0-20170929043011-405dbfeb8e38 // indirect
 	github.com/alecthomas/colour v0.0.0-20160524082231-60882d9e2721 // indirect
 	github.com/alecthomas/kingpin v2.2.5+incompatible
@@ -11,12 +10,16 @@ require (
 	github.com/estafette/estafette-foundation v0.0.52
 	github.com/fatih/camelcase v1.0.0 // indirect
 	github.com/fsnotify/fsnotify v1.4.7
+	github.com/golang/protobuf v1.2.0
+	github.com/golang/snappy v0.0.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/mattn/go-isatty v0.0.6 // indirect
 	github.com/pinzolo/casee v0.0.0-20160729104318-956b6baf666a
 	github.com/prometheus/client_golang v0.9.2
+	github.com/prometheus/client_model v0.0.0-20190115171406-56726106282f
 	github.com/rs/zerolog v1.17.2
 	github.com/sergi/go-diff v1.0.0 // indirect
-	golang.org/x/oauth2 v0.0.0-20171206205713-6a2004c8907a
+	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
 	google.golang.org/api v0.0.0-20171208000347-fb1d4474b70b
-	google.golang.org/appengine v0.0.0-20171031194329-9d8544a6b2c7 // indirect
+	google.golang.org/grpc v1.19.1
 )
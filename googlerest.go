@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// getGoogleJSON calls a google cloud rest api that isn't part of the vendored client libraries; failures are returned as
+// *googleapi.Error, so they're handled the same as errors from the client libraries
+func getGoogleJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	return sendGoogleJSON(ctx, client, http.MethodGet, url, nil, v)
+}
+
+// sendGoogleJSON calls a google cloud rest api like getGoogleJSON, sending in as json body if it isn't nil
+func sendGoogleJSON(ctx context.Context, client *http.Client, method, url string, in, out interface{}) error {
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer googleapi.CloseBody(resp)
+
+	err = googleapi.CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// handleGrafanaDashboard generates a grafana dashboard for the quota metrics discovered in the last fetch cycle; with the project
+// query parameter it has a panel per metric and region for that project, otherwise it's an overview of the utilization across projects
+func (s *adminServer) handleGrafanaDashboard(w http.ResponseWriter, r *http.Request) {
+
+	latest := quotaUpdates.snapshot()
+
+	if len(latest) == 0 {
+		http.Error(w, "No quota has been fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	project := r.URL.Query().Get("project")
+	if project != "" {
+		latest = filterQuotaUpdates(latest, []string{project}, nil)
+		if len(latest) == 0 {
+			http.Error(w, fmt.Sprintf("No quota has been fetched for project %v", project), http.StatusNotFound)
+			return
+		}
+	}
+
+	writeJSON(w, generateGrafanaDashboard(latest, project))
+}
+
+// generateGrafanaDashboard creates the dashboard json for the quota updates
+func generateGrafanaDashboard(updates []quotaUpdate, project string) map[string]interface{} {
+
+	// collect the discovered metrics per scope, with global quota under the empty region; quota of other providers isn't exported
+	// with the estafette_gcloud metrics the panels query
+	metricsPerRegion := map[string]map[string]bool{}
+	for _, update := range updates {
+		if update.Provider != "" {
+			continue
+		}
+		if _, ok := metricsPerRegion[update.Region]; !ok {
+			metricsPerRegion[update.Region] = map[string]bool{}
+		}
+		metricsPerRegion[update.Region][update.Metric] = true
+	}
+
+	regions := []string{}
+	for region := range metricsPerRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	panels := []map[string]interface{}{}
+	id := 1
+	y := 0
+
+	for _, region := range regions {
+
+		title := "Global quota"
+		if region != "" {
+			title = "Regional quota | " + region
+		}
+		if project != "" {
+			title += " | " + project
+		}
+		panels = append(panels, map[string]interface{}{
+			"id":        id,
+			"type":      "row",
+			"title":     title,
+			"collapsed": false,
+			"gridPos":   map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+		})
+		id++
+		y++
+
+		metrics := []string{}
+		for metric := range metricsPerRegion[region] {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+
+		for i, metric := range metrics {
+			panels = append(panels, grafanaUtilizationPanel(id, metric, region, project, (i%3)*8, y+(i/3)*8))
+			id++
+		}
+		y += ((len(metrics) + 2) / 3) * 8
+	}
+
+	title := "Google Cloud Quota | Overview"
+	if project != "" {
+		title = "Google Cloud Quota | " + project
+	}
+
+	return map[string]interface{}{
+		"__inputs": []map[string]string{
+			{
+				"name":        "DS_PROMETHEUS",
+				"label":       "prometheus",
+				"description": "",
+				"type":        "datasource",
+				"pluginId":    "prometheus",
+				"pluginName":  "Prometheus",
+			},
+		},
+		"title":         title,
+		"tags":          []string{"gcloud", "quota"},
+		"editable":      true,
+		"schemaVersion": 27,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"panels":        panels,
+	}
+}
+
+// grafanaUtilizationPanel shows usage divided by limit for one metric, per region for a single project or per project in the overview
+func grafanaUtilizationPanel(id int, metric, region, project string, x, y int) map[string]interface{} {
+
+	selector := fmt.Sprintf(`metric="%v"`, metric)
+	if project != "" {
+		selector += fmt.Sprintf(`,project="%v"`, project)
+	}
+
+	expr := fmt.Sprintf(`estafette_gcloud_global_quota_usage{%v} / estafette_gcloud_global_quota_limit{%v}`, selector, selector)
+	if region != "" {
+		selector += fmt.Sprintf(`,region="%v"`, region)
+		expr = fmt.Sprintf(`estafette_gcloud_regional_quota_usage{%v} / estafette_gcloud_regional_quota_limit{%v}`, selector, selector)
+	}
+
+	legend := "{{project}}"
+	if project != "" {
+		legend = metric
+	}
+
+	return map[string]interface{}{
+		"id":         id,
+		"type":       "timeseries",
+		"title":      metric,
+		"datasource": "${DS_PROMETHEUS}",
+		"gridPos":    map[string]int{"h": 8, "w": 8, "x": x, "y": y},
+		"targets": []map[string]interface{}{
+			{
+				"expr":         expr,
+				"legendFormat": legend,
+				"refId":        "A",
+			},
+		},
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"unit": "percentunit",
+				"min":  0,
+				"max":  1,
+				"thresholds": map[string]interface{}{
+					"mode": "absolute",
+					"steps": []map[string]interface{}{
+						{"color": "green", "value": nil},
+						{"color": "orange", "value": 0.8},
+						{"color": "red", "value": 0.9},
+					},
+				},
+			},
+		},
+	}
+}
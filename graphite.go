@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/graphite"
+	"github.com/rs/zerolog/log"
+)
+
+// graphiteLogger passes errors of the graphite bridge on to zerolog
+type graphiteLogger struct{}
+
+func (graphiteLogger) Println(v ...interface{}) {
+	log.Warn().Msg(fmt.Sprint(v...))
+}
+
+// startGraphiteBridge pushes all registered metrics to a graphite server using the plaintext protocol at the interval until the context is done
+func startGraphiteBridge(ctx context.Context, address, prefix string, interval time.Duration) error {
+
+	bridge, err := graphite.NewBridge(&graphite.Config{
+		URL:           address,
+		Gatherer:      metricsGatherer,
+		Prefix:        prefix,
+		Interval:      interval,
+		Timeout:       10 * time.Second,
+		Logger:        graphiteLogger{},
+		ErrorHandling: graphite.ContinueOnError,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Pushing metrics to graphite %v every %v", address, interval)
+
+	go bridge.Run(ctx)
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// the messages below mirror proto/quota.proto
+
+// WatchQuotasRequest selects the quota to stream
+type WatchQuotasRequest struct {
+	Projects []string `protobuf:"bytes,1,rep,name=projects,proto3" json:"projects,omitempty"`
+	Metrics  []string `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty"`
+}
+
+func (m *WatchQuotasRequest) Reset()         { *m = WatchQuotasRequest{} }
+func (m *WatchQuotasRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchQuotasRequest) ProtoMessage()    {}
+
+// QuotaUpdates holds the quota of a single collection cycle
+type QuotaUpdates struct {
+	Quotas []*Quota `protobuf:"bytes,1,rep,name=quotas,proto3" json:"quotas,omitempty"`
+}
+
+func (m *QuotaUpdates) Reset()         { *m = QuotaUpdates{} }
+func (m *QuotaUpdates) String() string { return proto.CompactTextString(m) }
+func (*QuotaUpdates) ProtoMessage()    {}
+
+// Quota is the limit and usage of a single quota
+type Quota struct {
+	Project       string  `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
+	Region        string  `protobuf:"bytes,2,opt,name=region,proto3" json:"region,omitempty"`
+	Metric        string  `protobuf:"bytes,3,opt,name=metric,proto3" json:"metric,omitempty"`
+	Limit         float64 `protobuf:"fixed64,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	Usage         float64 `protobuf:"fixed64,5,opt,name=usage,proto3" json:"usage,omitempty"`
+	TimestampUnix int64   `protobuf:"varint,6,opt,name=timestamp_unix,json=timestampUnix,proto3" json:"timestamp_unix,omitempty"`
+}
+
+func (m *Quota) Reset()         { *m = Quota{} }
+func (m *Quota) String() string { return proto.CompactTextString(m) }
+func (*Quota) ProtoMessage()    {}
+
+// quotaServiceServer is the server api of the QuotaService
+type quotaServiceServer interface {
+	WatchQuotas(*WatchQuotasRequest, grpc.ServerStream) error
+}
+
+var quotaServiceDesc = grpc.ServiceDesc{
+	ServiceName: "estafette.gcloudquota.v1.QuotaService",
+	HandlerType: (*quotaServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchQuotas",
+			Handler:       watchQuotasHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/quota.proto",
+}
+
+func watchQuotasHandler(srv interface{}, stream grpc.ServerStream) error {
+	request := new(WatchQuotasRequest)
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return srv.(quotaServiceServer).WatchQuotas(request, stream)
+}
+
+// quotaService streams the updates of the quota broadcaster to grpc clients
+type quotaService struct{}
+
+func (quotaService) WatchQuotas(request *WatchQuotasRequest, stream grpc.ServerStream) error {
+
+	updates, latest := quotaUpdates.subscribe()
+	defer quotaUpdates.unsubscribe(updates)
+
+	send := func(cycle []quotaUpdate) error {
+		filtered := filterQuotaUpdates(cycle, request.Projects, request.Metrics)
+		if len(filtered) == 0 {
+			return nil
+		}
+
+		message := &QuotaUpdates{}
+		for _, update := range filtered {
+			message.Quotas = append(message.Quotas, &Quota{
+				Project:       update.Project,
+				Region:        update.Region,
+				Metric:        update.Metric,
+				Limit:         update.Limit,
+				Usage:         update.Usage,
+				TimestampUnix: update.Timestamp.Unix(),
+			})
+		}
+
+		return stream.SendMsg(message)
+	}
+
+	if err := send(latest); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case cycle := <-updates:
+			if err := send(cycle); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// initGRPCServer serves the QuotaService on the configured address, requiring the same bearer token as the metrics endpoint; it's
+// disabled if the address is empty
+func initGRPCServer(tokenValidator *bearerTokenValidator) {
+
+	if *grpcListenAddress == "" {
+		return
+	}
+
+	server := grpc.NewServer(grpc.StreamInterceptor(tokenValidator.streamInterceptor))
+	server.RegisterService(&quotaServiceDesc, quotaService{})
+
+	go func() {
+		log.Debug().
+			Str("address", *grpcListenAddress).
+			Msg("Serving grpc QuotaService...")
+
+		listener, err := net.Listen("tcp", *grpcListenAddress)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Starting grpc listener failed")
+		}
+
+		if err := server.Serve(listener); err != nil {
+			log.Fatal().Err(err).Msg("Serving grpc failed")
+		}
+	}()
+}
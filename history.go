@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// historyKey identifies a single quota; provider is empty for google cloud and region is empty for global quota
+type historyKey struct {
+	Provider string
+	Project  string
+	Region   string
+	Metric   string
+}
+
+type historyPoint struct {
+	Timestamp time.Time
+	Usage     float64
+	Limit     float64
+}
+
+// historyStore keeps the quota of past fetch cycles in memory for the retention period
+type historyStore struct {
+	retention time.Duration
+	series    map[historyKey][]historyPoint
+	mutex     sync.RWMutex
+}
+
+func newHistoryStore(retention time.Duration) *historyStore {
+	return &historyStore{
+		retention: retention,
+		series:    map[historyKey][]historyPoint{},
+	}
+}
+
+// run records every published fetch cycle
+func (h *historyStore) run() {
+
+	updates, latest := quotaUpdates.subscribe()
+
+	h.record(latest)
+	for cycle := range updates {
+		h.record(cycle)
+	}
+}
+
+// record adds the updates of a cycle and drops points older than the retention, including series that haven't been seen since
+func (h *historyStore) record(updates []quotaUpdate) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, update := range updates {
+		key := historyKey{update.Provider, update.Project, update.Region, update.Metric}
+
+		// quota of failed projects is carried over into the published cycle with the timestamp it was retrieved at, which is
+		// already recorded
+		points := h.series[key]
+		if len(points) > 0 && points[len(points)-1].Timestamp.Equal(update.Timestamp) {
+			continue
+		}
+
+		h.series[key] = append(points, historyPoint{Timestamp: update.Timestamp, Usage: update.Usage, Limit: update.Limit})
+	}
+
+	cutoff := time.Now().Add(-h.retention)
+	for key, points := range h.series {
+		i := 0
+		for i < len(points) && points[i].Timestamp.Before(cutoff) {
+			i++
+		}
+		if i == len(points) {
+			delete(h.series, key)
+			continue
+		}
+		if i > 0 {
+			h.series[key] = append([]historyPoint{}, points[i:]...)
+		}
+	}
+}
+
+// project returns a copy of the history of all quota of the project
+func (h *historyStore) project(project string) map[historyKey][]historyPoint {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	series := map[historyKey][]historyPoint{}
+	for key, points := range h.series {
+		if key.Project == project {
+			series[key] = append([]historyPoint{}, points...)
+		}
+	}
+
+	return series
+}
+
+// projects returns the projects there's history for
+func (h *historyStore) projects() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	seen := map[string]bool{}
+	projects := []string{}
+	for key := range h.series {
+		if !seen[key.Project] {
+			seen[key.Project] = true
+			projects = append(projects, key.Project)
+		}
+	}
+
+	return projects
+}
+
+// timeToExhaustion extrapolates the usage trend with a least squares fit to the moment usage reaches the limit; it returns false if
+// usage isn't growing, the limit is unlimited or there are too few points to tell
+func timeToExhaustion(points []historyPoint) (time.Duration, bool) {
+
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	last := points[len(points)-1]
+	if last.Limit < 0 {
+		return 0, false
+	}
+	if last.Usage >= last.Limit {
+		return 0, true
+	}
+
+	// fit usage = a + b*t with t in seconds relative to the first point
+	origin := points[0].Timestamp
+	n := float64(len(points))
+	var sumT, sumU, sumTT, sumTU float64
+	for _, point := range points {
+		t := point.Timestamp.Sub(origin).Seconds()
+		sumT += t
+		sumU += point.Usage
+		sumTT += t * t
+		sumTU += t * point.Usage
+	}
+
+	denominator := n*sumTT - sumT*sumT
+	if denominator == 0 {
+		return 0, false
+	}
+	slope := (n*sumTU - sumT*sumU) / denominator
+	if slope <= 0 {
+		return 0, false
+	}
+
+	return time.Duration((last.Limit - last.Usage) / slope * float64(time.Second)), true
+}
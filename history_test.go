@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreRecord(t *testing.T) {
+
+	first := time.Now().Add(-2 * time.Minute)
+	second := time.Now().Add(-time.Minute)
+	update := func(project string, timestamp time.Time, usage float64) quotaUpdate {
+		return quotaUpdate{Project: project, Region: "europe-west1", Metric: "cpus", Usage: usage, Limit: 24, Timestamp: timestamp}
+	}
+
+	h := newHistoryStore(time.Hour)
+	h.record([]quotaUpdate{update("history-project", first, 4), update("failing-project", first, 8)})
+	// the failing project's quota is carried over with the timestamp of the first cycle
+	h.record([]quotaUpdate{update("history-project", second, 6), update("failing-project", first, 8)})
+
+	tests := []struct {
+		project string
+		points  int
+	}{
+		{project: "history-project", points: 2},
+		{project: "failing-project", points: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.project, func(t *testing.T) {
+			points := h.project(tt.project)[historyKey{Project: tt.project, Region: "europe-west1", Metric: "cpus"}]
+			if len(points) != tt.points {
+				t.Errorf("Recorded %v points, expected %v", len(points), tt.points)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// httpSDTargetGroup is a target group in the prometheus http service discovery format
+type httpSDTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// handleHTTPSD lists the google cloud projects quota was retrieved for in the last fetch cycle as prometheus http_sd targets, with the
+// project id as target so other per-project exporters and probers can reuse this exporter's discovery through relabeling
+func handleHTTPSD(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, httpSDTargetGroups(quotaUpdates.snapshot()))
+}
+
+func httpSDTargetGroups(updates []quotaUpdate) []httpSDTargetGroup {
+
+	regionsPerProject := map[string]map[string]bool{}
+	for _, update := range updates {
+		if update.Provider != "" {
+			continue
+		}
+		if _, ok := regionsPerProject[update.Project]; !ok {
+			regionsPerProject[update.Project] = map[string]bool{}
+		}
+		if update.Region != "" {
+			regionsPerProject[update.Project][update.Region] = true
+		}
+	}
+
+	projects := []string{}
+	for project := range regionsPerProject {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	// an empty list rather than null, which prometheus rejects
+	groups := []httpSDTargetGroup{}
+	for _, project := range projects {
+		regions := []string{}
+		for region := range regionsPerProject[project] {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+
+		// list values are surrounded by separators, like prometheus does for tags, so they can be matched with .*,region,.*
+		regionsLabel := ""
+		if len(regions) > 0 {
+			regionsLabel = "," + strings.Join(regions, ",") + ","
+		}
+
+		groups = append(groups, httpSDTargetGroup{
+			Targets: []string{project},
+			Labels: map[string]string{
+				"__meta_gcloud_project_id": project,
+				"__meta_gcloud_regions":    regionsLabel,
+			},
+		})
+	}
+
+	return groups
+}
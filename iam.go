@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// iamKeysPerServiceAccountLimit is the fixed maximum number of keys a service account can have
+const iamKeysPerServiceAccountLimit = 10
+
+var (
+	// create gauges for the number of service accounts and the per project service account quota
+	iamServiceAccounts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_iam_service_accounts",
+		Help: "The number of service accounts in a project.",
+	}, []string{"project"})
+
+	iamServiceAccountsLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_iam_service_accounts_limit",
+		Help: "The maximum number of service accounts in a project, as set with --iam-service-account-limit.",
+	}, []string{"project"})
+
+	// create gauges for the number of user managed keys per service account and the limit they count against
+	iamServiceAccountKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_iam_service_account_keys",
+		Help: "The number of user managed keys of a service account.",
+	}, []string{"project", "service_account"})
+
+	iamServiceAccountKeysLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_iam_service_account_keys_limit",
+		Help: "The maximum number of keys per service account.",
+	}, []string{"project"})
+)
+
+func init() {
+	prometheus.MustRegister(iamServiceAccounts)
+	prometheus.MustRegister(iamServiceAccountsLimit)
+	prometheus.MustRegister(iamServiceAccountKeys)
+	prometheus.MustRegister(iamServiceAccountKeysLimit)
+
+	registerQuotaSource("iam", func(clients clientProvider, regions []string) quotaSource {
+		return &iamQuotaSource{clients: clients, serviceAccountLimit: *iamServiceAccountLimit}
+	})
+}
+
+// iamQuotaSource exports the number of service accounts against the per project quota and the keys of each service account against
+// the per service account limit, neither of which the iam api reports
+type iamQuotaSource struct {
+	clients             clientProvider
+	serviceAccountLimit int
+}
+
+func (s *iamQuotaSource) Name() string {
+	return "iam"
+}
+
+// Discover doesn't return locations, service accounts are global
+func (s *iamQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *iamQuotaSource) Permissions() []string {
+	return []string{"iam.serviceAccounts.list", "iam.serviceAccountKeys.list"}
+}
+
+func (s *iamQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't undercount the service accounts
+	serviceAccounts := []string{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("pageSize", "100")
+		query.Set("fields", "accounts/email,nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Accounts []struct {
+				Email string `json:"email"`
+			} `json:"accounts"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://iam.googleapis.com/v1/projects/%v/serviceAccounts?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range response.Accounts {
+			serviceAccounts = append(serviceAccounts, account.Email)
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing service accounts failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	setGauge(ctx, iamServiceAccounts, float64(len(serviceAccounts)), project)
+	setGauge(ctx, iamServiceAccountsLimit, float64(s.serviceAccountLimit), project)
+	setGauge(ctx, iamServiceAccountKeysLimit, iamKeysPerServiceAccountLimit, project)
+
+	// system managed keys are rotated by google and don't count against the limit; the keys list isn't paged
+	for _, email := range serviceAccounts {
+		query := url.Values{}
+		query.Set("keyTypes", "USER_MANAGED")
+		query.Set("fields", "keys/name")
+
+		var response struct {
+			Keys []struct {
+				Name string `json:"name"`
+			} `json:"keys"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://iam.googleapis.com/v1/projects/%v/serviceAccounts/%v/keys?%v", project, email, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		setGauge(ctx, iamServiceAccountKeys, float64(len(response.Keys)), project, email)
+	}
+
+	// iam limits aren't compute quota, so they're only exported as metrics
+	return nil, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	iapJWTHeader  = "X-Goog-Iap-Jwt-Assertion"
+	iapIssuer     = "https://cloud.google.com/iap"
+	iapJWKSURL    = "https://www.gstatic.com/iap/verify/public_key-jwk"
+	iapKeysMaxAge = time.Hour
+	iapClockSkew  = 30 * time.Second
+)
+
+// iapValidator verifies the signed header identity aware proxy adds to requests it lets through, see
+// https://cloud.google.com/iap/docs/signed-headers-howto
+type iapValidator struct {
+	audience string
+	client   *http.Client
+
+	keys          map[string]*ecdsa.PublicKey
+	keysFetchedAt time.Time
+	keysMutex     sync.Mutex
+}
+
+// newIAPValidator creates a validator for the expected audience, like /projects/PROJECT_NUMBER/global/backendServices/SERVICE_ID; with an empty audience no check is done
+func newIAPValidator(audience string) *iapValidator {
+	return &iapValidator{
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// middleware rejects requests without a valid iap jwt with a 401
+func (v *iapValidator) middleware(next http.Handler) http.Handler {
+
+	if v.audience == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.validate(r.Header.Get(iapJWTHeader)); err != nil {
+			log.Debug().Err(err).Msgf("Rejecting request from %v with invalid iap jwt", r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *iapValidator) validate(token string) error {
+
+	if token == "" {
+		return fmt.Errorf("Header %v is missing", iapJWTHeader)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("Jwt doesn't consist of 3 parts")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Algorithm != "ES256" {
+		return fmt.Errorf("Jwt algorithm %v is not ES256", header.Algorithm)
+	}
+
+	key, err := v.key(header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return fmt.Errorf("Jwt signature is malformed")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return fmt.Errorf("Jwt signature is invalid")
+	}
+
+	var claims struct {
+		Audience  string `json:"aud"`
+		Issuer    string `json:"iss"`
+		ExpiresAt int64  `json:"exp"`
+		IssuedAt  int64  `json:"iat"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Audience != v.audience:
+		return fmt.Errorf("Jwt audience %v doesn't match %v", claims.Audience, v.audience)
+	case claims.Issuer != iapIssuer:
+		return fmt.Errorf("Jwt issuer %v isn't %v", claims.Issuer, iapIssuer)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(iapClockSkew)):
+		return fmt.Errorf("Jwt expired")
+	case now.Before(time.Unix(claims.IssuedAt, 0).Add(-iapClockSkew)):
+		return fmt.Errorf("Jwt is issued in the future")
+	}
+
+	return nil
+}
+
+// key returns the public key with the id, refreshing the keys from google when they're stale or the id is unknown
+func (v *iapValidator) key(keyID string) (*ecdsa.PublicKey, error) {
+
+	v.keysMutex.Lock()
+	defer v.keysMutex.Unlock()
+
+	key, ok := v.keys[keyID]
+	if ok && time.Since(v.keysFetchedAt) < iapKeysMaxAge {
+		return key, nil
+	}
+
+	// don't let requests with made up key ids hammer the key endpoint
+	if !ok && time.Since(v.keysFetchedAt) < time.Minute {
+		return nil, fmt.Errorf("Jwt key %v is unknown", keyID)
+	}
+
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.keysFetchedAt = time.Now()
+
+	key, ok = v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("Jwt key %v is unknown", keyID)
+	}
+
+	return key, nil
+}
+
+func (v *iapValidator) fetchKeys() (map[string]*ecdsa.PublicKey, error) {
+
+	resp, err := v.client.Get(iapJWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("Retrieving iap public keys failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Retrieving iap public keys returned status %v", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyID string `json:"kid"`
+			Curve string `json:"crv"`
+			X     string `json:"x"`
+			Y     string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*ecdsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Curve != "P-256" {
+			continue
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("Jwt part is not base64url encoded: %v", err)
+	}
+
+	return json.Unmarshal(data, v)
+}
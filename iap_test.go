@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc serves requests of an http client from a function
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestIAPValidatorValidate(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve the public key as the only key of the jwks instead of retrieving google's keys
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test-key",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}},
+	})
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(jwks)), Header: http.Header{}, Request: req}, nil
+	})}
+
+	audience := "/projects/123/global/backendServices/456"
+	now := time.Now().Unix()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{"aud": audience, "iss": iapIssuer, "iat": now - 60, "exp": now + 540}
+	}
+
+	sign := func(signingKey *ecdsa.PrivateKey, header, claims map[string]interface{}) string {
+		headerJSON, _ := json.Marshal(header)
+		claimsJSON, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+		hash := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, signingKey, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature := make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+	header := map[string]interface{}{"alg": "ES256", "kid": "test-key"}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "AcceptsValidToken", token: sign(key, header, validClaims()), valid: true},
+		{name: "AcceptsTokenWithinClockSkew", token: sign(key, header, withClaim("iat", now+10)), valid: true},
+		{name: "RejectsMissingToken", token: ""},
+		{name: "RejectsMalformedToken", token: "not-a-jwt"},
+		{name: "RejectsExpiredToken", token: sign(key, header, withClaim("exp", now-120))},
+		{name: "RejectsTokenIssuedInTheFuture", token: sign(key, header, withClaim("iat", now+120))},
+		{name: "RejectsWrongAudience", token: sign(key, header, withClaim("aud", "/projects/123/global/backendServices/789"))},
+		{name: "RejectsWrongIssuer", token: sign(key, header, withClaim("iss", "https://accounts.google.com"))},
+		{name: "RejectsOtherAlgorithm", token: sign(key, map[string]interface{}{"alg": "RS256", "kid": "test-key"}, validClaims())},
+		{name: "RejectsBadSignature", token: sign(otherKey, header, validClaims())},
+		{name: "RejectsUnknownKeyID", token: sign(key, map[string]interface{}{"alg": "ES256", "kid": "other-key"}, validClaims())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			v := newIAPValidator(audience)
+			v.client = client
+
+			err := v.validate(tt.token)
+
+			if tt.valid && err != nil {
+				t.Errorf("Expected token to be accepted, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected token to be rejected")
+			}
+		})
+	}
+}
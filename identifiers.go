@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// project ids are 6 to 30 lowercase letters, digits or hyphens, starting with a letter and not ending in a hyphen; legacy projects
+	// can be scoped to a domain, like example.com:project
+	projectIDRegex = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+	// regions are like us-central1 or northamerica-northeast1
+	regionRegex = regexp.MustCompile(`^[a-z]+(-[a-z]+)*[0-9]+$`)
+)
+
+// normalizeList splits a comma-separated list, trimming whitespace and dropping empty and duplicate entries, so an accidental
+// trailing comma or repeated value doesn't end up as an api call
+func normalizeList(list string) []string {
+
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || stringInSlice(items, item) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// parseProjects normalizes a comma-separated list of project ids and rejects invalid ids
+func parseProjects(list string) ([]string, error) {
+
+	projects := normalizeList(list)
+	for _, project := range projects {
+		if !projectIDRegex.MatchString(project) {
+			return nil, fmt.Errorf("Project %q is not a valid project id, use 6 to 30 lowercase letters, digits or hyphens, starting with a letter", project)
+		}
+	}
+
+	return projects, nil
+}
+
+// parseRegions normalizes a comma-separated list of regions and rejects invalid names
+func parseRegions(list string) ([]string, error) {
+
+	regions := normalizeList(list)
+	for _, region := range regions {
+		if !regionRegex.MatchString(region) {
+			return nil, fmt.Errorf("Region %q is not a valid region name, like europe-west1", region)
+		}
+	}
+
+	return regions, nil
+}
+
+// dropInvalidProjects leaves out invalid project ids with an error instead of failing, for starting lazily
+func dropInvalidProjects(list string) []string {
+	return dropInvalid(normalizeList(list), projectIDRegex, "project id")
+}
+
+// dropInvalidRegions leaves out invalid region names with an error instead of failing, for starting lazily
+func dropInvalidRegions(list string) []string {
+	return dropInvalid(normalizeList(list), regionRegex, "region name")
+}
+
+func dropInvalid(items []string, regex *regexp.Regexp, kind string) []string {
+
+	valid := []string{}
+	for _, item := range items {
+		if !regex.MatchString(item) {
+			log.Error().Msgf("%q is not a valid %v, leaving it out", item, kind)
+			continue
+		}
+		valid = append(valid, item)
+	}
+
+	return valid
+}
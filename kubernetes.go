@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesListLimit is the page size for list calls against the kubernetes api
+const kubernetesListLimit = 500
+
+var (
+	// create gauges for the hard limit and usage of kubernetes resource quota, with the google cloud project the namespace maps to
+	kubernetesResourceQuotaHard = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_kubernetes_resourcequota_hard",
+		Help: "The hard limit of a kubernetes resource quota, with the google cloud project its namespace maps to.",
+	}, []string{"cluster", "namespace", "resourcequota", "resource", "project"})
+
+	kubernetesResourceQuotaUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_kubernetes_resourcequota_used",
+		Help: "The usage of a kubernetes resource quota, with the google cloud project its namespace maps to.",
+	}, []string{"cluster", "namespace", "resourcequota", "resource", "project"})
+)
+
+func init() {
+	prometheus.MustRegister(kubernetesResourceQuotaHard)
+	prometheus.MustRegister(kubernetesResourceQuotaUsed)
+
+	registerQuotaSource("kubernetes", func(clients clientProvider, regions []string) quotaSource {
+		return &kubernetesQuotaSource{
+			cluster:           *kubernetesClusterName,
+			namespaceProjects: *kubernetesNamespaceProjects,
+			defaultProject:    *kubernetesDefaultProject,
+		}
+	})
+}
+
+// kubernetesQuotaSource retrieves the resource quota of the cluster the exporter runs in, so dashboards can show cluster level next
+// to cloud level quota pressure; resource quota isn't cloud quota, so it's only exported as metrics and not as quota updates
+type kubernetesQuotaSource struct {
+	cluster           string
+	namespaceProjects map[string]string
+	defaultProject    string
+	api               kubernetesAPIClient
+}
+
+func (s *kubernetesQuotaSource) Name() string {
+	return "kubernetes"
+}
+
+// Projects returns the cluster as only project
+func (s *kubernetesQuotaSource) Projects(ctx context.Context) ([]string, error) {
+	return []string{s.cluster}, nil
+}
+
+func (s *kubernetesQuotaSource) Discover(ctx context.Context, cluster string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *kubernetesQuotaSource) Collect(ctx context.Context, cluster string, locations []string) ([]quotaUpdate, error) {
+
+	type resourceQuota struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Status struct {
+			Hard map[string]string `json:"hard"`
+			Used map[string]string `json:"used"`
+		} `json:"status"`
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't leave a partial set
+	items := []resourceQuota{}
+	tokens := pageTokens{}
+	continueToken := ""
+	for {
+		path := fmt.Sprintf("/api/v1/resourcequotas?limit=%v", kubernetesListLimit)
+		if continueToken != "" {
+			path += "&continue=" + url.QueryEscape(continueToken)
+		}
+
+		var list struct {
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+			Items []resourceQuota `json:"items"`
+		}
+		err := s.api.do(ctx, http.MethodGet, path, nil, &list)
+		if err != nil {
+			return nil, fmt.Errorf("Listing kubernetes resource quota failed at page %v: %v", tokens.pages+1, err)
+		}
+		items = append(items, list.Items...)
+
+		more, err := tokens.next(list.Metadata.Continue)
+		if err != nil {
+			return nil, fmt.Errorf("Listing kubernetes resource quota failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		continueToken = list.Metadata.Continue
+	}
+
+	for _, item := range items {
+		project := s.defaultProject
+		if p, ok := s.namespaceProjects[item.Metadata.Namespace]; ok {
+			project = p
+		}
+
+		for resource, hard := range item.Status.Hard {
+			value, err := parseKubernetesQuantity(hard)
+			if err != nil {
+				return nil, fmt.Errorf("Parsing hard limit of %v in resource quota %v/%v failed: %v", resource, item.Metadata.Namespace, item.Metadata.Name, err)
+			}
+			value, ok := sanitizeQuotaValue("kubernetes", project, resource, "limit", value)
+			if !ok {
+				continue
+			}
+			setGauge(ctx, kubernetesResourceQuotaHard, value, cluster, item.Metadata.Namespace, item.Metadata.Name, resource, project)
+		}
+		for resource, used := range item.Status.Used {
+			value, err := parseKubernetesQuantity(used)
+			if err != nil {
+				return nil, fmt.Errorf("Parsing usage of %v in resource quota %v/%v failed: %v", resource, item.Metadata.Namespace, item.Metadata.Name, err)
+			}
+			value, ok := sanitizeQuotaValue("kubernetes", project, resource, "usage", value)
+			if !ok {
+				continue
+			}
+			setGauge(ctx, kubernetesResourceQuotaUsed, value, cluster, item.Metadata.Namespace, item.Metadata.Name, resource, project)
+		}
+	}
+
+	return nil, nil
+}
+
+// kubernetesAPIClient calls the kubernetes api of the cluster the exporter runs in with its service account
+type kubernetesAPIClient struct {
+	client *http.Client
+	mutex  sync.Mutex
+}
+
+// kubernetesAPIError is a response from the kubernetes api with an unexpected status code, like 404 for a missing object or 409 for a
+// conflicting update
+type kubernetesAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *kubernetesAPIError) Error() string {
+	return fmt.Sprintf("Status %v: %v", e.StatusCode, e.Body)
+}
+
+// isKubernetesAPIStatus returns whether the error is a kubernetes api response with the status code
+func isKubernetesAPIStatus(err error, statusCode int) bool {
+	apiErr, ok := err.(*kubernetesAPIError)
+	return ok && apiErr.StatusCode == statusCode
+}
+
+// do calls the kubernetes api, sending in as json body if not nil and decoding the response into out
+func (c *kubernetesAPIClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("Not running inside a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT aren't set")
+	}
+
+	c.mutex.Lock()
+	if c.client == nil {
+		caCert, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/ca.crt")
+		if err != nil {
+			c.mutex.Unlock()
+			return err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		c.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	client := c.client
+	c.mutex.Unlock()
+
+	// the token is read on every call, since it's rotated by the kubelet
+	token, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/token")
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "https://"+net.JoinHostPort(host, port)+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &kubernetesAPIError{StatusCode: resp.StatusCode, Body: string(responseBody)}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(responseBody, out)
+}
+
+// kubernetesQuantitySuffixes are the binary and decimal suffixes of kubernetes quantities, like 2Gi or 500m
+var kubernetesQuantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseKubernetesQuantity converts a quantity like 2Gi, 500m or 1e3 to its value
+func parseKubernetesQuantity(quantity string) (float64, error) {
+
+	quantity = strings.TrimSpace(quantity)
+	for _, s := range kubernetesQuantitySuffixes {
+		if strings.HasSuffix(quantity, s.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(quantity, s.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return value * s.multiplier, nil
+		}
+	}
+
+	value, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("Quantity %v is out of range", quantity)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pinzolo/casee"
+)
+
+var (
+	// metricNames caches the snake cased name of every quota metric, since converting them is one of the biggest sources of
+	// allocations per cycle when monitoring thousands of projects, while there are only a few hundred distinct metrics
+	metricNames      = map[string]string{}
+	metricNamesMutex sync.RWMutex
+)
+
+// quotaMetricName returns the metric label for a quota metric like IN_USE_ADDRESSES, converting it only the first time
+func quotaMetricName(metric string) string {
+
+	metricNamesMutex.RLock()
+	name, ok := metricNames[metric]
+	metricNamesMutex.RUnlock()
+	if ok {
+		return name
+	}
+
+	name = casee.ToSnakeCase(metric)
+
+	metricNamesMutex.Lock()
+	metricNames[metric] = name
+	metricNamesMutex.Unlock()
+
+	return name
+}
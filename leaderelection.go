@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// kubernetesMicroTimeFormat is the format of the acquire and renew times of a lease
+const kubernetesMicroTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+var (
+	// create gauge for whether this replica holds the lease, to see which replica is active
+	leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_leader",
+		Help: "Whether this replica is the leader that fetches and serves quota (1) or a standby (0), with --leader-elect.",
+	})
+
+	// leaderElectionEnabled and leading are set by the leader elector, so /ready only succeeds for the leader
+	leaderElectionEnabled int32
+	leading               int32
+)
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+}
+
+// kubernetesLease is the part of a coordination.k8s.io/v1 lease used for leader election
+type kubernetesLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// leaderElector elects a single active replica with a kubernetes lease, so running a standby doesn't double the api calls and
+// series; the lease is considered expired when its holder hasn't renewed it for the lease duration as observed by this replica,
+// so the clocks of the replicas don't have to be in sync
+type leaderElector struct {
+	api           kubernetesAPIClient
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	// the holder and renew time last seen in the lease and when they were seen
+	observedHolder    string
+	observedRenewTime string
+	observedTime      time.Time
+}
+
+// newLeaderElector creates an elector for the lease in the namespace, defaulting to the namespace of the pod, with the hostname,
+// which is the pod name, as identity
+func newLeaderElector(namespace, name string, leaseDuration, renewDeadline, retryPeriod time.Duration) (*leaderElector, error) {
+
+	if namespace == "" {
+		data, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("Reading the namespace of the pod failed, set --leader-elect-namespace instead: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt32(&leaderElectionEnabled, 1)
+
+	return &leaderElector{
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+	}, nil
+}
+
+// acquire blocks until this replica holds the lease or the context is done
+func (e *leaderElector) acquire(ctx context.Context) error {
+
+	log.Info().Str("lease", e.namespace+"/"+e.name).Str("identity", e.identity).Msg("Waiting to become leader")
+
+	for {
+		acquired, err := e.tryAcquireOrRenew(ctx)
+		if err != nil {
+			log.Warn().Err(err).Str("lease", e.namespace+"/"+e.name).Msg("Acquiring lease failed")
+		}
+		if acquired {
+			atomic.StoreInt32(&leading, 1)
+			leaderGauge.Set(1)
+			log.Info().Str("lease", e.namespace+"/"+e.name).Str("identity", e.identity).Msg("Became leader, fetching quota")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.retryPeriod):
+		}
+	}
+}
+
+// renew keeps renewing the lease until the context is done, and calls lost if it can't be renewed within the renew deadline
+func (e *leaderElector) renew(ctx context.Context, lost func()) {
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retryPeriod):
+		}
+
+		renewCtx, cancel := context.WithTimeout(ctx, e.renewDeadline)
+		renewed, err := e.tryAcquireOrRenew(renewCtx)
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Str("lease", e.namespace+"/"+e.name).Msg("Renewing lease failed")
+		}
+		if renewed {
+			lastRenew = time.Now()
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(lastRenew) > e.renewDeadline {
+			atomic.StoreInt32(&leading, 0)
+			leaderGauge.Set(0)
+			lost()
+			return
+		}
+	}
+}
+
+// release hands the lease back if this replica holds it, so a standby can take over right away instead of waiting for it to expire
+func (e *leaderElector) release(ctx context.Context) {
+
+	if atomic.LoadInt32(&leading) == 0 {
+		return
+	}
+
+	lease, err := e.getLease(ctx)
+	if err != nil || lease.Spec.HolderIdentity != e.identity {
+		return
+	}
+
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	lease.Spec.RenewTime = time.Now().Format(kubernetesMicroTimeFormat)
+	err = e.api.do(ctx, http.MethodPut, e.leasePath(), lease, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("lease", e.namespace+"/"+e.name).Msg("Releasing lease failed")
+		return
+	}
+
+	atomic.StoreInt32(&leading, 0)
+	leaderGauge.Set(0)
+	log.Info().Str("lease", e.namespace+"/"+e.name).Msg("Released lease")
+}
+
+// tryAcquireOrRenew creates the lease or takes it over if it's held by this replica, unheld or expired; an update conflicting with
+// another replica returns false without error
+func (e *leaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+
+	now := time.Now()
+
+	lease, err := e.getLease(ctx)
+	if isKubernetesAPIStatus(err, http.StatusNotFound) {
+		lease = &kubernetesLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata.Name = e.name
+		lease.Metadata.Namespace = e.namespace
+		lease.Spec.HolderIdentity = e.identity
+		lease.Spec.LeaseDurationSeconds = int(e.leaseDuration / time.Second)
+		lease.Spec.AcquireTime = now.Format(kubernetesMicroTimeFormat)
+		lease.Spec.RenewTime = now.Format(kubernetesMicroTimeFormat)
+
+		err = e.api.do(ctx, http.MethodPost, fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%v/leases", e.namespace), lease, nil)
+		if isKubernetesAPIStatus(err, http.StatusConflict) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		e.observe(lease, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if lease.Spec.HolderIdentity != e.observedHolder || lease.Spec.RenewTime != e.observedRenewTime {
+		e.observe(lease, now)
+	}
+
+	leaseDuration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	if lease.Spec.HolderIdentity != "" && lease.Spec.HolderIdentity != e.identity && e.observedTime.Add(leaseDuration).After(now) {
+		return false, nil
+	}
+
+	if lease.Spec.HolderIdentity != e.identity {
+		lease.Spec.AcquireTime = now.Format(kubernetesMicroTimeFormat)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.HolderIdentity = e.identity
+	lease.Spec.LeaseDurationSeconds = int(e.leaseDuration / time.Second)
+	lease.Spec.RenewTime = now.Format(kubernetesMicroTimeFormat)
+
+	// the resource version of the retrieved lease makes the update fail if another replica updated it in the meantime
+	err = e.api.do(ctx, http.MethodPut, e.leasePath(), lease, nil)
+	if isKubernetesAPIStatus(err, http.StatusConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.observe(lease, now)
+
+	return true, nil
+}
+
+func (e *leaderElector) observe(lease *kubernetesLease, now time.Time) {
+	e.observedHolder = lease.Spec.HolderIdentity
+	e.observedRenewTime = lease.Spec.RenewTime
+	e.observedTime = now
+}
+
+func (e *leaderElector) getLease(ctx context.Context) (*kubernetesLease, error) {
+	var lease kubernetesLease
+	err := e.api.do(ctx, http.MethodGet, e.leasePath(), nil, &lease)
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (e *leaderElector) leasePath() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%v/leases/%v", e.namespace, e.name)
+}
+
+// checkLeadership returns an error for a standby replica, so kubernetes only routes scrapes to the leader
+func checkLeadership() error {
+	if atomic.LoadInt32(&leaderElectionEnabled) == 1 && atomic.LoadInt32(&leading) == 0 {
+		return fmt.Errorf("Standing by, another replica is the leader")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+var (
+	// create info metric with the reason a project is excluded from fetching
+	projectExcludedInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_excluded_info",
+		Help: "Set to 1 with the lifecycle state, like delete_requested, of a project that's skipped because it isn't active; absent for active projects.",
+	}, []string{"project", "reason"})
+
+	// errProjectInactive marks projects skipped because they're pending deletion or otherwise not active
+	errProjectInactive = errors.New("Project isn't active")
+
+	projectLifecycles      = map[string]projectLifecycle{}
+	projectLifecyclesMutex sync.Mutex
+)
+
+func init() {
+	prometheus.MustRegister(projectExcludedInfo)
+}
+
+// projectLifecycle is the lifecycle state of a project as last checked
+type projectLifecycle struct {
+	state   string
+	checked time.Time
+}
+
+// inactiveProjectState returns the lifecycle state of the project if it isn't active, like DELETE_REQUESTED, or an empty string if
+// it is or the state can't be retrieved; the state is retrieved from the resource manager once per --project-lifecycle-recheck
+func inactiveProjectState(ctx context.Context, clients clientProvider, project string) string {
+
+	projectLifecyclesMutex.Lock()
+	lifecycle, ok := projectLifecycles[project]
+	projectLifecyclesMutex.Unlock()
+	if ok && time.Since(lifecycle.checked) < *projectLifecycleRecheck {
+		return inactiveState(lifecycle.state)
+	}
+
+	state, err := getProjectLifecycleState(ctx, clients, project)
+	if err != nil {
+		// a project whose state is unknown is fetched as usual, its own calls tell whether it's reachable
+		loggerFromContext(ctx).Debug().Err(err).Str("project", project).Msgf("Retrieving lifecycle state of project %v failed", project)
+		state = lifecycle.state
+	}
+
+	projectLifecyclesMutex.Lock()
+	projectLifecycles[project] = projectLifecycle{state: state, checked: time.Now()}
+	projectLifecyclesMutex.Unlock()
+
+	previous, current := inactiveState(lifecycle.state), inactiveState(state)
+	if current != previous {
+		if previous != "" {
+			projectExcludedInfo.DeleteLabelValues(project, strings.ToLower(previous))
+		}
+		if current != "" {
+			projectExcludedInfo.WithLabelValues(project, strings.ToLower(current)).Set(1)
+			log.Warn().Str("project", project).Msgf("Project %v is in lifecycle state %v, skipping it", project, current)
+		} else {
+			log.Info().Str("project", project).Msgf("Project %v is active again, no longer skipping it", project)
+		}
+	}
+
+	return current
+}
+
+// inactiveState returns the state unless it's active or unknown
+func inactiveState(state string) string {
+	if state == "" || state == "ACTIVE" || state == "LIFECYCLE_STATE_UNSPECIFIED" {
+		return ""
+	}
+	return state
+}
+
+func getProjectLifecycleState(ctx context.Context, clients clientProvider, project string) (string, error) {
+
+	httpClient, err := clients.httpClient(project)
+	if err != nil {
+		return "", err
+	}
+
+	service, err := cloudresourcemanager.New(httpClient)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := service.Projects.Get(project).Fields("lifecycleState").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+
+	return p.LifecycleState, nil
+}
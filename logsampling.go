@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// logSampler is a zerolog hook that writes at most limit identical warning or error lines per window; identical means same level and
+// message, and since messages name the project this limits per project. The next line written after suppression carries the number
+// of suppressed lines.
+type logSampler struct {
+	limit     int
+	window    time.Duration
+	entries   map[string]*logSamplerEntry
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type logSamplerEntry struct {
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+func newLogSampler(limit int, window time.Duration) *logSampler {
+	return &logSampler{
+		limit:     limit,
+		window:    window,
+		entries:   map[string]*logSamplerEntry{},
+		lastPrune: time.Now(),
+	}
+}
+
+func (s *logSampler) Run(e *zerolog.Event, level zerolog.Level, message string) {
+
+	// fatal lines exit the process and lower levels aren't sampled
+	if level < zerolog.WarnLevel || level >= zerolog.FatalLevel {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	key := level.String() + " " + message
+	entry, ok := s.entries[key]
+	if !ok || now.Sub(entry.windowStart) >= s.window {
+		suppressed := 0
+		if ok {
+			suppressed = entry.suppressed
+		}
+		entry = &logSamplerEntry{windowStart: now}
+		s.entries[key] = entry
+		if suppressed > 0 {
+			e.Int("suppressed", suppressed)
+		}
+	}
+
+	entry.count++
+	if entry.count > s.limit {
+		entry.suppressed++
+		e.Discard()
+	}
+}
+
+// prune forgets messages that haven't been seen for a full window, so the map doesn't grow with every distinct message
+func (s *logSampler) prune(now time.Time) {
+
+	if now.Sub(s.lastPrune) < s.window {
+		return
+	}
+	s.lastPrune = now
+
+	for key, entry := range s.entries {
+		if now.Sub(entry.windowStart) >= 2*s.window {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -2,22 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/alecthomas/kingpin"
 	foundation "github.com/estafette/estafette-foundation"
 	"github.com/fsnotify/fsnotify"
-	"github.com/pinzolo/casee"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/oauth2/google"
 	compute "google.golang.org/api/compute/v1"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const annotationCloudflareHostnames string = "estafette.io/cloudflare-hostnames"
@@ -48,11 +50,140 @@ var (
 )
 
 var (
+	// commands
+	serveCommand          = kingpin.Command("serve", "Fetch quota continuously and export it (default).").Default()
+	recordingRulesCommand = kingpin.Command("recording-rules", "Fetch quota once and print recommended Prometheus recording rules for the discovered metrics.")
+	recordingRulesFile    = recordingRulesCommand.Flag("file", "The file to write the recording rules to; defaults to stdout.").String()
+	topCommand            = kingpin.Command("top", "Show live quota utilization in the terminal, sorted by headroom and updated every fetch cycle.")
+	topRows               = topCommand.Flag("rows", "The number of quota rows to show.").Default("40").Int()
+	benchmarkCommand      = kingpin.Command("benchmark", "Fetch synthetic quota for many projects and regions without calling google cloud apis, and report cycle duration, memory, scrape latency and exposition size.")
+	benchmarkProjects     = benchmarkCommand.Flag("projects", "The number of projects to simulate.").Default("3000").Int()
+	benchmarkRegions      = benchmarkCommand.Flag("regions", "The number of regions to simulate per project.").Default("10").Int()
+	benchmarkCycles       = benchmarkCommand.Flag("cycles", "The number of fetch cycles to run.").Default("3").Int()
+
 	// flags
-	prometheusMetricsAddress = kingpin.Flag("metrics-listen-address", "The address to listen on for Prometheus metrics requests.").Envar("PROMETHEUS_METRICS_PORT").Default(":9101").String()
-	prometheusMetricsPath    = kingpin.Flag("metrics-path", "The path to listen for Prometheus metrics requests.").Envar("PROMETHEUS_METRICS_PATH").Default("/metrics").String()
-	googleComputeProjects    = kingpin.Flag("google-compute-projects", "The Google Cloud project ids to get quota for (optionally as comma-separated list).").Envar("GCLOUD_PROJECTS").String()
-	googleComputeRegions     = kingpin.Flag("google-compute-regions", "The Google Cloud regions to get quota for (optionally as comma-separated list).").Envar("GCLOUD_REGIONS").String()
+	prometheusMetricsAddress    = kingpin.Flag("metrics-listen-address", "The address to listen on for Prometheus metrics requests.").Envar("PROMETHEUS_METRICS_PORT").Default(":9101").String()
+	prometheusMetricsNetwork    = kingpin.Flag("metrics-listen-network", "The address family to listen on: tcp for dual-stack, tcp4 for IPv4 only or tcp6 for IPv6 only; use brackets for IPv6 addresses, e.g. [::]:9101.").Envar("PROMETHEUS_METRICS_NETWORK").Default("tcp").Enum("tcp", "tcp4", "tcp6")
+	prometheusMetricsPath       = kingpin.Flag("metrics-path", "The path to listen for Prometheus metrics requests.").Envar("PROMETHEUS_METRICS_PATH").Default("/metrics").String()
+	googleComputeProjects       = kingpin.Flag("google-compute-projects", "The Google Cloud project ids to get quota for (optionally as comma-separated list).").Envar("GCLOUD_PROJECTS").String()
+	googleComputeRegions        = kingpin.Flag("google-compute-regions", "The Google Cloud regions to get quota for (optionally as comma-separated list).").Envar("GCLOUD_REGIONS").String()
+	collectors                  = kingpin.Flag("collectors", "The quota sources to collect (as comma-separated list), e.g. compute, drift, zonal, storage, enabled-apis, service-usage, cloudquotas, accelerators, quota-preferences, bigquery, cloudsql, spanner, bigtable, iam, appengine, filestore, memorystore, gke, gke-autoscaler, aws, azure or kubernetes.").Envar("COLLECTORS").Default("compute").String()
+	awsRegions                  = kingpin.Flag("aws-regions", "The AWS regions to get quota for with the aws collector (optionally as comma-separated list).").Envar("AWS_QUOTA_REGIONS").Default("us-east-1").String()
+	awsServices                 = kingpin.Flag("aws-services", "The AWS service codes to get quota for with the aws collector (optionally as comma-separated list).").Envar("AWS_QUOTA_SERVICES").Default("ec2,ebs,vpc,elasticloadbalancing").String()
+	azureSubscriptions          = kingpin.Flag("azure-subscriptions", "The Azure subscription ids to get quota for with the azure collector (optionally as comma-separated list).").Envar("AZURE_SUBSCRIPTIONS").String()
+	azureLocations              = kingpin.Flag("azure-locations", "The Azure locations to get compute quota for with the azure collector (optionally as comma-separated list).").Envar("AZURE_LOCATIONS").String()
+	serviceUsageServices        = kingpin.Flag("service-usage-services", "The services to get quota for with the service-usage collector (optionally as comma-separated list), e.g. pubsub.googleapis.com; defaults to all services enabled in each project.").Envar("SERVICE_USAGE_SERVICES").String()
+	cloudQuotasServices         = kingpin.Flag("cloudquotas-services", "The services to get quota infos for with the cloudquotas collector (optionally as comma-separated list), e.g. compute.googleapis.com; defaults to all services enabled in each project.").Envar("CLOUDQUOTAS_SERVICES").String()
+	acceleratorServices         = kingpin.Flag("accelerator-services", "The services to get gpu and tpu quota for with the accelerators collector (as comma-separated list), e.g. aiplatform.googleapis.com.").Envar("ACCELERATOR_SERVICES").Default("aiplatform.googleapis.com,tpu.googleapis.com").String()
+	kubernetesClusterName       = kingpin.Flag("kubernetes-cluster-name", "The name of the cluster the exporter runs in, used as cluster label by the kubernetes collector.").Envar("KUBERNETES_CLUSTER_NAME").Default("in-cluster").String()
+	kubernetesNamespaceProjects = kingpin.Flag("kubernetes-namespace-project", "Map a namespace to the google cloud project it consumes quota in, as namespace=project (repeatable).").Envar("KUBERNETES_NAMESPACE_PROJECTS").StringMap()
+	kubernetesDefaultProject    = kingpin.Flag("kubernetes-default-project", "The google cloud project for namespaces without a mapping.").Envar("KUBERNETES_DEFAULT_PROJECT").String()
+	simulate                    = kingpin.Flag("simulate", "Serve synthetic quota from a fake Google Cloud backend instead of calling the apis, for developing dashboards and alerts without credentials.").Envar("SIMULATE").Bool()
+	simulateProjects            = kingpin.Flag("simulate-projects", "The number of projects to simulate.").Envar("SIMULATE_PROJECTS").Default("3").Int()
+	simulateRegions             = kingpin.Flag("simulate-regions", "The number of regions to simulate per project.").Envar("SIMULATE_REGIONS").Default("3").Int()
+	simulateDrift               = kingpin.Flag("simulate-drift", "The maximum change of simulated usage per fetch cycle, as fraction of the limit.").Envar("SIMULATE_DRIFT").Default("0.05").Float64()
+	recordDir                   = kingpin.Flag("record-dir", "A directory to record all Google Cloud api responses to, for replaying them later.").Envar("RECORD_DIR").String()
+	replayDir                   = kingpin.Flag("replay-dir", "A directory with recorded Google Cloud api responses to serve instead of calling the apis; no credentials are needed.").Envar("REPLAY_DIR").String()
+	metricsBearerToken          = kingpin.Flag("metrics-bearer-token", "The bearer token scrape requests have to present to retrieve the metrics.").Envar("METRICS_BEARER_TOKEN").String()
+	metricsBearerTokenFile      = kingpin.Flag("metrics-bearer-token-file", "The path to a file containing the bearer token scrape requests have to present; takes precedence over --metrics-bearer-token.").Envar("METRICS_BEARER_TOKEN_FILE").String()
+	metricsRateLimit            = kingpin.Flag("metrics-rate-limit", "The number of metrics requests per second allowed per client ip; 0 disables rate limiting.").Envar("METRICS_RATE_LIMIT").Default("0").Float64()
+	metricsRateBurst            = kingpin.Flag("metrics-rate-burst", "The number of metrics requests a client ip can burst above the rate limit.").Envar("METRICS_RATE_BURST").Default("5").Int()
+	metricsMaxConcurrentScrapes = kingpin.Flag("metrics-max-concurrent-scrapes", "The maximum number of metrics requests served concurrently; 0 means unlimited.").Envar("METRICS_MAX_CONCURRENT_SCRAPES").Default("0").Int()
+	iapAudience                 = kingpin.Flag("iap-audience", "The expected audience of the Identity-Aware Proxy signed header, like /projects/PROJECT_NUMBER/global/backendServices/SERVICE_ID; requests without a valid header are rejected if set.").Envar("IAP_AUDIENCE").String()
+	eventThresholds             = kingpin.Flag("event-threshold", "A utilization ratio, like 0.8, for which crossings are sent as threshold events on the /events stream (repeatable).").Envar("EVENT_THRESHOLDS").Default("0.8", "0.9").Float64List()
+	slackSigningSecret          = kingpin.Flag("slack-signing-secret", "The signing secret of the Slack app; if set, slash commands like /quota project-x cpus europe-west1 are answered at /slack/command on the metrics address.").Envar("SLACK_SIGNING_SECRET").String()
+	dashboardEnabled            = kingpin.Flag("dashboard", "Serve an html dashboard with the utilization history and time to exhaustion of each quota at /dashboard on the metrics address.").Envar("DASHBOARD").Bool()
+	historyRetention            = kingpin.Flag("history-retention", "How long the quota of past fetch cycles is kept in memory for the dashboard.").Envar("HISTORY_RETENTION").Default("24h").Duration()
+	auditLogEnabled             = kingpin.Flag("audit-log", "Log every outbound Google Cloud api call as a structured json line.").Envar("AUDIT_LOG").Bool()
+	auditLogFile                = kingpin.Flag("audit-log-file", "The file to write the audit log to; defaults to stdout.").Envar("AUDIT_LOG_FILE").String()
+	apiTimeout                  = kingpin.Flag("gcp-api-timeout", "The timeout for a single attempt of a Google Cloud api call.").Envar("GCP_API_TIMEOUT").Default("30s").Duration()
+	apiRetries                  = kingpin.Flag("gcp-api-retries", "The number of times a failed Google Cloud api call is retried.").Envar("GCP_API_RETRIES").Default("3").Int()
+	apiMaxBackoff               = kingpin.Flag("gcp-api-max-backoff", "The maximum delay between retries of a Google Cloud api call.").Envar("GCP_API_MAX_BACKOFF").Default("10s").Duration()
+	apiPolicyOverrides          = kingpin.Flag("gcp-api-policy", "Per service override of timeout, retries and backoff ceiling, as service=timeout:10s,retries:5,max-backoff:1m (repeatable).").Envar("GCP_API_POLICIES").Strings()
+	apiQPS                      = kingpin.Flag("gcp-api-qps", "The maximum number of Google Cloud api calls per second across all projects, so a large project list doesn't exhaust the api quota; 0 means unlimited.").Envar("GCP_API_QPS").Default("0").Float64()
+	apiBurst                    = kingpin.Flag("gcp-api-burst", "The number of Google Cloud api calls that can burst above --gcp-api-qps.").Envar("GCP_API_BURST").Default("10").Int()
+	userAgent                   = kingpin.Flag("user-agent", "The User-Agent to send on Google Cloud api calls; defaults to the exporter name, version and deployment name.").Envar("USER_AGENT").String()
+	deploymentName              = kingpin.Flag("deployment-name", "The name of this deployment, included in the User-Agent to attribute api calls to this instance.").Envar("DEPLOYMENT_NAME").String()
+	logVerbosity                = kingpin.Flag("log-verbosity", "Which structured logs to write for fetch cycles: cycle for a summary per cycle, project to add a line per project and collector, api to add a line per api call; all lines carry the cycle id.").Envar("LOG_VERBOSITY").Default("project").Enum("cycle", "project", "api")
+	logSampleLimit              = kingpin.Flag("log-sample-limit", "The maximum number of identical warning or error lines to log per --log-sample-window; 0 disables sampling.").Envar("LOG_SAMPLE_LIMIT").Default("10").Int()
+	logSampleWindow             = kingpin.Flag("log-sample-window", "The window in which identical warning or error lines are counted for --log-sample-limit.").Envar("LOG_SAMPLE_WINDOW").Default("1m").Duration()
+	sentryDSN                   = kingpin.Flag("sentry-dsn", "The Sentry dsn to report collection errors and panics to; disabled if empty.").Envar("SENTRY_DSN").String()
+	errorReportingProject       = kingpin.Flag("error-reporting-project", "The Google Cloud project to report collection errors and panics to with Cloud Error Reporting; disabled if empty.").Envar("ERROR_REPORTING_PROJECT").String()
+	vpcServiceControlsBackoff   = kingpin.Flag("vpc-sc-backoff", "How long to skip a project after its api calls got rejected by a VPC Service Controls perimeter.").Envar("VPC_SC_BACKOFF").Default("30m").Duration()
+	deadProjectAfter            = kingpin.Flag("dead-project-after", "The number of fetch cycles in a row a project has to return not found or permission denied to be considered deleted or unreachable.").Envar("DEAD_PROJECT_AFTER").Default("3").Int()
+	deadProjectRecheck          = kingpin.Flag("dead-project-recheck", "How often a project considered deleted or unreachable is attempted again.").Envar("DEAD_PROJECT_RECHECK").Default("1h").Duration()
+	apiDisabledRecheck          = kingpin.Flag("api-disabled-recheck", "How often a project without the compute api enabled is attempted again.").Envar("API_DISABLED_RECHECK").Default("1h").Duration()
+	skipInactiveProjects        = kingpin.Flag("skip-inactive-projects", "Check the lifecycle state of projects with the resource manager and skip those that aren't active, like projects pending deletion.").Envar("SKIP_INACTIVE_PROJECTS").Default("true").Bool()
+	projectLifecycleRecheck     = kingpin.Flag("project-lifecycle-recheck", "How often the lifecycle state of a project is retrieved again.").Envar("PROJECT_LIFECYCLE_RECHECK").Default("10m").Duration()
+	livenessMaxMissedCycles     = kingpin.Flag("liveness-max-missed-cycles", "The number of fetch intervals without a completed fetch cycle after which /liveness fails, so a wedged exporter gets restarted; 0 disables the check.").Envar("LIVENESS_MAX_MISSED_CYCLES").Default("5").Int()
+	autoFileQuotaIncreases      = kingpin.Flag("auto-file-quota-increases", "File a quota increase request with the cloud quotas api for compute quota whose usage stayed above --auto-file-threshold for --auto-file-cycles cycles in a row; only quota with an --auto-file-cap are increased.").Envar("AUTO_FILE_QUOTA_INCREASES").Bool()
+	autoFileCaps                = kingpin.Flag("auto-file-cap", "The maximum value to request for a compute quota, as metric=value like cpus=1000 (repeatable).").Envar("AUTO_FILE_CAPS").Strings()
+	autoFileThreshold           = kingpin.Flag("auto-file-threshold", "The fraction of the limit usage has to be above to file a quota increase request.").Envar("AUTO_FILE_THRESHOLD").Default("0.85").Float64()
+	autoFileCycles              = kingpin.Flag("auto-file-cycles", "The number of fetch cycles in a row usage has to be above --auto-file-threshold to file a quota increase request.").Envar("AUTO_FILE_CYCLES").Default("3").Int()
+	autoFileMultiplier          = kingpin.Flag("auto-file-multiplier", "The factor to multiply the current limit by for the requested value, which is never above the --auto-file-cap of the quota.").Envar("AUTO_FILE_MULTIPLIER").Default("1.5").Float64()
+	autoFileContactEmail        = kingpin.Flag("auto-file-contact-email", "The email google contacts about filed quota increase requests.").Envar("AUTO_FILE_CONTACT_EMAIL").String()
+	normalizeUnits              = kingpin.Flag("normalize-units", "Convert quota in GB, TB, Mbps or Gbps to bytes and bits per second, with the metric label ending in _bytes or _bits_per_second accordingly.").Envar("NORMALIZE_UNITS").Bool()
+	bigQueryLocations           = kingpin.Flag("bigquery-locations", "The bigquery locations to retrieve reservations and capacity commitments for (as comma-separated list), like US, EU or europe-west1.").Envar("BIGQUERY_LOCATIONS").Default("US,EU").String()
+	bigQueryQueryLimit          = kingpin.Flag("bigquery-concurrent-query-limit", "The maximum number of concurrently running bigquery queries per project the bigquery collector compares against.").Envar("BIGQUERY_CONCURRENT_QUERY_LIMIT").Default("100").Int()
+	bigQueryLoadJobLimit        = kingpin.Flag("bigquery-load-job-limit", "The maximum number of bigquery load jobs per project per day the bigquery collector compares against.").Envar("BIGQUERY_LOAD_JOB_LIMIT").Default("100000").Int()
+	cloudSQLInstanceLimit       = kingpin.Flag("cloudsql-instance-limit", "The maximum number of cloud sql instances per project the cloudsql collector compares against; raise it for projects that got their quota increased.").Envar("CLOUDSQL_INSTANCE_LIMIT").Default("100").Int()
+	spannerNodeLimit            = kingpin.Flag("spanner-node-limit", "The spanner node quota per instance configuration of the projects, which the spanner api doesn't report; the limit isn't exported if 0.").Envar("SPANNER_NODE_LIMIT").Default("0").Int()
+	bigtableNodeLimit           = kingpin.Flag("bigtable-node-limit", "The bigtable node quota per zone and storage type of the projects, which the bigtable admin api doesn't report; the limit isn't exported if 0.").Envar("BIGTABLE_NODE_LIMIT").Default("0").Int()
+	iamServiceAccountLimit      = kingpin.Flag("iam-service-account-limit", "The maximum number of service accounts per project the iam collector compares against; raise it for projects that got their quota increased.").Envar("IAM_SERVICE_ACCOUNT_LIMIT").Default("100").Int()
+	appEngineLimits             = kingpin.Flag("appengine-limit", "Override a documented app engine limit the appengine collector compares against, as metric=value like versions_per_app=15 for free apps (repeatable).").Envar("APPENGINE_LIMITS").Strings()
+	gkeLimits                   = kingpin.Flag("gke-limit", "Override a documented gke limit the gke collector compares against, as metric=value like nodes_per_cluster=5000 (repeatable).").Envar("GKE_LIMITS").Strings()
+	storageGroupLabel           = kingpin.Flag("storage-group-label", "The label of snapshots, images and disks the storage collector groups them by, like team.").Envar("STORAGE_GROUP_LABEL").Default("team").String()
+	startupMode                 = kingpin.Flag("startup-mode", "Whether to fail-fast when credentials or projects are invalid, e.g. in ci, or to start lazily with degraded status metrics until they become valid, e.g. in kubernetes where secrets may arrive late.").Envar("STARTUP_MODE").Default("fail-fast").Enum("fail-fast", "lazy")
+	fetchInterval               = kingpin.Flag("fetch-interval", "The time between fetch cycles; raise it to stay under the api read quota when monitoring many projects.").Envar("FETCH_INTERVAL").Default("60s").Duration()
+	fetchJitterPercent          = kingpin.Flag("fetch-jitter-percent", "The maximum deviation from --fetch-interval as percentage, to keep multiple exporters from fetching in lockstep.").Envar("FETCH_JITTER_PERCENT").Default("25").Int()
+	staggerFetches              = kingpin.Flag("stagger-fetches", "Spread the project fetches of a cycle evenly across the fetch interval instead of starting them all at once, to smooth the api load of many projects; the metrics are still updated at once at the end of each cycle.").Envar("STAGGER_FETCHES").Bool()
+	shard                       = kingpin.Flag("shard", "The shard of this replica, from 0 to --total-shards - 1; it only fetches the projects whose id hashes to it.").Envar("SHARD").Default("0").Int()
+	totalShards                 = kingpin.Flag("total-shards", "The number of replicas splitting the projects between them, so a large organization can be fetched by several smaller exporters; 1 disables sharding.").Envar("TOTAL_SHARDS").Default("1").Int()
+	leaderElect                 = kingpin.Flag("leader-elect", "Elect a leader with a kubernetes lease, so of multiple replicas only the leader fetches and serves quota and a standby takes over when it fails.").Envar("LEADER_ELECT").Bool()
+	leaderElectNamespace        = kingpin.Flag("leader-elect-namespace", "The namespace of the lease; defaults to the namespace of the pod.").Envar("LEADER_ELECT_NAMESPACE").String()
+	leaderElectLeaseName        = kingpin.Flag("leader-elect-lease-name", "The name of the lease.").Envar("LEADER_ELECT_LEASE_NAME").Default("estafette-gcloud-quota-exporter").String()
+	leaderElectLeaseDuration    = kingpin.Flag("leader-elect-lease-duration", "How long a standby waits after the last renewal of the leader before taking over.").Envar("LEADER_ELECT_LEASE_DURATION").Default("15s").Duration()
+	leaderElectRenewDeadline    = kingpin.Flag("leader-elect-renew-deadline", "How long the leader keeps trying to renew the lease before it gives up leadership and exits.").Envar("LEADER_ELECT_RENEW_DEADLINE").Default("10s").Duration()
+	leaderElectRetryPeriod      = kingpin.Flag("leader-elect-retry-period", "The time between attempts to acquire or renew the lease.").Envar("LEADER_ELECT_RETRY_PERIOD").Default("2s").Duration()
+	maxConcurrency              = kingpin.Flag("max-concurrency", "The maximum number of projects to fetch quota for in parallel.").Envar("MAX_CONCURRENCY").Default("10").Int()
+	cycleDeadline               = kingpin.Flag("cycle-deadline", "The maximum duration of a fetch cycle, after which calls still running are cancelled and remaining projects are skipped until the next cycle; 0 disables the deadline.").Envar("CYCLE_DEADLINE").Default("0").Duration()
+	metricTTL                   = kingpin.Flag("metric-ttl", "The number of fetch cycles after which quota series that weren't refreshed, e.g. because a project or region keeps failing, are removed; 0 keeps them until they're refreshed.").Envar("METRIC_TTL").Default("0").Int()
+	scrapeTimeFetch             = kingpin.Flag("scrape-time-fetch", "Fetch quota when metrics are scraped instead of in a background loop, so prometheus controls freshness.").Envar("SCRAPE_TIME_FETCH").Bool()
+	scrapeFetchMinInterval      = kingpin.Flag("scrape-fetch-min-interval", "The minimum time between fetches with --scrape-time-fetch; scrapes within it are served the last fetch.").Envar("SCRAPE_FETCH_MIN_INTERVAL").Default("30s").Duration()
+	credentialSources           = kingpin.Flag("credentials", "A credentials file bound to the projects it's used for, as /path/to/key.json=project-a,project-b (repeatable); the bound projects are added to the projects to get quota for, all other projects use the application default credentials.").Envar("GCLOUD_CREDENTIALS").Strings()
+	downscopeTokens             = kingpin.Flag("downscope-tokens", "Request read-only scoped access tokens instead of full cloud-platform access.").Envar("DOWNSCOPE_TOKENS").Bool()
+	impersonateServiceAccount   = kingpin.Flag("impersonate-service-account", "The email of a service account to impersonate with short-lived tokens, e.g. one with only quota read permissions.").Envar("IMPERSONATE_SERVICE_ACCOUNT").String()
+	impersonationLifetime       = kingpin.Flag("impersonation-lifetime", "The lifetime of tokens of the impersonated service account.").Envar("IMPERSONATION_LIFETIME").Default("15m").Duration()
+	adminListenAddress          = kingpin.Flag("admin-listen-address", "The address to serve the admin endpoints /status, /config, /reload, /api/v1/refresh, /grafana/dashboard and /debug/pprof on; disabled if empty.").Envar("ADMIN_LISTEN_ADDRESS").String()
+	grpcListenAddress           = kingpin.Flag("grpc-listen-address", "The address to serve the grpc QuotaService with its WatchQuotas stream on; disabled if empty.").Envar("GRPC_LISTEN_ADDRESS").String()
+	otlpEndpoint                = kingpin.Flag("otlp-endpoint", "The OTLP/HTTP metrics endpoint to push metrics to in json encoding, like http://otel-collector:4318/v1/metrics; disabled if empty.").Envar("OTLP_ENDPOINT").String()
+	otlpHeaders                 = kingpin.Flag("otlp-header", "A header to send to the OTLP endpoint, as key=value (repeatable).").Envar("OTLP_HEADERS").Strings()
+	otlpPushInterval            = kingpin.Flag("otlp-push-interval", "The interval at which metrics are pushed to the OTLP endpoint.").Envar("OTLP_PUSH_INTERVAL").Default("60s").Duration()
+	otlpTracesEndpoint          = kingpin.Flag("otlp-traces-endpoint", "The OTLP/HTTP traces endpoint to export spans of fetch cycles and api calls to in json encoding, like http://otel-collector:4318/v1/traces; disabled if empty. Uses the --otlp-header headers.").Envar("OTLP_TRACES_ENDPOINT").String()
+	remoteWriteURL              = kingpin.Flag("remote-write-url", "The Prometheus remote write endpoint to push metrics to; disabled if empty.").Envar("REMOTE_WRITE_URL").String()
+	remoteWriteUsername         = kingpin.Flag("remote-write-username", "The username for basic authentication against the remote write endpoint.").Envar("REMOTE_WRITE_USERNAME").String()
+	remoteWritePassword         = kingpin.Flag("remote-write-password", "The password for basic authentication against the remote write endpoint.").Envar("REMOTE_WRITE_PASSWORD").String()
+	remoteWriteBearerToken      = kingpin.Flag("remote-write-bearer-token", "The bearer token for the remote write endpoint, used if no username is set.").Envar("REMOTE_WRITE_BEARER_TOKEN").String()
+	remoteWriteInterval         = kingpin.Flag("remote-write-interval", "The interval at which metrics are pushed to the remote write endpoint.").Envar("REMOTE_WRITE_INTERVAL").Default("60s").Duration()
+	statsdAddress               = kingpin.Flag("statsd-address", "The host:port of a StatsD or DogStatsD server to send gauges to over udp; disabled if empty.").Envar("STATSD_ADDRESS").String()
+	statsdPrefix                = kingpin.Flag("statsd-prefix", "The prefix to add to the StatsD metric names.").Envar("STATSD_PREFIX").String()
+	statsdDogstatsdTags         = kingpin.Flag("statsd-dogstatsd-tags", "Send labels as DogStatsD tags instead of appending their values to the metric name.").Envar("STATSD_DOGSTATSD_TAGS").Bool()
+	statsdInterval              = kingpin.Flag("statsd-interval", "The interval at which gauges are sent to StatsD.").Envar("STATSD_INTERVAL").Default("60s").Duration()
+	datadogAPIKey               = kingpin.Flag("datadog-api-key", "The Datadog api key to submit metrics with; disabled if empty.").Envar("DATADOG_API_KEY").String()
+	datadogSite                 = kingpin.Flag("datadog-site", "The Datadog site to submit metrics to.").Envar("DATADOG_SITE").Default("datadoghq.com").String()
+	datadogPrefix               = kingpin.Flag("datadog-prefix", "The prefix to add to the Datadog metric names.").Envar("DATADOG_PREFIX").String()
+	datadogTags                 = kingpin.Flag("datadog-tag", "An extra tag to add to all Datadog series, as key:value (repeatable).").Envar("DATADOG_TAGS").Strings()
+	datadogTagMapping           = kingpin.Flag("datadog-tag-mapping", "Rename a label to a different Datadog tag key, as label=tag (repeatable).").Envar("DATADOG_TAG_MAPPING").Strings()
+	datadogInterval             = kingpin.Flag("datadog-interval", "The interval at which metrics are submitted to Datadog.").Envar("DATADOG_INTERVAL").Default("60s").Duration()
+	graphiteAddress             = kingpin.Flag("graphite-address", "The host:port of a Graphite server to push metrics to using the plaintext protocol; disabled if empty.").Envar("GRAPHITE_ADDRESS").String()
+	graphitePrefix              = kingpin.Flag("graphite-prefix", "The prefix to add to the Graphite metric paths.").Envar("GRAPHITE_PREFIX").String()
+	graphiteInterval            = kingpin.Flag("graphite-interval", "The interval at which metrics are flushed to Graphite.").Envar("GRAPHITE_INTERVAL").Default("60s").Duration()
+	once                        = kingpin.Flag("once", "Fetch quota once, push the metrics to the pushgateway if configured or write them otherwise, and exit with a non-zero code if any collector failed, for running as job or cronjob.").Envar("ONCE").Bool()
+	onceOutputFile              = kingpin.Flag("once-output-file", "The file to write the metrics to in --once mode if no pushgateway is configured; defaults to stdout.").Envar("ONCE_OUTPUT_FILE").String()
+	pushgatewayURL              = kingpin.Flag("pushgateway-url", "The Pushgateway to push the metrics to in --once mode.").Envar("PUSHGATEWAY_URL").String()
+	pushgatewayJob              = kingpin.Flag("pushgateway-job", "The job name to push the metrics under.").Envar("PUSHGATEWAY_JOB").Default("estafette-gcloud-quota-exporter").String()
+	pushgatewayGrouping         = kingpin.Flag("pushgateway-grouping", "A grouping label to push the metrics under, as key=value (repeatable); defaults to instance=<deployment name> if the deployment name is set.").Envar("PUSHGATEWAY_GROUPING").Strings()
+	pushgatewayDeleteStaleAfter = kingpin.Flag("pushgateway-delete-stale-after", "Delete groups of the same job that haven't been pushed to for this long; 0 disables deletion.").Envar("PUSHGATEWAY_DELETE_STALE_AFTER").Default("0").Duration()
 
 	// seed random number
 	r = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -92,120 +223,397 @@ func init() {
 func main() {
 
 	// parse command line parameters
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
 
+	err := validateConfig()
+	if err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
+	// keep repeated errors, e.g. from an org wide permission issue, from flooding the logs
+	if *logSampleLimit > 0 {
+		log.Logger = log.Logger.Hook(newLogSampler(*logSampleLimit, *logSampleWindow))
+	}
+
 	// init /liveness endpoint
-	foundation.InitLiveness()
+	initLivenessServer()
+
+	tokenValidator, err := newBearerTokenValidator(*metricsBearerToken, *metricsBearerTokenFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Loading metrics bearer token failed")
+	}
+	if tokenValidator.enabled() {
+		log.Info().Msg("Requiring bearer token for metrics requests")
+	}
 
-	foundation.InitMetrics()
+	// init /metrics endpoint
+	initMetricsServer(tokenValidator, newIAPValidator(*iapAudience), newScrapeLimiter(*metricsRateLimit, *metricsRateBurst, *metricsMaxConcurrentScrapes))
 
-	ctx := context.Background()
-	client, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	if *auditLogEnabled {
+		err = initAuditLogging(*auditLogFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Initializing audit log failed")
+		}
+	}
+
+	configuredAPIPolicies, err = parseAPIPolicies(apiPolicy{Timeout: *apiTimeout, Retries: *apiRetries, MaxBackoff: *apiMaxBackoff}, *apiPolicyOverrides)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing api policies failed")
+	}
+
+	configuredGKELimits, err = parseGKELimits(*gkeLimits)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Creating google cloud client failed")
+		log.Fatal().Err(err).Msg("Parsing gke limits failed")
 	}
 
-	computeService, err := compute.New(client)
+	configuredAppEngineLimits, err = parseAppEngineLimits(*appEngineLimits)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Creating google cloud service failed")
+		log.Fatal().Err(err).Msg("Parsing app engine limits failed")
+	}
+
+	if *apiQPS > 0 {
+		apiLimiter = newTokenBucket(*apiQPS, *apiBurst)
+	}
+
+	if *recordDir != "" {
+		err = os.MkdirAll(*recordDir, 0755)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Creating record directory %v failed", *recordDir)
+		}
 	}
 
-	foundation.WatchForFileChanges(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), func(event fsnotify.Event) {
-		// reinitialize parts making use of the mounted data
+	ctx := context.Background()
 
-		client, err = google.DefaultClient(ctx, compute.CloudPlatformScope)
+	if *otlpTracesEndpoint != "" {
+		err = initTracing(ctx, *otlpTracesEndpoint, *otlpHeaders, 10*time.Second)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Creating google cloud client failed")
+			log.Fatal().Err(err).Msg("Initializing tracing failed")
 		}
+	}
+
+	err = initErrorReporting(ctx, *sentryDSN, *errorReportingProject)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Initializing error reporting failed")
+	}
 
-		computeService, err = compute.New(client)
+	if command == benchmarkCommand.FullCommand() {
+		err = runBenchmark(ctx, *benchmarkProjects, *benchmarkRegions, *benchmarkCycles, os.Stdout)
 		if err != nil {
+			log.Fatal().Err(err).Msg("Running benchmark failed")
+		}
+		return
+	}
 
+	var sources []credentialSource
+	var projects, regions []string
+	var clients *clientManager
+	if *simulate {
+		computeClient, simulatedProjects, simulatedRegions, err := newSimulatedComputeClient(*simulateProjects, *simulateRegions, *simulateDrift)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating simulated google cloud backend failed")
 		}
+		log.Warn().Msgf("Simulating quota for projects %v and regions %v, no google cloud apis are called", simulatedProjects, simulatedRegions)
+
+		projects = simulatedProjects
+		regions = simulatedRegions
+		clients = newStaticClientManager(computeClient)
+	} else {
+		sources, projects, regions, clients = initGoogleClients(ctx)
+	}
+
+	if *totalShards > 1 {
+		configuredProjects := len(projects)
+		projects = shardProjects(projects)
+		log.Info().Msgf("Fetching %v of %v projects as shard %v of %v", len(projects), configuredProjects, *shard, *totalShards)
+	}
+
+	quotaSources, err := newQuotaSources(*collectors, clients, regions)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Creating quota sources failed")
+	}
+
+	// check permissions up front and whenever credentials change
+	runPermissionPreflight(ctx, clients, quotaSources, projects)
+	clients.onReload(func(ctx context.Context) {
+		runPermissionPreflight(ctx, clients, quotaSources, projects)
+	})
+
+	if command == recordingRulesCommand.FullCommand() {
+		fetchQuota(ctx, clients, quotaSources, projects)
+		writeOutput(*recordingRulesFile, generateRecordingRules(quotaUpdates.snapshot()))
+		return
+	}
+
+	if command == topCommand.FullCommand() {
+		runTop(ctx, clients, quotaSources, projects, *topRows)
+		return
+	}
+
+	if *once {
+		runOnce(ctx, clients, quotaSources, projects)
+		return
+	}
+
+	// init admin endpoints
+	initAdminServer(&adminServer{
+		ctx:      ctx,
+		clients:  clients,
+		sources:  sources,
+		projects: projects,
+		regions:  regions,
 	})
 
+	// init grpc streaming api
+	initGRPCServer(tokenValidator)
+
+	// init push based outputs
+	initSinks(ctx)
+
+	// file quota increase requests
+	if *autoFileQuotaIncreases {
+		filer, err := newQuotaAutoFiler(clients, *autoFileThreshold, *autoFileCycles, *autoFileMultiplier, *autoFileCaps, *autoFileContactEmail)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating quota auto filer failed")
+		}
+		go filer.run(ctx)
+	}
+
 	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
 
-	// split projects to list
-	projects := strings.Split(*googleComputeProjects, ",")
+	// fetches are cancelled on shutdown, so in-flight api calls are aborted instead of holding up termination for a whole cycle
+	fetchCtx, cancelFetches := context.WithCancel(ctx)
+
+	var elector *leaderElector
+	if *leaderElect {
+		elector, err = newLeaderElector(*leaderElectNamespace, *leaderElectLeaseName, *leaderElectLeaseDuration, *leaderElectRenewDeadline, *leaderElectRetryPeriod)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Initializing leader election failed")
+		}
+	}
+	onShutdown := func() {
+		markShuttingDown()
+		cancelFetches()
+		if elector != nil {
+			releaseCtx, cancel := context.WithTimeout(ctx, *leaderElectRetryPeriod)
+			defer cancel()
+			elector.release(releaseCtx)
+		}
+	}
+
+	if *scrapeTimeFetch {
+		prometheus.MustRegister(newScrapeTimeCollector(fetchCtx, clients, quotaSources, projects, *scrapeFetchMinInterval))
+		log.Info().Msg("Fetching quota when metrics are scraped")
 
-	// split regions to list
-	regions := strings.Split(*googleComputeRegions, ",")
+		// quota is fetched on demand, so there's nothing to wait for
+		requireProjectsForReadiness(nil)
+		foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup, onShutdown)
+		return
+	}
 
 	// watch gcloud quota
+	requireProjectsForReadiness(projects)
+	enableRefresh()
+	waitGroup.Add(1)
 	go func(waitGroup *sync.WaitGroup) {
-		// loop indefinitely
-		for {
-			fetchQuota(ctx, computeService, projects, regions)
+		defer waitGroup.Done()
+
+		// a standby only fetches once it becomes leader; losing leadership exits, so it restarts as standby without quota series
+		if elector != nil {
+			err := elector.acquire(fetchCtx)
+			if err != nil {
+				return
+			}
+			go elector.renew(fetchCtx, func() {
+				log.Fatal().Msg("Lost leadership, exiting to rejoin as standby")
+			})
+		}
 
-			// sleep random time between 60s +- 25%
-			sleepTime := applyJitter(60)
-			log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+		// a standby never completes a cycle, so the watchdog is only armed once fetching starts
+		enableCycleWatchdog()
+
+		// loop until shutting down
+		refreshed := false
+		for fetchCtx.Err() == nil {
+			// a requested refresh isn't staggered, since it's expected to finish right away
+			cycleStart := time.Now()
+			cycleCtx := fetchCtx
+			if *staggerFetches && !refreshed {
+				cycleCtx = withStaggerWindow(fetchCtx, time.Duration(float64(*fetchInterval)*staggerWindowFraction))
+			}
+			fetchQuota(cycleCtx, clients, quotaSources, projects)
+
+			// sleep random time around the fetch interval, unless an immediate cycle is requested; staggered cycles take up most of
+			// the interval themselves, so only the rest of it is slept
+			sleepTime := applyJitter(*fetchInterval, *fetchJitterPercent)
+			if *staggerFetches {
+				sleepTime -= time.Since(cycleStart)
+			}
+			log.Debug().Msgf("Sleeping for %v...", sleepTime)
+			refreshed = sleepUntilRefresh(fetchCtx, sleepTime)
 		}
+		log.Info().Msg("Stopped fetching quota")
 	}(waitGroup)
 
-	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
+	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup, onShutdown)
 }
 
-func fetchQuota(ctx context.Context, computeService *compute.Service, projects, regions []string) {
+// initGoogleClients creates the clients for the configured credentials, which keep being reloaded when the credentials change,
+// and determines the projects and regions to get quota for
+func initGoogleClients(ctx context.Context) (sources []credentialSource, projects, regions []string, clients *clientManager) {
+
+	sources, err := parseCredentialSources(*credentialSources)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing credential sources failed")
+	}
 
-	log.Info().Msgf("Fetching gcloud quota for projects %v and regions %v...", projects, regions)
+	// split projects and regions to lists
+	lazy := *startupMode == "lazy"
+	projects, err = parseProjects(*googleComputeProjects)
+	if err != nil {
+		if !lazy {
+			log.Fatal().Err(err).Msg("Parsing projects failed")
+		}
+		projects = dropInvalidProjects(*googleComputeProjects)
+	}
+	regions, err = parseRegions(*googleComputeRegions)
+	if err != nil {
+		if !lazy {
+			log.Fatal().Err(err).Msg("Parsing regions failed")
+		}
+		regions = dropInvalidRegions(*googleComputeRegions)
+	}
 
+	// add the projects bound to credential sources and check whether any project needs the default credentials
+	useDefaultCredentials := false
+	boundProjects := []string{}
+	for _, source := range sources {
+		boundProjects = append(boundProjects, source.Projects...)
+	}
 	for _, project := range projects {
+		if !foundation.StringArrayContains(boundProjects, project) {
+			useDefaultCredentials = true
+		}
+	}
+	for _, project := range boundProjects {
+		if !foundation.StringArrayContains(projects, project) {
+			projects = append(projects, project)
+		}
+	}
 
-		p, err := computeService.Projects.Get(project).Context(ctx).Do()
-		if err != nil {
-			log.Fatal().Err(err).Msgf("Retrieving project detail for project %v failed", project)
+	clients, err = newClientManager(ctx, sources, useDefaultCredentials, lazy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Creating google cloud clients failed")
+	}
+
+	if useDefaultCredentials {
+		foundation.WatchForFileChanges(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), func(event fsnotify.Event) {
+			// reinitialize parts making use of the mounted data
+			clients.reload(ctx, "")
+		})
+	}
+	for _, source := range sources {
+		credentialsFile := source.File
+		foundation.WatchForFileChanges(credentialsFile, func(event fsnotify.Event) {
+			clients.reload(ctx, credentialsFile)
+		})
+	}
+
+	return
+}
+
+// writeOutput writes the content of a generating command to the file, or stdout if empty
+func writeOutput(file, content string) {
+
+	if file == "" {
+		fmt.Print(content)
+		return
+	}
+
+	err := ioutil.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Writing %v failed", file)
+	}
+}
+
+// runOnce fetches quota a single time and pushes the result to the pushgateway if configured, or writes it in the prometheus text
+// format otherwise; it exits with a non-zero code if any collector failed, after publishing what was retrieved
+func runOnce(ctx context.Context, clients clientProvider, quotaSources []quotaSource, projects []string) {
+
+	failures := fetchQuota(ctx, clients, quotaSources, projects)
+	defer func() {
+		if failures > 0 {
+			log.Error().Msgf("Fetching quota failed %v times", failures)
+			os.Exit(1)
 		}
+	}()
 
-		updateGlobalQuota(p.Quotas, project)
+	if *pushgatewayURL == "" {
+		recorder := httptest.NewRecorder()
+		promhttp.HandlerFor(metricsGatherer, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, *prometheusMetricsPath, nil))
+		writeOutput(*onceOutputFile, recorder.Body.String())
+		return
+	}
 
-		for _, region := range regions {
-			r, err := computeService.Regions.Get(project, region).Context(ctx).Do()
-			if err != nil {
-				log.Fatal().Err(err).Msgf("Retrieving region detail for project %v and region %v failed", project, region)
-			}
+	grouping, err := parseGroupingLabels(*pushgatewayGrouping)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing pushgateway grouping labels failed")
+	}
+	if len(grouping) == 0 && *deploymentName != "" {
+		grouping["instance"] = *deploymentName
+	}
 
-			updateRegionalQuota(r.Quotas, project, region)
+	err = pushToPushgateway(*pushgatewayURL, *pushgatewayJob, grouping)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Pushing metrics to pushgateway %v failed", *pushgatewayURL)
+	}
+	log.Info().Msgf("Pushed metrics to pushgateway %v", *pushgatewayURL)
+
+	if *pushgatewayDeleteStaleAfter > 0 {
+		err = deleteStalePushgatewayGroups(*pushgatewayURL, *pushgatewayJob, grouping, *pushgatewayDeleteStaleAfter)
+		if err != nil {
+			log.Warn().Err(err).Msg("Deleting stale pushgateway groups failed")
 		}
 	}
 }
 
-func updateGlobalQuota(quotas []*compute.Quota, project string) (err error) {
+func updateGlobalQuota(ctx context.Context, quotas []*compute.Quota, project string) (err error) {
 
 	for _, quota := range quotas {
 
-		metricName := casee.ToSnakeCase(quota.Metric)
+		metricName := quotaMetricName(quota.Metric)
 
-		globalQuotaLimit.WithLabelValues(project, metricName).Set(quota.Limit)
-		globalQuotaUsage.WithLabelValues(project, metricName).Set(quota.Usage)
+		setGauge(ctx, globalQuotaLimit, quota.Limit, project, metricName)
+		setGauge(ctx, globalQuotaUsage, quota.Usage, project, metricName)
 
 	}
 
 	return
 }
 
-func updateRegionalQuota(quotas []*compute.Quota, project, region string) (err error) {
+func updateRegionalQuota(ctx context.Context, quotas []*compute.Quota, project, region string) (err error) {
 
 	for _, quota := range quotas {
 
-		metricName := casee.ToSnakeCase(quota.Metric)
+		metricName := quotaMetricName(quota.Metric)
 
-		regionalQuotaLimit.WithLabelValues(project, region, metricName).Set(quota.Limit)
-		regionalQuotaUsage.WithLabelValues(project, region, metricName).Set(quota.Usage)
+		setGauge(ctx, regionalQuotaLimit, quota.Limit, project, region, metricName)
+		setGauge(ctx, regionalQuotaUsage, quota.Usage, project, region, metricName)
 
 	}
 
 	return
 }
 
-func applyJitter(input int) (output int) {
+// applyJitter returns a random duration within percent of the interval
+func applyJitter(interval time.Duration, percent int) time.Duration {
 
-	deviation := int(0.25 * float64(input))
+	deviation := int64(float64(interval) * float64(percent) / 100)
+	if deviation <= 0 {
+		return interval
+	}
 
-	return input - deviation + r.Intn(2*deviation)
+	return interval - time.Duration(deviation) + time.Duration(r.Int63n(2*deviation))
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// resetTestState removes the series earlier tests left in the gauges and forgets which series were set, since both are global
+func resetTestState(gauges ...*prometheus.GaugeVec) {
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	for _, gauge := range gauges {
+		gauge.Reset()
+	}
+	for owner := range activeSeries {
+		delete(activeSeries, owner)
+	}
+}
+
+// runTestCycle collects the quota of the projects with the source like a fetch cycle does and applies the gauges, so series that
+// aren't set again are removed as stale
+func runTestCycle(t *testing.T, source quotaSource, projects ...string) map[string]error {
+
+	t.Helper()
+
+	ctx, snapshot := withGaugeSnapshot(context.Background())
+	snapshot.listProjects(source.Name(), projects)
+
+	errs := map[string]error{}
+	for _, project := range projects {
+		_, err := collectQuotaSource(withSeriesOwner(ctx, source.Name(), project), source, project)
+		if err == nil {
+			snapshot.markCollected(source.Name(), project)
+		}
+		errs[project] = err
+	}
+	snapshot.apply()
+
+	return errs
+}
+
+// expectedSeries is the value a test expects for the series of a gauge
+type expectedSeries struct {
+	gauge  *prometheus.GaugeVec
+	labels prometheus.Labels
+	value  float64
+}
+
+// assertTestCycle checks the outcome of a test cycle and the series it exported
+func assertTestCycle(t *testing.T, err error, expectErr bool, expected, absent []expectedSeries) {
+
+	t.Helper()
+
+	if expectErr {
+		if err == nil {
+			t.Fatal("Expected collecting to fail")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Collecting failed: %v", err)
+	}
+
+	for _, series := range expected {
+		if value := gaugeValue(t, series.gauge, series.labels); value != series.value {
+			t.Errorf("Series %v is %v, expected %v", series.labels, value, series.value)
+		}
+	}
+	for _, series := range absent {
+		if hasSeries(series.gauge, series.labels) {
+			t.Errorf("Series %v was exported", series.labels)
+		}
+	}
+}
+
+// gaugeValue returns the value of the series, failing the test if it doesn't exist
+func gaugeValue(t *testing.T, gauge *prometheus.GaugeVec, labels prometheus.Labels) float64 {
+
+	t.Helper()
+
+	if !hasSeries(gauge, labels) {
+		t.Fatalf("Series %v doesn't exist", labels)
+	}
+
+	return testutil.ToFloat64(gauge.With(labels))
+}
+
+// hasSeries checks whether the series with the labels is exported, without creating it like gauge.With does
+func hasSeries(gauge *prometheus.GaugeVec, labels prometheus.Labels) bool {
+
+	ch := make(chan prometheus.Metric, 1000)
+	gauge.Collect(ch)
+	close(ch)
+
+	for m := range ch {
+		metric := &dto.Metric{}
+		if err := m.Write(metric); err != nil || len(metric.Label) != len(labels) {
+			continue
+		}
+
+		match := true
+		for _, pair := range metric.Label {
+			if value, ok := labels[pair.GetName()]; !ok || value != pair.GetValue() {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testQuotas returns compute quota with the limit and usage per metric
+func testQuotas(values map[string][2]float64) []*compute.Quota {
+
+	quotas := []*compute.Quota{}
+	for metric, limitAndUsage := range values {
+		quotas = append(quotas, &compute.Quota{Metric: metric, Limit: limitAndUsage[0], Usage: limitAndUsage[1]})
+	}
+
+	return quotas
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauges for the memorystore for redis instances and their memory per region
+	memorystoreInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_memorystore_instances",
+		Help: "The number of memorystore for redis instances per region.",
+	}, []string{"project", "region"})
+
+	memorystoreMemoryGB = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_memorystore_memory_gb",
+		Help: "The provisioned memory of all memorystore for redis instances per region.",
+	}, []string{"project", "region"})
+
+	// create gauge for the memorystore quota, like the total memory per region
+	memorystoreQuotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_memorystore_limit",
+		Help: "The limit of a memorystore for redis quota as reported by the service usage api, with region global for quota that isn't regional; -1 is unlimited.",
+	}, []string{"project", "region", "quota_metric", "unit"})
+)
+
+func init() {
+	prometheus.MustRegister(memorystoreInstances)
+	prometheus.MustRegister(memorystoreMemoryGB)
+	prometheus.MustRegister(memorystoreQuotaLimit)
+
+	registerQuotaSource("memorystore", func(clients clientProvider, regions []string) quotaSource {
+		return &memorystoreQuotaSource{clients: clients, regions: regions}
+	})
+}
+
+// memorystoreQuotaSource exports the memorystore for redis instances and memory per region next to the redis quota; like for filestore
+// the usage is counted from the instances
+type memorystoreQuotaSource struct {
+	clients clientProvider
+	regions []string
+}
+
+func (s *memorystoreQuotaSource) Name() string {
+	return "memorystore"
+}
+
+// Discover doesn't return locations, instances in all regions are listed at once
+func (s *memorystoreQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *memorystoreQuotaSource) Permissions() []string {
+	return []string{"redis.instances.list", "serviceusage.quotas.get"}
+}
+
+func (s *memorystoreQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]float64{}
+	memoryGB := map[string]float64{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("fields", "instances(name,memorySizeGb),unreachable,nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Instances []struct {
+				Name         string  `json:"name"`
+				MemorySizeGb float64 `json:"memorySizeGb"`
+			} `json:"instances"`
+			Unreachable   []string `json:"unreachable"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://redis.googleapis.com/v1/projects/%v/locations/-/instances?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		// instances in unreachable locations are left out of the list, which would export too little memory for their region
+		if len(response.Unreachable) > 0 {
+			return nil, fmt.Errorf("Listing memorystore instances for project %v returned a partial list, locations %v are missing", project, strings.Join(response.Unreachable, ", "))
+		}
+
+		for _, instance := range response.Instances {
+			// names are like projects/p/locations/europe-west1/instances/i
+			segments := strings.Split(instance.Name, "/")
+			if len(segments) != 6 {
+				return nil, fmt.Errorf("Parsing memorystore instance name %v failed", instance.Name)
+			}
+			region := segments[3]
+			if len(s.regions) > 0 && !stringInSlice(s.regions, region) {
+				continue
+			}
+
+			instances[region]++
+			memoryGB[region] += instance.MemorySizeGb
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing memorystore instances failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	buckets, err := listConsumerQuotaBuckets(ctx, httpClient, project, "redis.googleapis.com")
+	if err != nil {
+		return nil, err
+	}
+
+	for region, count := range instances {
+		setGauge(ctx, memorystoreInstances, count, project, region)
+		setGauge(ctx, memorystoreMemoryGB, memoryGB[region], project, region)
+	}
+
+	for _, bucket := range buckets {
+		region, ok := quotaDimensionsRegion(bucket.Dimensions, s.regions)
+		if !ok {
+			continue
+		}
+		value, ok := sanitizeQuotaValue("gcloud", project, bucket.Metric, "limit", float64(bucket.EffectiveLimit))
+		if !ok {
+			continue
+		}
+		setGauge(ctx, memorystoreQuotaLimit, value, project, region, bucket.Metric, bucket.Unit)
+	}
+
+	// memorystore quota isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// otlpSink pushes metrics to an opentelemetry collector using otlp over http with json encoding
+type otlpSink struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// newOTLPSink creates a sink for an endpoint like http://otel-collector:4318/v1/metrics, with headers in the form key=value
+func newOTLPSink(endpoint string, headers []string) (*otlpSink, error) {
+
+	sink := &otlpSink{
+		endpoint: endpoint,
+		headers:  map[string]string{},
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, header := range headers {
+		keyValue := strings.SplitN(header, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("Otlp header %q is not in the form key=value", header)
+		}
+		sink.headers[keyValue[0]] = keyValue[1]
+	}
+
+	return sink, nil
+}
+
+func (s *otlpSink) Name() string {
+	return "otlp endpoint " + s.endpoint
+}
+
+func (s *otlpSink) Push(ctx context.Context, families []*dto.MetricFamily) error {
+
+	body, err := json.Marshal(otlpRequest(families, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Otlp endpoint returned status %v: %v", resp.StatusCode, string(message))
+	}
+
+	return nil
+}
+
+// otlpRequest converts the prometheus metric families into an ExportMetricsServiceRequest in its json mapping, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+func otlpRequest(families []*dto.MetricFamily, now time.Time) map[string]interface{} {
+
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := []map[string]interface{}{}
+	for _, family := range families {
+
+		metric := map[string]interface{}{
+			"name":        family.GetName(),
+			"description": family.GetHelp(),
+		}
+
+		dataPoints := []map[string]interface{}{}
+		for _, m := range family.GetMetric() {
+			dataPoint := map[string]interface{}{
+				"attributes":   otlpAttributes(labelMap(m)),
+				"timeUnixNano": timestamp,
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				dataPoint["asDouble"] = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				dataPoint["asDouble"] = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				dataPoint["asDouble"] = m.GetUntyped().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				// otlp expects the count per bucket rather than prometheus' cumulative counts
+				histogram := m.GetHistogram()
+				bounds := []float64{}
+				counts := []string{}
+				previous := uint64(0)
+				for _, bucket := range histogram.GetBucket() {
+					bounds = append(bounds, bucket.GetUpperBound())
+					counts = append(counts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+					previous = bucket.GetCumulativeCount()
+				}
+				counts = append(counts, strconv.FormatUint(histogram.GetSampleCount()-previous, 10))
+
+				dataPoint["count"] = strconv.FormatUint(histogram.GetSampleCount(), 10)
+				dataPoint["sum"] = histogram.GetSampleSum()
+				dataPoint["explicitBounds"] = bounds
+				dataPoint["bucketCounts"] = counts
+			default:
+				// summaries aren't used by this exporter
+				continue
+			}
+
+			dataPoints = append(dataPoints, dataPoint)
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric["sum"] = map[string]interface{}{
+				"dataPoints":             dataPoints,
+				"aggregationTemporality": 2,
+				"isMonotonic":            true,
+			}
+		case dto.MetricType_HISTOGRAM:
+			metric["histogram"] = map[string]interface{}{
+				"dataPoints":             dataPoints,
+				"aggregationTemporality": 2,
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric["gauge"] = map[string]interface{}{
+				"dataPoints": dataPoints,
+			}
+		default:
+			continue
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []map[string]interface{}{
+			{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{
+						"service.name":    "estafette-gcloud-quota-exporter",
+						"service.version": version,
+					}),
+				},
+				"scopeMetrics": []map[string]interface{}{
+					{
+						"scope": map[string]interface{}{
+							"name":    "estafette-gcloud-quota-exporter",
+							"version": version,
+						},
+						"metrics": metrics,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(labels map[string]string) []map[string]interface{} {
+
+	attributes := []map[string]interface{}{}
+	for key, value := range labels {
+		attributes = append(attributes, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": value},
+		})
+	}
+
+	return attributes
+}
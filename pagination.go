@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+// maxListPages bounds the number of pages a single list call can follow, so an api that keeps handing out new page tokens can't stall a fetch cycle
+const maxListPages = 1000
+
+// pageTokens tracks the page tokens returned by a list call; every list call that can span multiple pages follows them until
+// the last page, since our organization is large enough that assuming a single page silently drops resources
+type pageTokens struct {
+	pages int
+	seen  map[string]bool
+}
+
+// next records the token of the page that was just retrieved and returns whether there's another page to retrieve, failing
+// if the api returns a token it handed out before or the list exceeds the maximum number of pages
+func (p *pageTokens) next(token string) (bool, error) {
+
+	p.pages++
+	if token == "" {
+		return false, nil
+	}
+
+	if p.seen == nil {
+		p.seen = map[string]bool{}
+	}
+	if p.seen[token] {
+		return false, fmt.Errorf("Page token %v was returned twice after %v pages", token, p.pages)
+	}
+	if p.pages >= maxListPages {
+		return false, fmt.Errorf("List exceeded the maximum of %v pages", maxListPages)
+	}
+	p.seen[token] = true
+
+	return true, nil
+}
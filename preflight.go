@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+var (
+	// create gauge for whether the credentials have the permissions a collector needs in a project
+	permissionGranted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_permission_granted",
+		Help: "Whether the credentials used for the project have the permission (1) or not (0), as checked on startup and reload.",
+	}, []string{"project", "permission"})
+
+	// missing permissions per project as found by the last preflight check
+	missingPermissions      = map[string][]string{}
+	missingPermissionsMutex sync.Mutex
+)
+
+func init() {
+	prometheus.MustRegister(permissionGranted)
+}
+
+// permissionsQuotaSource is implemented by sources that know the iam permissions they need in each project
+type permissionsQuotaSource interface {
+	Permissions() []string
+}
+
+func (s *computeQuotaSource) Permissions() []string {
+	return []string{"compute.projects.get", "compute.regions.list"}
+}
+
+func (s *gkeAutoscalerQuotaSource) Permissions() []string {
+	return []string{"container.clusters.list", "compute.instanceGroupManagers.get", "compute.machineTypes.get", "compute.regions.get"}
+}
+
+// runPermissionPreflight tests the permissions the enabled collectors need in each project, so missing permissions show up in the logs,
+// the status page and metrics right away instead of when quota is first retrieved
+func runPermissionPreflight(ctx context.Context, clients clientProvider, sources []quotaSource, projects []string) {
+
+	permissions := []string{}
+	for _, source := range sources {
+		if s, ok := source.(permissionsQuotaSource); ok {
+			for _, permission := range s.Permissions() {
+				if !stringInSlice(permissions, permission) {
+					permissions = append(permissions, permission)
+				}
+			}
+		}
+	}
+	if len(permissions) == 0 {
+		return
+	}
+	// skipping inactive projects reads their lifecycle state
+	if *skipInactiveProjects {
+		permissions = append(permissions, "resourcemanager.projects.get")
+	}
+	sort.Strings(permissions)
+
+	for _, project := range projects {
+
+		httpClient, err := clients.httpClient(project)
+		if err != nil {
+			// injected clients, like the simulated backend, don't need permissions
+			log.Debug().Err(err).Msgf("Skipping permission check for project %v", project)
+			continue
+		}
+
+		service, err := cloudresourcemanager.New(httpClient)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Checking permissions for project %v failed", project)
+			continue
+		}
+
+		response, err := service.Projects.TestIamPermissions(project, &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions}).Context(ctx).Do()
+		if err != nil {
+			log.Warn().Err(err).Msgf("Checking permissions for project %v failed", project)
+			continue
+		}
+
+		missing := []string{}
+		for _, permission := range permissions {
+			if stringInSlice(response.Permissions, permission) {
+				permissionGranted.WithLabelValues(project, permission).Set(1)
+			} else {
+				permissionGranted.WithLabelValues(project, permission).Set(0)
+				missing = append(missing, permission)
+			}
+		}
+
+		missingPermissionsMutex.Lock()
+		if len(missing) > 0 {
+			missingPermissions[project] = missing
+		} else {
+			delete(missingPermissions, project)
+		}
+		missingPermissionsMutex.Unlock()
+
+		if len(missing) > 0 {
+			log.Error().Msgf("Credentials for project %v lack permissions %v", project, strings.Join(missing, ", "))
+		} else {
+			log.Debug().Msgf("Credentials for project %v have all required permissions", project)
+		}
+	}
+}
+
+// missingPermissionsStatus returns a copy of the missing permissions per project for the status page
+func missingPermissionsStatus() map[string][]string {
+
+	missingPermissionsMutex.Lock()
+	defer missingPermissionsMutex.Unlock()
+
+	status := map[string][]string{}
+	for project, missing := range missingPermissions {
+		status[project] = missing
+	}
+
+	return status
+}
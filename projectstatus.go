@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// create gauge for whether quota could be retrieved for a project in the last attempt
+	projectUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_up",
+		Help: "Whether quota for the project was retrieved successfully by all collectors in the last attempt (1) or not (0).",
+	}, []string{"project"})
+
+	// create info metric with the reason a project is down
+	projectErrorInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_project_error_info",
+		Help: "Set to 1 with the reason the last attempt to retrieve quota for the project failed; absent while the project is up.",
+	}, []string{"project", "reason"})
+
+	// create counter for failed attempts per project and collector, to alert on broken projects individually
+	scrapeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_scrape_errors_total",
+		Help: "The number of failed attempts to retrieve quota, by project and scope, the collector that failed; project is empty if listing the collector's projects failed.",
+	}, []string{"project", "scope"})
+
+	// create gauge for whether the last attempt for a project succeeded
+	scrapeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_scrape_success",
+		Help: "Whether all collectors retrieved quota for the project successfully in the last attempt (1) or not (0).",
+	}, []string{"project"})
+
+	// create histogram for how long retrieving quota takes per project and collector, to alert on slow collection
+	fetchDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "estafette_gcloud_quota_fetch_duration_seconds",
+		Help:    "The time it took to retrieve quota for the project, by scope, the collector, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"project", "scope"})
+
+	// create gauge for when all collectors last retrieved quota for a project, to alert on stale collection
+	lastSuccessfulFetch = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_last_successful_fetch_timestamp_seconds",
+		Help: "The time quota for the project was last retrieved successfully by all collectors.",
+	}, []string{"project"})
+
+	// errBlockedByVPCServiceControls marks projects skipped during the backoff after a perimeter violation
+	errBlockedByVPCServiceControls = errors.New("Project is blocked by a VPC Service Controls perimeter")
+
+	projectErrorReasons      = map[string]string{}
+	projectErrorReasonsMutex sync.Mutex
+
+	// projectHealth has the outcome of the last attempt per project for /healthz/details; guarded by projectErrorReasonsMutex
+	projectHealth = map[string]projectHealthStatus{}
+)
+
+// projectHealthStatus is the outcome of the last attempt to retrieve quota for a project
+type projectHealthStatus struct {
+	Status      string     `json:"status"`
+	LastAttempt time.Time  `json:"lastAttempt"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func init() {
+	prometheus.MustRegister(projectUp)
+	prometheus.MustRegister(projectErrorInfo)
+	prometheus.MustRegister(scrapeErrorsTotal)
+	prometheus.MustRegister(scrapeSuccess)
+	prometheus.MustRegister(fetchDurationSeconds)
+	prometheus.MustRegister(lastSuccessfulFetch)
+}
+
+// updateProjectStatus sets the up and error info metrics from the outcome of a fetch cycle, which has the first error per attempted project
+func updateProjectStatus(outcomes map[string]error) {
+
+	projectErrorReasonsMutex.Lock()
+	defer projectErrorReasonsMutex.Unlock()
+
+	now := time.Now()
+	for project, err := range outcomes {
+
+		health := projectHealth[project]
+		health.LastAttempt = now
+
+		reason := ""
+		if err != nil {
+			reason = errorReason(err)
+		}
+
+		if previous, ok := projectErrorReasons[project]; ok && previous != reason {
+			projectErrorInfo.DeleteLabelValues(project, previous)
+			delete(projectErrorReasons, project)
+		}
+
+		if err == nil {
+			projectUp.WithLabelValues(project).Set(1)
+			scrapeSuccess.WithLabelValues(project).Set(1)
+			lastSuccessfulFetch.WithLabelValues(project).Set(float64(now.Unix()))
+			projectHealth[project] = projectHealthStatus{Status: "up", LastAttempt: now, LastSuccess: &now}
+			continue
+		}
+
+		projectUp.WithLabelValues(project).Set(0)
+		scrapeSuccess.WithLabelValues(project).Set(0)
+		projectErrorInfo.WithLabelValues(project, reason).Set(1)
+		projectErrorReasons[project] = reason
+
+		health.Status = "down"
+		health.Reason = reason
+		health.Error = err.Error()
+		projectHealth[project] = health
+	}
+}
+
+// projectHealthDetails returns a copy of the outcome of the last attempt per project
+func projectHealthDetails() map[string]projectHealthStatus {
+
+	projectErrorReasonsMutex.Lock()
+	defer projectErrorReasonsMutex.Unlock()
+
+	details := make(map[string]projectHealthStatus, len(projectHealth))
+	for project, health := range projectHealth {
+		details[project] = health
+	}
+
+	return details
+}
+
+// errorReason classifies an error into a short reason usable as label value
+func errorReason(err error) string {
+
+	if err == errBlockedByVPCServiceControls || isVPCServiceControlsError(err) {
+		return "vpc_service_controls"
+	}
+	if err == errComputeAPIDisabled || isComputeAPIDisabledError(err) {
+		return "api_disabled"
+	}
+	if err == errProjectInactive {
+		return "inactive"
+	}
+	if err == errProjectDead {
+		return "dead"
+	}
+	if isCredentialsUnavailableError(err) {
+		return "credentials_unavailable"
+	}
+
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		switch {
+		case apiErr.Code == 401:
+			return "unauthenticated"
+		case apiErr.Code == 403:
+			return "permission_denied"
+		case apiErr.Code == 404:
+			return "not_found"
+		case apiErr.Code == 429:
+			return "rate_limited"
+		case apiErr.Code >= 500:
+			return "unavailable"
+		}
+		return "api_error"
+	}
+
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return "timeout"
+	}
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauge for the limit value of other clouds, with the account or subscription as project
+	providerQuotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_cloud_quota_limit",
+		Help: "The limit for quota of other cloud providers.",
+	}, []string{"provider", "project", "region", "metric"})
+
+	// create gauge for the usage value of other clouds
+	providerQuotaUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_cloud_quota_usage",
+		Help: "The usage for quota of other cloud providers.",
+	}, []string{"provider", "project", "region", "metric"})
+
+	nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func init() {
+	prometheus.MustRegister(providerQuotaLimit)
+	prometheus.MustRegister(providerQuotaUsage)
+}
+
+// updateProviderQuota sets the gauges for quota of another cloud provider; usage is only set if the provider reports it
+func updateProviderQuota(ctx context.Context, update quotaUpdate, hasUsage bool) {
+
+	setGauge(ctx, providerQuotaLimit, update.Limit, update.Provider, update.Project, update.Region, update.Metric)
+	if hasUsage {
+		setGauge(ctx, providerQuotaUsage, update.Usage, update.Provider, update.Project, update.Region, update.Metric)
+	}
+}
+
+// toMetricName turns a descriptive quota name like "Running On-Demand Standard instances" into a metric label value like
+// running_on_demand_standard_instances
+func toMetricName(name string) string {
+	return strings.Trim(nonAlphanumericRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/rs/zerolog/log"
+)
+
+// parseGroupingLabels parses grouping labels in the form key=value
+func parseGroupingLabels(values []string) (map[string]string, error) {
+
+	grouping := map[string]string{}
+	for _, value := range values {
+		keyValue := strings.SplitN(value, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return nil, fmt.Errorf("Grouping label %q is not in the form key=value", value)
+		}
+		grouping[keyValue[0]] = keyValue[1]
+	}
+
+	return grouping, nil
+}
+
+// pushToPushgateway replaces the metrics of the job and grouping labels at the pushgateway with the current metrics
+func pushToPushgateway(gatewayURL, job string, grouping map[string]string) error {
+
+	pusher := push.New(gatewayURL, job).Gatherer(metricsGatherer)
+	for key, value := range grouping {
+		pusher = pusher.Grouping(key, value)
+	}
+
+	return pusher.Push()
+}
+
+// deleteStalePushgatewayGroups deletes all groups of the job that haven't been pushed to for longer than maxAge, except the group
+// with the current grouping labels, so runs that were decommissioned or renamed don't leave frozen series behind
+func deleteStalePushgatewayGroups(gatewayURL, job string, grouping map[string]string, maxAge time.Duration) error {
+
+	if !strings.Contains(gatewayURL, "://") {
+		gatewayURL = "http://" + gatewayURL
+	}
+	gatewayURL = strings.TrimSuffix(gatewayURL, "/")
+
+	resp, err := http.Get(gatewayURL + "/api/v1/metrics")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Listing pushgateway groups returned status %v", resp.StatusCode)
+	}
+
+	var response struct {
+		Data []struct {
+			Labels          map[string]string `json:"labels"`
+			PushTimeSeconds struct {
+				Metrics []struct {
+					Value string `json:"value"`
+				} `json:"metrics"`
+			} `json:"push_time_seconds"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+
+	current := map[string]string{"job": job}
+	for key, value := range grouping {
+		current[key] = value
+	}
+
+	for _, group := range response.Data {
+		if group.Labels["job"] != job || reflect.DeepEqual(group.Labels, current) || len(group.PushTimeSeconds.Metrics) == 0 {
+			continue
+		}
+
+		pushTime, err := strconv.ParseFloat(group.PushTimeSeconds.Metrics[0].Value, 64)
+		if err != nil || time.Since(time.Unix(int64(pushTime), 0)) < maxAge {
+			continue
+		}
+
+		log.Info().Msgf("Deleting stale pushgateway group %v, last pushed at %v", group.Labels, time.Unix(int64(pushTime), 0))
+
+		req, err := http.NewRequest(http.MethodDelete, gatewayURL+pushgatewayGroupPath(group.Labels), nil)
+		if err != nil {
+			return err
+		}
+		deleteResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		deleteResp.Body.Close()
+
+		if deleteResp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("Deleting pushgateway group %v returned status %v", group.Labels, deleteResp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// pushgatewayGroupPath builds the /metrics/job/<job>/<label>/<value> path of a group, base64 encoding values the way the pushgateway expects
+func pushgatewayGroupPath(labels map[string]string) string {
+
+	encode := func(name, value string) string {
+		if value == "" || strings.Contains(value, "/") {
+			return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+		}
+		return name + "/" + url.PathEscape(value)
+	}
+
+	path := "/metrics/" + encode("job", labels["job"])
+	for name, value := range labels {
+		if name == "job" {
+			continue
+		}
+		path += "/" + encode(name, value)
+	}
+
+	return path
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// quotaUpdate is the limit and usage of a single quota as retrieved in a fetch cycle; region is empty for global quota and provider
+// is empty for google cloud
+type quotaUpdate struct {
+	Provider  string    `json:"provider,omitempty"`
+	Project   string    `json:"project"`
+	Region    string    `json:"region,omitempty"`
+	Metric    string    `json:"metric"`
+	Limit     float64   `json:"limit"`
+	Usage     float64   `json:"usage"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func toQuotaUpdates(quotas []*compute.Quota, project, region string, timestamp time.Time) []quotaUpdate {
+
+	updates := make([]quotaUpdate, 0, len(quotas))
+	for _, quota := range quotas {
+		updates = append(updates, quotaUpdate{
+			Project:   project,
+			Region:    region,
+			Metric:    quotaMetricName(quota.Metric),
+			Limit:     quota.Limit,
+			Usage:     quota.Usage,
+			Timestamp: timestamp,
+		})
+	}
+
+	return updates
+}
+
+// filterQuotaUpdates keeps the updates for the projects and metrics; empty filters match everything
+func filterQuotaUpdates(updates []quotaUpdate, projects, metrics []string) []quotaUpdate {
+
+	if len(projects) == 0 && len(metrics) == 0 {
+		return updates
+	}
+
+	filtered := []quotaUpdate{}
+	for _, update := range updates {
+		if len(projects) > 0 && !stringInSlice(projects, update.Project) {
+			continue
+		}
+		if len(metrics) > 0 && !stringInSlice(metrics, update.Metric) {
+			continue
+		}
+		filtered = append(filtered, update)
+	}
+
+	return filtered
+}
+
+func stringInSlice(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaBroadcaster hands the results of each fetch cycle to all subscribed streaming clients
+type quotaBroadcaster struct {
+	subscribers map[chan []quotaUpdate]struct{}
+	latest      []quotaUpdate
+	mutex       sync.Mutex
+}
+
+// quotaUpdates distributes the quota of every finished fetch cycle
+var quotaUpdates = &quotaBroadcaster{
+	subscribers: map[chan []quotaUpdate]struct{}{},
+}
+
+// subscribe returns a channel receiving the updates of every following cycle and the updates of the last finished cycle
+func (b *quotaBroadcaster) subscribe() (chan []quotaUpdate, []quotaUpdate) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch := make(chan []quotaUpdate, 1)
+	b.subscribers[ch] = struct{}{}
+
+	return ch, b.latest
+}
+
+func (b *quotaBroadcaster) unsubscribe(ch chan []quotaUpdate) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.subscribers, ch)
+}
+
+// snapshot returns the updates of the last finished cycle
+func (b *quotaBroadcaster) snapshot() []quotaUpdate {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.latest
+}
+
+// publish merges the updates of a cycle into the latest ones and sends the result to all subscribers; a subscriber that hasn't
+// consumed the previous cycle yet gets the older cycle replaced
+func (b *quotaBroadcaster) publish(updates []quotaUpdate, listed, succeeded map[string]bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	updates = mergeQuotaUpdates(b.latest, updates, listed, succeeded)
+	b.latest = updates
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- updates:
+		default:
+			// drop the unconsumed cycle in favour of the new one
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- updates
+		}
+	}
+}
+
+// quotaUpdateKey identifies the quota of an update, so updates of consecutive cycles can be merged
+type quotaUpdateKey struct {
+	provider string
+	project  string
+	region   string
+	metric   string
+}
+
+// mergeQuotaUpdates keeps the previous values a project didn't return in this cycle, so a failing collector or call doesn't make its
+// quota disappear until the next cycle; projects that succeeded are replaced entirely and projects that aren't listed anymore are
+// dropped, unless listed is nil because not all projects could be listed
+func mergeQuotaUpdates(previous, updates []quotaUpdate, listed, succeeded map[string]bool) []quotaUpdate {
+
+	updated := map[quotaUpdateKey]bool{}
+	for _, update := range updates {
+		updated[quotaUpdateKey{provider: update.Provider, project: update.Project, region: update.Region, metric: update.Metric}] = true
+	}
+
+	merged := append(make([]quotaUpdate, 0, len(updates)), updates...)
+	for _, update := range previous {
+		if updated[quotaUpdateKey{provider: update.Provider, project: update.Project, region: update.Region, metric: update.Metric}] || succeeded[update.Project] {
+			continue
+		}
+		if listed != nil && !listed[update.Project] {
+			continue
+		}
+		merged = append(merged, update)
+	}
+
+	return merged
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create info metric with the state of quota preferences, to follow quota increase requests
+	quotaPreferenceInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_preference_info",
+		Help: "Set to 1 with the state of a quota preference: pending while it's being reconciled, approved once the granted value reaches the requested one, or denied otherwise.",
+	}, []string{"project", "service", "quota_id", "dimensions", "preference", "state"})
+
+	// create gauges for the requested and granted value of quota preferences
+	quotaPreferenceRequested = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_preference_requested_value",
+		Help: "The value requested by a quota preference.",
+	}, []string{"project", "service", "quota_id", "dimensions", "preference"})
+
+	quotaPreferenceGranted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_preference_granted_value",
+		Help: "The value granted for a quota preference so far.",
+	}, []string{"project", "service", "quota_id", "dimensions", "preference"})
+
+	// create gauge for when a quota preference was last updated, to see how long requests have been pending
+	quotaPreferenceUpdated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_preference_update_timestamp_seconds",
+		Help: "The time a quota preference was last updated.",
+	}, []string{"project", "service", "quota_id", "dimensions", "preference"})
+)
+
+func init() {
+	prometheus.MustRegister(quotaPreferenceInfo)
+	prometheus.MustRegister(quotaPreferenceRequested)
+	prometheus.MustRegister(quotaPreferenceGranted)
+	prometheus.MustRegister(quotaPreferenceUpdated)
+
+	registerQuotaSource("quota-preferences", func(clients clientProvider, regions []string) quotaSource {
+		return &quotaPreferencesQuotaSource{clients: clients}
+	})
+}
+
+// quotaPreference is the part of a quota preference of the cloud quotas api, which holds a quota increase request, that's exported
+type quotaPreference struct {
+	Name        string            `json:"name"`
+	Service     string            `json:"service"`
+	QuotaID     string            `json:"quotaId"`
+	Dimensions  map[string]string `json:"dimensions"`
+	Reconciling bool              `json:"reconciling"`
+	UpdateTime  string            `json:"updateTime"`
+	QuotaConfig struct {
+		PreferredValue string `json:"preferredValue"`
+		GrantedValue   string `json:"grantedValue"`
+		StateDetail    string `json:"stateDetail"`
+	} `json:"quotaConfig"`
+}
+
+// quotaPreferencesQuotaSource exports the state of the quota preferences of each project, so quota increase requests can be followed
+// on the same dashboards as the quota they're for
+type quotaPreferencesQuotaSource struct {
+	clients clientProvider
+}
+
+func (s *quotaPreferencesQuotaSource) Name() string {
+	return "quota-preferences"
+}
+
+// Discover doesn't return locations, the preferences of all locations are listed at once
+func (s *quotaPreferencesQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *quotaPreferencesQuotaSource) Permissions() []string {
+	return []string{"cloudquotas.quotas.get"}
+}
+
+func (s *quotaPreferencesQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't leave a partial set
+	preferences := []quotaPreference{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("pageSize", "100")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			QuotaPreferences []quotaPreference `json:"quotaPreferences"`
+			NextPageToken    string            `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://cloudquotas.googleapis.com/v1/projects/%v/locations/global/quotaPreferences?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, response.QuotaPreferences...)
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing quota preferences failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	for _, preference := range preferences {
+		id := preference.Name[strings.LastIndex(preference.Name, "/")+1:]
+		dimensions := formatQuotaDimensions(preference.Dimensions)
+
+		requested, err := strconv.ParseInt(preference.QuotaConfig.PreferredValue, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Parsing preferred value %v of quota preference %v failed: %v", preference.QuotaConfig.PreferredValue, preference.Name, err)
+		}
+		setGauge(ctx, quotaPreferenceRequested, float64(requested), project, preference.Service, preference.QuotaID, dimensions, id)
+
+		// the granted value is only set once the preference has been reconciled
+		granted, grantedErr := strconv.ParseInt(preference.QuotaConfig.GrantedValue, 10, 64)
+		if grantedErr == nil {
+			setGauge(ctx, quotaPreferenceGranted, float64(granted), project, preference.Service, preference.QuotaID, dimensions, id)
+		}
+
+		setGauge(ctx, quotaPreferenceInfo, 1, project, preference.Service, preference.QuotaID, dimensions, id, quotaPreferenceState(preference.Reconciling, grantedErr == nil, granted, requested))
+
+		if updated, err := time.Parse(time.RFC3339Nano, preference.UpdateTime); err == nil {
+			setGauge(ctx, quotaPreferenceUpdated, float64(updated.Unix()), project, preference.Service, preference.QuotaID, dimensions, id)
+		}
+	}
+
+	// preferences aren't quota themselves, so they're only exported as metrics
+	return nil, nil
+}
+
+// quotaPreferenceState derives whether a quota increase request is pending, approved or denied; a request granted in part counts as denied
+func quotaPreferenceState(reconciling, hasGranted bool, granted, requested int64) string {
+
+	switch {
+	case reconciling || !hasGranted:
+		return "pending"
+	case granted >= requested:
+		return "approved"
+	default:
+		return "denied"
+	}
+}
+
+// formatQuotaDimensions formats dimensions like region and gpu family as a single label value, region=us-central1, sorted by key
+func formatQuotaDimensions(dimensions map[string]string) string {
+
+	pairs := make([]string, 0, len(dimensions))
+	for key, value := range dimensions {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
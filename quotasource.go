@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lastCycleQuotaCount is the number of quota retrieved in the last cycle, to size the next cycle's results up front instead of
+// growing them quota by quota
+var lastCycleQuotaCount int64
+
+// quotaSource retrieves quota for a single google cloud service; new services are added by registering a factory for them from an
+// init function in their own file, without changes to the fetch loop
+type quotaSource interface {
+	Name() string
+	// Discover returns the locations to collect quota for in the project, empty for services with only global quota
+	Discover(ctx context.Context, project string) ([]string, error)
+	// Collect retrieves quota for the project and discovered locations, updates the source's gauges and returns the retrieved quota;
+	// on error it returns the quota retrieved so far
+	Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error)
+}
+
+// projectsQuotaSource is implemented by sources that don't retrieve quota for the configured google cloud projects, like those for
+// other clouds; they return the accounts or subscriptions to retrieve quota for instead
+type projectsQuotaSource interface {
+	Projects(ctx context.Context) ([]string, error)
+}
+
+// quotaSourceFactory creates a quota source using the shared google cloud clients and configured regions
+type quotaSourceFactory func(clients clientProvider, regions []string) quotaSource
+
+var quotaSourceFactories = map[string]quotaSourceFactory{}
+
+// registerQuotaSource makes a quota source selectable with --collectors
+func registerQuotaSource(name string, factory quotaSourceFactory) {
+	quotaSourceFactories[name] = factory
+}
+
+// newQuotaSources creates the quota sources for the comma-separated list of collector names
+func newQuotaSources(collectors string, clients clientProvider, regions []string) ([]quotaSource, error) {
+
+	sources := []quotaSource{}
+	for _, name := range strings.Split(collectors, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		factory, ok := quotaSourceFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("Collector %v is unknown, available collectors are %v", name, strings.Join(availableQuotaSources(), ", "))
+		}
+		sources = append(sources, factory(clients, regions))
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No collectors are enabled")
+	}
+
+	return sources, nil
+}
+
+func availableQuotaSources() []string {
+
+	names := []string{}
+	for name := range quotaSourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// fetchQuota collects quota from all sources for all projects and hands the results to the streaming clients; it returns the number of failures
+func fetchQuota(ctx context.Context, clients clientProvider, sources []quotaSource, projects []string) int {
+
+	ctx, cycleSpan := startSpan(ctx, "fetch cycle", spanKindInternal, nil)
+	defer cycleSpan.finish(nil)
+
+	ctx, logger, cycleID := withCycleLogger(ctx)
+
+	// gauges are staged and applied at once at the end of the cycle, so scrapes never see a mix of two cycles; fetches inside a
+	// scrape emit their own consistent metrics instead
+	var snapshot *gaugeSnapshot
+	if !isScrapeTimeFetch(ctx) {
+		ctx, snapshot = withGaugeSnapshot(ctx)
+	}
+	cycleSpan.setAttribute("cycle_id", cycleID)
+
+	// report panics with their stack before crashing
+	defer reportPanic(ctx, cycleID)
+
+	// bound the whole cycle, so a slow project can't hold up the next one
+	if *cycleDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *cycleDeadline)
+		defer cancel()
+	}
+
+	start := time.Now()
+	logger.Info().Int("projects", len(projects)).Int("collectors", len(sources)).Msg("Starting fetch cycle")
+
+	cycleUpdates := make([]quotaUpdate, 0, atomic.LoadInt64(&lastCycleQuotaCount))
+	failures := 0
+
+	// the first error per attempted project, nil if all collectors succeeded
+	outcomes := map[string]error{}
+
+	// the first error and whether any call succeeded per project for sources only calling compute, which decide whether a project is dead
+	computeOutcomes := map[string]error{}
+	computeSucceeded := map[string]bool{}
+
+	// the projects listed by any collector in this cycle, nil once a collector couldn't list its projects so none are dropped from
+	// the published quota
+	listed := map[string]bool{}
+
+	// whether dead projects are skipped is decided once per cycle, so a recheck runs all collectors
+	skipDead := map[string]bool{}
+
+	// guards the results above, which are updated by the concurrent workers
+	var mutex sync.Mutex
+
+	for _, source := range sources {
+
+		sourceProjects := projects
+		if s, ok := source.(projectsQuotaSource); ok {
+			var err error
+			sourceProjects, err = s.Projects(ctx)
+			if err != nil {
+				reportError(ctx, err, "", map[string]string{"collector": source.Name(), "cycleID": cycleID})
+				scrapeErrorsTotal.WithLabelValues("", source.Name()).Inc()
+				logger.Error().Err(err).Str("collector", source.Name()).Msgf("Retrieving %v projects failed, keeping their previous values until the next cycle", source.Name())
+				failures++
+				snapshot.keepCollector(source.Name())
+				listed = nil
+				continue
+			}
+			sourceProjects = shardProjects(sourceProjects)
+		}
+		shardProjectsCount.WithLabelValues(fmt.Sprint(*shard), fmt.Sprint(*totalShards), source.Name()).Set(float64(len(sourceProjects)))
+		snapshot.listProjects(source.Name(), sourceProjects)
+		if listed != nil {
+			for _, project := range sourceProjects {
+				listed[project] = true
+			}
+		}
+
+		// the collectors run one after another, so each gets its share of the stagger window
+		pacer := newStaggerPacer(staggerWindowFromContext(ctx)/time.Duration(len(sources)), len(sourceProjects))
+
+		// the lifecycle state only applies to google cloud projects, not to the accounts or clusters of other sources
+		_, ownProjects := source.(projectsQuotaSource)
+		checkLifecycle := *skipInactiveProjects && !ownProjects
+
+		source := source
+		forEachConcurrently(sourceProjects, *maxConcurrency, func(project string) {
+
+			defer reportPanic(ctx, cycleID)
+
+			// a cancelled wait means the cycle deadline passed, which is handled right below
+			_ = pacer.wait(ctx)
+
+			if ctx.Err() != nil {
+				mutex.Lock()
+				if outcomes[project] == nil {
+					outcomes[project] = ctx.Err()
+				}
+				mutex.Unlock()
+				if ctx.Err() == context.Canceled {
+					logger.Debug().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project, shutting down")
+					return
+				}
+				logger.Warn().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project, the fetch cycle deadline has been exceeded")
+				return
+			}
+
+			if isBlockedByVPCServiceControls(project) {
+				mutex.Lock()
+				outcomes[project] = errBlockedByVPCServiceControls
+				mutex.Unlock()
+				logger.Debug().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project blocked by a VPC Service Controls perimeter")
+				return
+			}
+
+			if checkLifecycle {
+				if state := inactiveProjectState(ctx, clients, project); state != "" {
+					mutex.Lock()
+					outcomes[project] = errProjectInactive
+					mutex.Unlock()
+					logger.Debug().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msgf("Skipping project in lifecycle state %v", state)
+					return
+				}
+			}
+
+			usesCompute := usesComputeAPI(source)
+			if usesCompute && isComputeAPIDisabled(project) {
+				mutex.Lock()
+				outcomes[project] = errComputeAPIDisabled
+				mutex.Unlock()
+				logger.Debug().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project without the compute api enabled")
+				return
+			}
+
+			mutex.Lock()
+			if _, ok := skipDead[project]; !ok {
+				skipDead[project] = isDeadProjectSkipped(project)
+			}
+			skip := skipDead[project]
+			if skip {
+				outcomes[project] = errProjectDead
+			}
+			mutex.Unlock()
+			if skip {
+				logger.Debug().Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project considered deleted or unreachable")
+				return
+			}
+
+			projectStart := time.Now()
+			updates, err := collectQuotaSource(withSeriesOwner(ctx, source.Name(), project), source, project)
+			fetchDurationSeconds.WithLabelValues(project, source.Name()).Observe(time.Since(projectStart).Seconds())
+
+			// a project that never enabled the compute api is excluded rather than failing every cycle
+			if isComputeAPIDisabledError(err) {
+				handleComputeAPIDisabled(project)
+				mutex.Lock()
+				if outcomes[project] == nil {
+					outcomes[project] = errComputeAPIDisabled
+				}
+				mutex.Unlock()
+				logger.Debug().Err(err).Str("collector", source.Name()).Str("project", project).Str("outcome", "skipped").Msg("Skipping project without the compute api enabled")
+				return
+			}
+
+			mutex.Lock()
+			cycleUpdates = append(cycleUpdates, updates...)
+			if outcomes[project] == nil {
+				outcomes[project] = err
+			}
+			if callsOnlyComputeAPI(source) {
+				if computeOutcomes[project] == nil {
+					computeOutcomes[project] = err
+				}
+				if err == nil {
+					computeSucceeded[project] = true
+				}
+			}
+			if err != nil {
+				failures++
+			} else {
+				snapshot.markCollected(source.Name(), project)
+			}
+			mutex.Unlock()
+
+			if err == nil && usesCompute {
+				clearComputeAPIDisabled(project)
+			}
+
+			if err != nil {
+				// calls aborted by a shutdown aren't failures worth reporting
+				if ctx.Err() == context.Canceled {
+					logger.Info().Str("collector", source.Name()).Str("project", project).Dur("duration", time.Since(projectStart)).Str("outcome", "cancelled").Msgf("Retrieving %v quota for project %v was cancelled, shutting down", source.Name(), project)
+					return
+				}
+				reportError(ctx, err, "", map[string]string{"collector": source.Name(), "project": project, "cycleID": cycleID})
+				scrapeErrorsTotal.WithLabelValues(project, source.Name()).Inc()
+				clients.handleError(ctx, project, err)
+				if isVPCServiceControlsError(err) {
+					handleVPCServiceControlsViolation(project, err)
+					return
+				}
+				if isCredentialsUnavailableError(err) {
+					logger.Warn().Err(err).Str("collector", source.Name()).Str("project", project).Str("outcome", "error").Msgf("Skipping %v quota for project %v until its credentials are available", source.Name(), project)
+					return
+				}
+				if errorReason(err) == "timeout" {
+					logger.Warn().Err(err).Str("collector", source.Name()).Str("project", project).Dur("duration", time.Since(projectStart)).Str("outcome", "timeout").Msgf("Retrieving %v quota for project %v timed out", source.Name(), project)
+					return
+				}
+				if isDeadProjectError(err) && callsOnlyComputeAPI(source) {
+					logger.Warn().Err(err).Str("collector", source.Name()).Str("project", project).Dur("duration", time.Since(projectStart)).Str("outcome", "error").Msgf("Retrieving %v quota for project %v failed, the project may have been deleted or access revoked", source.Name(), project)
+					return
+				}
+				// transient errors have already been retried with backoff by the transport, so give up until the next cycle and keep
+				// serving the previous values
+				logger.Error().Err(err).Str("collector", source.Name()).Str("project", project).Dur("duration", time.Since(projectStart)).Str("outcome", "error").Msgf("Retrieving %v quota for project %v failed, keeping its previous values until the next cycle", source.Name(), project)
+				return
+			}
+
+			if logVerbosityIncludes(logVerbosityProject) {
+				logger.Info().Str("collector", source.Name()).Str("project", project).Dur("duration", time.Since(projectStart)).Int("quota", len(updates)).Str("outcome", "success").Msg("Retrieved quota")
+			}
+		})
+	}
+
+	if snapshot != nil {
+		snapshot.apply()
+	}
+	atomic.StoreInt64(&lastCycleQuotaCount, int64(len(cycleUpdates)))
+	succeeded := map[string]bool{}
+	for project, err := range outcomes {
+		if err == nil {
+			succeeded[project] = true
+		}
+	}
+	quotaUpdates.publish(cycleUpdates, listed, succeeded)
+	updateProjectStatus(outcomes)
+	updateDeadProjects(computeOutcomes, computeSucceeded)
+	recordCycleCompleted()
+
+	logger.Info().Dur("duration", time.Since(start)).Int("projects", len(projects)).Int("quota", len(cycleUpdates)).Int("failures", failures).Msg("Finished fetch cycle")
+
+	return failures
+}
+
+// reportPanic reports a panic in a fetch cycle with its stack before crashing; it has to be deferred in every goroutine of the cycle
+func reportPanic(ctx context.Context, cycleID string) {
+	if r := recover(); r != nil {
+		reportError(ctx, fmt.Errorf("Panic in fetch cycle: %v", r), string(debug.Stack()), map[string]string{"cycleID": cycleID})
+		panic(r)
+	}
+}
+
+func collectQuotaSource(ctx context.Context, source quotaSource, project string) (updates []quotaUpdate, err error) {
+
+	ctx, collectSpan := startSpan(ctx, "collect "+source.Name(), spanKindInternal, map[string]string{"collector": source.Name(), "project": project})
+	defer func() { collectSpan.finish(err) }()
+
+	discoverCtx, discoverSpan := startSpan(ctx, "discover "+source.Name(), spanKindInternal, map[string]string{"collector": source.Name(), "project": project})
+	locations, err := source.Discover(discoverCtx, project)
+	discoverSpan.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	collectSpan.setAttribute("locations", strings.Join(locations, ","))
+
+	return source.Collect(ctx, project, locations)
+}
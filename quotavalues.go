@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	compute "google.golang.org/api/compute/v1"
+)
+
+var (
+	// create counter for invalid values returned by apis, so a bad value shows up here instead of propagating into alerts
+	invalidQuotaValuesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_invalid_values_total",
+		Help: "The number of NaN, infinite or negative quota limits and usages returned by apis, which are skipped or clamped to 0 instead of exported.",
+	}, []string{"provider", "project", "field", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(invalidQuotaValuesTotal)
+}
+
+// sanitizeQuotaValue returns whether a limit or usage can be exported; NaN and infinite values are skipped and negative values are clamped
+// to 0, except for a limit of -1, which marks unlimited quota
+func sanitizeQuotaValue(provider, project, metric, field string, value float64) (float64, bool) {
+
+	reason := ""
+	switch {
+	case math.IsNaN(value):
+		reason = "nan"
+	case math.IsInf(value, 0):
+		reason = "infinite"
+	case value < 0 && !(field == "limit" && value == -1):
+		reason = "negative"
+	default:
+		return value, true
+	}
+
+	invalidQuotaValuesTotal.WithLabelValues(provider, project, field, reason).Inc()
+	log.Warn().Msgf("Api returned %v %v %v for metric %v in project %v", reason, field, value, metric, project)
+
+	if reason == "negative" {
+		return 0, true
+	}
+
+	return value, false
+}
+
+// sanitizeQuotas returns copies of the quotas with valid values only, dropping quotas whose limit or usage can't be exported
+func sanitizeQuotas(quotas []*compute.Quota, project string) []*compute.Quota {
+
+	// the copies share a single allocation, since this runs for every project and region in every cycle
+	values := make([]compute.Quota, 0, len(quotas))
+	sanitized := make([]*compute.Quota, 0, len(quotas))
+	for _, quota := range quotas {
+		if quota == nil {
+			continue
+		}
+
+		limit, limitOK := sanitizeQuotaValue("gcloud", project, quota.Metric, "limit", quota.Limit)
+		usage, usageOK := sanitizeQuotaValue("gcloud", project, quota.Metric, "usage", quota.Usage)
+		if !limitOK || !usageOK {
+			continue
+		}
+
+		values = append(values, *quota)
+		sanitizedQuota := &values[len(values)-1]
+		sanitizedQuota.Limit = limit
+		sanitizedQuota.Usage = usage
+		sanitized = append(sanitized, sanitizedQuota)
+	}
+
+	return sanitized
+}
+
+// sanitizeQuotaUpdate validates the limit and usage of another provider's quota before it's exported
+func sanitizeQuotaUpdate(update quotaUpdate) (quotaUpdate, bool) {
+
+	var limitOK, usageOK bool
+	update.Limit, limitOK = sanitizeQuotaValue(update.Provider, update.Project, update.Metric, "limit", update.Limit)
+	update.Usage, usageOK = sanitizeQuotaValue(update.Provider, update.Project, update.Metric, "usage", update.Usage)
+
+	return update, limitOK && usageOK
+}
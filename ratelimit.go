@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst events and refills at rate events per second
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastSeen time.Time
+	mutex    sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		lastSeen: time.Now(),
+	}
+}
+
+// allow takes a token if one is available right now
+func (b *tokenBucket) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.lastSeen).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// wait takes a token, blocking until one is available or the context is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mutex.Lock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.lastSeen).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastSeen = now
+
+	// reserve the token up front, so concurrent waiters queue up behind each other
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		// hand back the reserved token
+		b.mutex.Lock()
+		b.tokens++
+		b.mutex.Unlock()
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+func (b *tokenBucket) idleSince() time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.lastSeen
+}
+
+// scrapeLimiter limits the request rate per client ip and the number of concurrently served requests
+type scrapeLimiter struct {
+	rate         float64
+	burst        int
+	buckets      map[string]*tokenBucket
+	bucketsMutex sync.Mutex
+	concurrency  chan struct{}
+}
+
+// newScrapeLimiter creates a limiter; a rate of 0 disables per client rate limiting, maxConcurrent of 0 disables the concurrency cap
+func newScrapeLimiter(rate float64, burst, maxConcurrent int) *scrapeLimiter {
+
+	l := &scrapeLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: map[string]*tokenBucket{},
+	}
+
+	if maxConcurrent > 0 {
+		l.concurrency = make(chan struct{}, maxConcurrent)
+	}
+
+	if rate > 0 {
+		go l.evictIdleBuckets(10 * time.Minute)
+	}
+
+	return l
+}
+
+func (l *scrapeLimiter) bucket(client string) *tokenBucket {
+	l.bucketsMutex.Lock()
+	defer l.bucketsMutex.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[client] = b
+	}
+
+	return b
+}
+
+// evictIdleBuckets removes buckets of clients that haven't been seen for a while so the map doesn't grow forever
+func (l *scrapeLimiter) evictIdleBuckets(idleTimeout time.Duration) {
+	for range time.Tick(idleTimeout) {
+		l.bucketsMutex.Lock()
+		for client, b := range l.buckets {
+			if time.Since(b.idleSince()) > idleTimeout {
+				delete(l.buckets, client)
+			}
+		}
+		l.bucketsMutex.Unlock()
+	}
+}
+
+// middleware rejects requests over the client's rate with a 429 and requests over the concurrency cap with a 503
+func (l *scrapeLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if l.rate > 0 {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if !l.bucket(client).allow() {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if l.concurrency != nil {
+			select {
+			case l.concurrency <- struct{}{}:
+				defer func() { <-l.concurrency }()
+			default:
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
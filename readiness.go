@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// readinessProjects are the projects that need a successful fetch before /ready succeeds; nil until the exporter started fetching
+	readinessProjects      []string
+	readinessStarted       bool
+	readinessProjectsMutex sync.Mutex
+
+	// shuttingDown is set once a shutdown signal is received, so kubernetes stops routing scrapes to the pod while it finishes
+	shuttingDown int32
+)
+
+// requireProjectsForReadiness makes /ready succeed once each of the projects has been fetched successfully at least once
+func requireProjectsForReadiness(projects []string) {
+
+	readinessProjectsMutex.Lock()
+	defer readinessProjectsMutex.Unlock()
+
+	readinessProjects = projects
+	readinessStarted = true
+}
+
+// markShuttingDown makes /ready fail from now on; it's passed to foundation's graceful shutdown handling
+func markShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+// readinessExcludedReasons are the reasons projects are skipped for until they change outside the exporter, so they don't block readiness
+var readinessExcludedReasons = map[string]bool{
+	"api_disabled":         true,
+	"inactive":             true,
+	"dead":                 true,
+	"vpc_service_controls": true,
+}
+
+// checkReadiness returns an error while the exporter would serve incomplete metrics
+func checkReadiness() error {
+
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		return fmt.Errorf("Shutting down")
+	}
+
+	err := checkLeadership()
+	if err != nil {
+		return err
+	}
+
+	readinessProjectsMutex.Lock()
+	started := readinessStarted
+	projects := readinessProjects
+	readinessProjectsMutex.Unlock()
+
+	if !started {
+		return fmt.Errorf("Not fetching quota yet")
+	}
+
+	health := projectHealthDetails()
+	pending := []string{}
+	for _, project := range projects {
+		// projects excluded from fetching would keep the exporter from ever becoming ready
+		if health[project].LastSuccess == nil && !readinessExcludedReasons[health[project].Reason] && !isProjectDead(project) {
+			pending = append(pending, project)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// keep the message short for a large number of projects
+	listed := pending
+	if len(listed) > 10 {
+		listed = listed[:10]
+	}
+
+	return fmt.Errorf("No successful fetch yet for %v of %v projects: %v", len(pending), len(projects), strings.Join(listed, ", "))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordedResponse is an api response as stored on disk by the record transport; request headers aren't stored so no credentials
+// end up in the recordings
+type recordedResponse struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// recordingFile returns the file a response for the request is stored in; the name is derived from method and url so a replay
+// finds the response for the same call
+func recordingFile(dir string, req *http.Request) string {
+
+	hash := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	name := strings.Trim(strings.Replace(req.URL.Path, "/", "_", -1), "_")
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%v_%v_%v.json", strings.ToLower(req.Method), name, hex.EncodeToString(hash[:8])))
+}
+
+// recordTransport writes every response passing through it to the recording directory
+type recordTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// a 304 answers a conditional request with an empty body; recording it would overwrite the full response for the same url,
+	// which a replay without cached etags couldn't use
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recording, err := json.MarshalIndent(recordedResponse{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(recordingFile(t.dir, req), recording, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Recording response for %v %v failed: %v", req.Method, req.URL, err)
+	}
+
+	return resp, nil
+}
+
+// replayTransport serves the responses from the recording directory instead of calling the apis
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	data, err := ioutil.ReadFile(recordingFile(t.dir, req))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No recorded response for %v %v in %v", req.Method, req.URL, t.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recording recordedResponse
+	err = json.Unmarshal(data, &recording)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing recorded response for %v %v failed: %v", req.Method, req.URL, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", recording.StatusCode, http.StatusText(recording.StatusCode)),
+		StatusCode:    recording.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recording.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(recording.Body)),
+		ContentLength: int64(len(recording.Body)),
+		Request:       req,
+	}, nil
+}
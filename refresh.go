@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// refreshRequests wakes the fetch loop for an immediate cycle; it holds a single request, so any number of requests while
+	// a cycle is running or pending collapse into one extra cycle
+	refreshRequests = make(chan struct{}, 1)
+
+	// refreshEnabled is only set while fetching in a loop, since in scrape time mode there's no loop to wake
+	refreshEnabled int32
+)
+
+// enableRefresh lets the admin endpoint and SIGUSR1 trigger an immediate fetch cycle
+func enableRefresh() {
+
+	atomic.StoreInt32(&refreshEnabled, 1)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			requestRefresh("SIGUSR1")
+		}
+	}()
+}
+
+// requestRefresh queues an immediate fetch cycle; it returns false if one is already queued
+func requestRefresh(trigger string) bool {
+	select {
+	case refreshRequests <- struct{}{}:
+		log.Info().Str("trigger", trigger).Msg("Queued immediate fetch cycle")
+		return true
+	default:
+		log.Debug().Str("trigger", trigger).Msg("Immediate fetch cycle is already queued")
+		return false
+	}
+}
+
+// sleepUntilRefresh sleeps for the duration, until a refresh is requested or until the context is done; it returns whether a refresh
+// was requested
+func sleepUntilRefresh(ctx context.Context, d time.Duration) bool {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return false
+	case <-refreshRequests:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *adminServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if atomic.LoadInt32(&refreshEnabled) == 0 {
+		http.Error(w, "Quota is fetched when metrics are scraped, there's no fetch cycle to trigger", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, map[string]interface{}{
+		"queued": requestRefresh("admin endpoint"),
+	})
+}
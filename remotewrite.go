@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// remoteWriteSink pushes metrics to a prometheus remote write endpoint like grafana cloud, mimir or cortex
+type remoteWriteSink struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	client      *http.Client
+}
+
+func newRemoteWriteSink(url, username, password, bearerToken string) *remoteWriteSink {
+	return &remoteWriteSink{
+		url:         url,
+		username:    username,
+		password:    password,
+		bearerToken: bearerToken,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *remoteWriteSink) Name() string {
+	return "remote write endpoint " + s.url
+}
+
+func (s *remoteWriteSink) Push(ctx context.Context, families []*dto.MetricFamily) error {
+
+	body := snappy.Encode(nil, encodeWriteRequest(families, time.Now()))
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", buildUserAgent(*userAgent, *deploymentName))
+
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	} else if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Remote write endpoint returned status %v: %v", resp.StatusCode, string(message))
+	}
+
+	return nil
+}
+
+// encodeWriteRequest encodes the metrics as remote write WriteRequest protobuf message, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+func encodeWriteRequest(families []*dto.MetricFamily, now time.Time) []byte {
+
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+
+	request := proto.NewBuffer(nil)
+	for _, s := range flattenFamilies(families) {
+
+		timeSeries := proto.NewBuffer(nil)
+
+		// labels have to be sorted by name
+		names := make([]string, 0, len(s.labels))
+		for name := range s.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			label := proto.NewBuffer(nil)
+			label.EncodeVarint(1<<3 | proto.WireBytes)
+			label.EncodeStringBytes(name)
+			label.EncodeVarint(2<<3 | proto.WireBytes)
+			label.EncodeStringBytes(s.labels[name])
+
+			// TimeSeries.labels = 1
+			timeSeries.EncodeVarint(1<<3 | proto.WireBytes)
+			timeSeries.EncodeRawBytes(label.Bytes())
+		}
+
+		sample := proto.NewBuffer(nil)
+		sample.EncodeVarint(1<<3 | proto.WireFixed64)
+		sample.EncodeFixed64(math.Float64bits(s.value))
+		sample.EncodeVarint(2<<3 | proto.WireVarint)
+		sample.EncodeVarint(uint64(timestamp))
+
+		// TimeSeries.samples = 2
+		timeSeries.EncodeVarint(2<<3 | proto.WireBytes)
+		timeSeries.EncodeRawBytes(sample.Bytes())
+
+		// WriteRequest.timeseries = 1
+		request.EncodeVarint(1<<3 | proto.WireBytes)
+		request.EncodeRawBytes(timeSeries.Bytes())
+	}
+
+	return request.Bytes()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// create counter for retried api calls, to tell google cloud flakiness apart from misconfiguration
+	apiRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_api_retries_total",
+		Help: "The number of retried google cloud api calls, by service and the reason of the failed attempt.",
+	}, []string{"service", "reason"})
+
+	// create counter for calls that still failed after using up all retries of their policy
+	apiRetryBudgetExhaustedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_api_retry_budget_exhausted_total",
+		Help: "The number of google cloud api calls that still failed after all retries of their policy were used.",
+	}, []string{"service"})
+
+	// create gauge for the backoff a project's calls are currently waiting for
+	apiBackoffSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_api_backoff_seconds",
+		Help: "The backoff the current retry of a google cloud api call for the project is waiting for, 0 if no call is being retried.",
+	}, []string{"project", "service"})
+)
+
+func init() {
+	prometheus.MustRegister(apiRetriesTotal)
+	prometheus.MustRegister(apiRetryBudgetExhaustedTotal)
+	prometheus.MustRegister(apiBackoffSeconds)
+}
+
+// apiPolicy defines how calls to a single google cloud service are timed out and retried
+type apiPolicy struct {
+	Timeout    time.Duration
+	Retries    int
+	MaxBackoff time.Duration
+}
+
+// apiPolicies holds the default policy and the per service overrides
+type apiPolicies struct {
+	defaultPolicy apiPolicy
+	services      map[string]apiPolicy
+}
+
+// forService returns the policy for a service like compute or serviceusage
+func (p apiPolicies) forService(service string) apiPolicy {
+	if policy, ok := p.services[service]; ok {
+		return policy
+	}
+	return p.defaultPolicy
+}
+
+// parseAPIPolicies parses overrides in the form compute=timeout:10s,retries:5,max-backoff:1m on top of the default policy
+func parseAPIPolicies(defaultPolicy apiPolicy, overrides []string) (policies apiPolicies, err error) {
+
+	policies = apiPolicies{
+		defaultPolicy: defaultPolicy,
+		services:      map[string]apiPolicy{},
+	}
+
+	for _, override := range overrides {
+		override = strings.TrimSpace(override)
+		if override == "" {
+			continue
+		}
+
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return policies, fmt.Errorf("Api policy %q is not in the form service=key:value,key:value", override)
+		}
+
+		service := parts[0]
+		policy := defaultPolicy
+
+		for _, setting := range strings.Split(parts[1], ",") {
+			keyValue := strings.SplitN(setting, ":", 2)
+			if len(keyValue) != 2 {
+				return policies, fmt.Errorf("Setting %q of api policy for service %v is not in the form key:value", setting, service)
+			}
+
+			switch keyValue[0] {
+			case "timeout":
+				policy.Timeout, err = time.ParseDuration(keyValue[1])
+			case "retries":
+				policy.Retries, err = strconv.Atoi(keyValue[1])
+			case "max-backoff":
+				policy.MaxBackoff, err = time.ParseDuration(keyValue[1])
+			default:
+				err = fmt.Errorf("Unknown setting %q, use timeout, retries or max-backoff", keyValue[0])
+			}
+			if err != nil {
+				return policies, fmt.Errorf("Parsing api policy for service %v failed: %v", service, err)
+			}
+		}
+
+		policies.services[service] = policy
+	}
+
+	return policies, nil
+}
+
+// serviceFromRequest derives the google cloud service name from hosts like compute.googleapis.com or paths like www.googleapis.com/compute/v1
+func serviceFromRequest(req *http.Request) string {
+
+	host := req.URL.Hostname()
+	if host == "www.googleapis.com" {
+		segments := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+		return segments[0]
+	}
+
+	return strings.TrimSuffix(host, ".googleapis.com")
+}
+
+// retryTransport applies a per attempt timeout and retries failed calls with exponential backoff according to the service's policy
+type retryTransport struct {
+	base     http.RoundTripper
+	policies apiPolicies
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	service := serviceFromRequest(req)
+	policy := t.policies.forService(service)
+	project := projectFromAPIPath(req.URL.Path)
+
+	for attempt := 0; ; attempt++ {
+
+		// hold off while the project is being rate limited
+		if project != "" {
+			if err := projectThrottles.wait(req.Context(), project); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.roundTripWithTimeout(req, policy.Timeout)
+		if err != nil && req.Context().Err() == nil && errorReason(err) == "timeout" {
+			loggerFromContext(req.Context()).Warn().Str("service", service).Str("project", project).Str("location", locationFromAPIPath(req.URL.Path)).Msgf("Api call %v %v timed out after %v", req.Method, req.URL.Path, policy.Timeout)
+		}
+
+		retryable := isRetryable(req, resp, err)
+
+		// back off exponentially per project when rate limited, at least as long as the api asks for
+		var throttleDelay time.Duration
+		if err == nil && isRateLimited(resp) {
+			throttledTotal.WithLabelValues(service, project).Inc()
+			throttleDelay = backoffDelay(attempt, policy.MaxBackoff)
+			if after := retryAfter(resp); after > throttleDelay {
+				throttleDelay = after
+			}
+			if project != "" {
+				projectThrottles.backOff(project, throttleDelay)
+			}
+			retryable = req.Context().Err() == nil
+		}
+		// a request whose body can't be read again can't be retried; its failed response is handed back untouched
+		if req.Body != nil && req.GetBody == nil {
+			retryable = false
+		}
+		if attempt >= policy.Retries || !retryable {
+			if attempt > 0 {
+				apiBackoffSeconds.WithLabelValues(project, service).Set(0)
+			}
+			if retryable && policy.Retries > 0 {
+				apiRetryBudgetExhaustedTotal.WithLabelValues(service).Inc()
+			}
+			return resp, err
+		}
+		apiRetriesTotal.WithLabelValues(service, retryReason(resp, err)).Inc()
+
+		// drain and discard the failed response before retrying
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if req.Body != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delay := backoffDelay(attempt, policy.MaxBackoff)
+		if throttleDelay > delay {
+			delay = throttleDelay
+		}
+		apiBackoffSeconds.WithLabelValues(project, service).Set(delay.Seconds())
+		log.Debug().Msgf("Retrying %v call %v %v in %v (attempt %v of %v)", service, req.Method, req.URL.Path, delay, attempt+1, policy.Retries)
+
+		select {
+		case <-req.Context().Done():
+			apiBackoffSeconds.WithLabelValues(project, service).Set(0)
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (t *retryTransport) roundTripWithTimeout(req *http.Request, timeout time.Duration) (*http.Response, error) {
+
+	if timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+
+	// keep the context alive until the caller is done reading the body
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelOnCloseBody releases the per attempt context once the response body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isRetryable checks whether a call failed in a way that might succeed when tried again
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+
+	if req.Context().Err() != nil {
+		// the caller gave up, retrying is pointless
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryReason labels a failed attempt with its status code, or error for transport failures like timeouts
+func retryReason(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// backoffDelay doubles a 500ms base delay for each attempt with +-25% jitter, capped at maxBackoff
+func backoffDelay(attempt int, maxBackoff time.Duration) time.Duration {
+
+	delay := 500 * time.Millisecond << uint(attempt)
+	if maxBackoff > 0 && (delay > maxBackoff || delay <= 0) {
+		delay = maxBackoff
+	}
+
+	milliseconds := int(delay / time.Millisecond)
+	if milliseconds < 4 {
+		// too small to apply jitter to
+		return delay
+	}
+
+	return time.Duration(foundation.ApplyJitter(milliseconds)) * time.Millisecond
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransportRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		request      func() *http.Request
+		attempts     int
+		expectedBody string
+	}{
+		{
+			name: "RetriesRequestWithoutBody",
+			request: func() *http.Request {
+				req, _ := http.NewRequest("GET", "https://compute.googleapis.com/compute/v1/projects/retry-project", nil)
+				return req
+			},
+			attempts:     3,
+			expectedBody: "ok",
+		},
+		{
+			name: "RetriesRequestWithReplayableBody",
+			request: func() *http.Request {
+				req, _ := http.NewRequest("POST", "https://compute.googleapis.com/compute/v1/projects/retry-project", bytes.NewReader([]byte("request")))
+				return req
+			},
+			attempts:     3,
+			expectedBody: "ok",
+		},
+		{
+			name: "ReturnsFailedResponseUntouchedIfBodyCantBeReplayed",
+			request: func() *http.Request {
+				req, _ := http.NewRequest("POST", "https://compute.googleapis.com/compute/v1/projects/retry-project", ioutil.NopCloser(strings.NewReader("request")))
+				return req
+			},
+			attempts:     1,
+			expectedBody: "backend error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// fail the first two attempts, checking every attempt sends the full request body
+			attempts := 0
+			base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				if req.Body != nil {
+					body, _ := ioutil.ReadAll(req.Body)
+					if string(body) != "request" {
+						t.Errorf("Attempt %v sent body %q", attempts, body)
+					}
+				}
+				if attempts <= 2 {
+					return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader("backend error")), Header: http.Header{}, Request: req}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("ok")), Header: http.Header{}, Request: req}, nil
+			})
+			transport := &retryTransport{base: base, policies: apiPolicies{defaultPolicy: apiPolicy{Retries: 3, MaxBackoff: time.Millisecond}}}
+
+			resp, err := transport.RoundTrip(tt.request())
+			if err != nil {
+				t.Fatalf("Round trip failed: %v", err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if err != nil || string(body) != tt.expectedBody {
+				t.Errorf("Response body is %q (%v), expected %q", body, err, tt.expectedBody)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("Made %v attempts, expected %v", attempts, tt.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// generateRecordingRules creates a prometheus rule file with consistently named recording rules for utilization, headroom and
+// aggregates across projects, for the scopes and metrics discovered in the quota updates
+func generateRecordingRules(updates []quotaUpdate) string {
+
+	hasGlobal := false
+	hasRegional := false
+	metrics := map[string]bool{}
+	for _, update := range updates {
+		// quota of other providers isn't exported with the estafette_gcloud metrics the rules are recorded from
+		if update.Provider != "" {
+			continue
+		}
+		if update.Region == "" {
+			hasGlobal = true
+		} else {
+			hasRegional = true
+		}
+		metrics[update.Metric] = true
+	}
+
+	sortedMetrics := []string{}
+	for metric := range metrics {
+		sortedMetrics = append(sortedMetrics, metric)
+	}
+	sort.Strings(sortedMetrics)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# generated by estafette-gcloud-quota-exporter %v\n", version)
+	fmt.Fprintf(&b, "# discovered metrics: %v\n", strings.Join(sortedMetrics, ", "))
+	b.WriteString("groups:\n")
+
+	writeRule := func(record, expr string) {
+		fmt.Fprintf(&b, "  - record: %v\n    expr: %v\n", record, expr)
+	}
+
+	if hasGlobal {
+		b.WriteString("- name: estafette-gcloud-quota-global\n  rules:\n")
+		// a limit of -1 means unlimited, 0 means the resource can't be used at all
+		writeRule("estafette_gcloud_quota:global_utilization:ratio", "estafette_gcloud_global_quota_usage / (estafette_gcloud_global_quota_limit > 0)")
+		writeRule("estafette_gcloud_quota:global_headroom", "(estafette_gcloud_global_quota_limit >= 0) - estafette_gcloud_global_quota_usage")
+		writeRule("estafette_gcloud_quota:global_usage:sum_by_metric", "sum by (metric) (estafette_gcloud_global_quota_usage)")
+		writeRule("estafette_gcloud_quota:global_limit:sum_by_metric", "sum by (metric) (estafette_gcloud_global_quota_limit >= 0)")
+		writeRule("estafette_gcloud_quota:global_utilization:max_by_metric", "max by (metric) (estafette_gcloud_quota:global_utilization:ratio)")
+	}
+
+	if hasRegional {
+		b.WriteString("- name: estafette-gcloud-quota-regional\n  rules:\n")
+		writeRule("estafette_gcloud_quota:regional_utilization:ratio", "estafette_gcloud_regional_quota_usage / (estafette_gcloud_regional_quota_limit > 0)")
+		writeRule("estafette_gcloud_quota:regional_headroom", "(estafette_gcloud_regional_quota_limit >= 0) - estafette_gcloud_regional_quota_usage")
+		writeRule("estafette_gcloud_quota:regional_usage:sum_by_region_metric", "sum by (region, metric) (estafette_gcloud_regional_quota_usage)")
+		writeRule("estafette_gcloud_quota:regional_limit:sum_by_region_metric", "sum by (region, metric) (estafette_gcloud_regional_quota_limit >= 0)")
+		writeRule("estafette_gcloud_quota:regional_utilization:max_by_region_metric", "max by (region, metric) (estafette_gcloud_quota:regional_utilization:ratio)")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type scrapeTimeFetchKey struct{}
+
+// scrapeTimeCollector fetches quota when metrics are scraped and emits the limits and usage as const metrics, so freshness is
+// controlled by prometheus and no values are served from a fetch loop that stopped; fetches are serialized and reused for scrapes
+// within the minimum interval, so frequent or concurrent scrapes don't multiply the api calls
+type scrapeTimeCollector struct {
+	ctx         context.Context
+	clients     clientProvider
+	sources     []quotaSource
+	projects    []string
+	minInterval time.Duration
+
+	lastFetch time.Time
+	mutex     sync.Mutex
+
+	globalLimit, globalUsage, regionalLimit, regionalUsage, providerLimit, providerUsage *prometheus.Desc
+}
+
+// newScrapeTimeCollector takes over the quota gauges, which have to be unregistered since the collector emits metrics of the same name
+func newScrapeTimeCollector(ctx context.Context, clients clientProvider, sources []quotaSource, projects []string, minInterval time.Duration) *scrapeTimeCollector {
+
+	for _, vec := range []*prometheus.GaugeVec{globalQuotaLimit, globalQuotaUsage, regionalQuotaLimit, regionalQuotaUsage, providerQuotaLimit, providerQuotaUsage} {
+		prometheus.Unregister(vec)
+	}
+
+	return &scrapeTimeCollector{
+		ctx:           context.WithValue(ctx, scrapeTimeFetchKey{}, true),
+		clients:       clients,
+		sources:       sources,
+		projects:      projects,
+		minInterval:   minInterval,
+		globalLimit:   describeGaugeVec(globalQuotaLimit),
+		globalUsage:   describeGaugeVec(globalQuotaUsage),
+		regionalLimit: describeGaugeVec(regionalQuotaLimit),
+		regionalUsage: describeGaugeVec(regionalQuotaUsage),
+		providerLimit: describeGaugeVec(providerQuotaLimit),
+		providerUsage: describeGaugeVec(providerQuotaUsage),
+	}
+}
+
+func (c *scrapeTimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.globalLimit
+	ch <- c.globalUsage
+	ch <- c.regionalLimit
+	ch <- c.regionalUsage
+	ch <- c.providerLimit
+	ch <- c.providerUsage
+}
+
+func (c *scrapeTimeCollector) Collect(ch chan<- prometheus.Metric) {
+
+	c.mutex.Lock()
+	if time.Since(c.lastFetch) >= c.minInterval {
+		fetchQuota(c.ctx, c.clients, c.sources, c.projects)
+		c.lastFetch = time.Now()
+	}
+	c.mutex.Unlock()
+
+	for _, update := range quotaUpdates.snapshot() {
+		switch {
+		case update.Provider != "":
+			ch <- prometheus.MustNewConstMetric(c.providerLimit, prometheus.GaugeValue, update.Limit, update.Provider, update.Project, update.Region, update.Metric)
+			ch <- prometheus.MustNewConstMetric(c.providerUsage, prometheus.GaugeValue, update.Usage, update.Provider, update.Project, update.Region, update.Metric)
+		case update.Region == "":
+			ch <- prometheus.MustNewConstMetric(c.globalLimit, prometheus.GaugeValue, update.Limit, update.Project, update.Metric)
+			ch <- prometheus.MustNewConstMetric(c.globalUsage, prometheus.GaugeValue, update.Usage, update.Project, update.Metric)
+		default:
+			ch <- prometheus.MustNewConstMetric(c.regionalLimit, prometheus.GaugeValue, update.Limit, update.Project, update.Region, update.Metric)
+			ch <- prometheus.MustNewConstMetric(c.regionalUsage, prometheus.GaugeValue, update.Usage, update.Project, update.Region, update.Metric)
+		}
+	}
+}
+
+// isScrapeTimeFetch checks whether a fetch runs inside a scrape; its gauges can't be staged then, since applying them would wait
+// for the scrape to finish
+func isScrapeTimeFetch(ctx context.Context) bool {
+	scrapeTime, _ := ctx.Value(scrapeTimeFetchKey{}).(bool)
+	return scrapeTime
+}
+
+// describeGaugeVec returns the description of a gauge vec, to emit const metrics with the same name, help and labels
+func describeGaugeVec(vec *prometheus.GaugeVec) *prometheus.Desc {
+	ch := make(chan *prometheus.Desc, 1)
+	vec.Describe(ch)
+	return <-ch
+}
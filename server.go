@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// initLivenessServer serves the /liveness, /ready and /healthz/details endpoints on port 5000; it uses its own mux so nothing registered on the default mux - like pprof - leaks onto it
+func initLivenessServer() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/liveness", func(w http.ResponseWriter, _ *http.Request) {
+		if err := checkCycleWatchdog(); err != nil {
+			log.Error().Err(err).Msg("Collection loop looks wedged, failing liveness")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "I'm alive!\n")
+	})
+
+	// only ready once every configured project has been fetched, so prometheus doesn't scrape empty metrics
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) {
+		if err := checkReadiness(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "I'm ready!\n")
+	})
+
+	// per project detail for automated runbooks, so they can pinpoint a failing project without parsing logs
+	mux.HandleFunc("/healthz/details", func(w http.ResponseWriter, _ *http.Request) {
+		status := "ok"
+		watchdog := ""
+		if err := checkCycleWatchdog(); err != nil {
+			status = "wedged"
+			watchdog = err.Error()
+		}
+		projects := projectHealthDetails()
+		for _, health := range projects {
+			if health.Status != "up" && status == "ok" {
+				status = "degraded"
+			}
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"status":             status,
+			"watchdog":           watchdog,
+			"lastCycleCompleted": time.Unix(0, atomic.LoadInt64(&lastCycleCompleted)),
+			"projects":           projects,
+		})
+	})
+
+	go func() {
+		log.Debug().
+			Str("port", ":5000").
+			Msg("Serving /liveness and /ready endpoints...")
+
+		if err := http.ListenAndServe(":5000", mux); err != nil {
+			log.Fatal().Err(err).Msg("Starting /liveness listener failed")
+		}
+	}()
+}
+
+// initMetricsServer serves the prometheus metrics on the configured listen address and path
+func initMetricsServer(tokenValidator *bearerTokenValidator, iap *iapValidator, limiter *scrapeLimiter) {
+
+	mux := http.NewServeMux()
+	mux.Handle(*prometheusMetricsPath, limiter.middleware(iap.middleware(tokenValidator.middleware(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(metricsGatherer, promhttp.HandlerOpts{}))))))
+
+	// the event streams are long lived, so they're not subject to the scrape limits
+	mux.Handle("/events", iap.middleware(tokenValidator.middleware(http.HandlerFunc(handleEvents))))
+	mux.Handle("/ws", iap.middleware(tokenValidator.middleware(http.HandlerFunc(handleWebsocket))))
+
+	mux.Handle("/http-sd", iap.middleware(tokenValidator.middleware(http.HandlerFunc(handleHTTPSD))))
+
+	if *dashboardEnabled {
+		history := newHistoryStore(*historyRetention)
+		go history.run()
+		mux.Handle("/dashboard", iap.middleware(tokenValidator.middleware(&dashboardHandler{history: history})))
+	}
+
+	// slack can't present a bearer token or pass iap, its requests are authenticated by their signature instead
+	if *slackSigningSecret != "" {
+		mux.Handle("/slack/command", newSlackCommandHandler(*slackSigningSecret))
+	}
+
+	go func() {
+		log.Debug().
+			Str("network", *prometheusMetricsNetwork).
+			Str("address", *prometheusMetricsAddress).
+			Str("path", *prometheusMetricsPath).
+			Msg("Serving Prometheus metrics...")
+
+		// use the socket passed by systemd if the exporter runs as socket activated service
+		listener, err := systemdListener("metrics")
+		if err != nil {
+			log.Fatal().Err(err).Msg("Using systemd activated socket failed")
+		}
+
+		if listener != nil {
+			log.Info().Msgf("Serving Prometheus metrics on systemd activated socket %v", listener.Addr())
+		} else {
+			// listen explicitly so ipv4-only, ipv6-only or dual-stack binding can be chosen
+			listener, err = net.Listen(*prometheusMetricsNetwork, *prometheusMetricsAddress)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Starting Prometheus listener failed")
+			}
+		}
+
+		if err := http.Serve(listener, mux); err != nil {
+			log.Fatal().Err(err).Msg("Serving Prometheus metrics failed")
+		}
+	}()
+}
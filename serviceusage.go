@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceUsageUsageWindow is how far back the allocation quota usage is looked up in cloud monitoring; it's only reported when
+// it's sampled, so the latest point within the window is used
+const serviceUsageUsageWindow = 30 * time.Minute
+
+var (
+	// create gauges for the limit and usage of quota of any google cloud service
+	serviceQuotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_service_limit",
+		Help: "The effective limit of a quota of a google cloud service as reported by the service usage api, with region global for quota that isn't regional; -1 is unlimited.",
+	}, []string{"project", "service", "quota_metric", "unit", "region"})
+
+	serviceQuotaUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_service_usage",
+		Help: "The usage of an allocation quota of a google cloud service as last reported to cloud monitoring; rate quota usage isn't exported.",
+	}, []string{"project", "service", "quota_metric", "unit", "region"})
+
+	// create counter for the services whose quota couldn't be retrieved, which are skipped without failing the other services
+	serviceQuotaErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_service_errors_total",
+		Help: "The number of failed attempts to retrieve the quota of a google cloud service, or its usage for service monitoring.googleapis.com.",
+	}, []string{"project", "service"})
+)
+
+func init() {
+	prometheus.MustRegister(serviceQuotaLimit)
+	prometheus.MustRegister(serviceQuotaUsage)
+	prometheus.MustRegister(serviceQuotaErrorsTotal)
+
+	registerQuotaSource("service-usage", func(clients clientProvider, regions []string) quotaSource {
+		return &serviceUsageQuotaSource{clients: clients, regions: regions, services: splitNonEmpty(*serviceUsageServices)}
+	})
+}
+
+// serviceUsageQuotaSource retrieves the quota of all services, like pubsub.googleapis.com or bigquery.googleapis.com, with the limits
+// from the service usage api and the usage of allocation quota from cloud monitoring, which the service usage api doesn't report
+type serviceUsageQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// services to retrieve quota for; all enabled services if empty
+	services []string
+}
+
+func (s *serviceUsageQuotaSource) Name() string {
+	return "service-usage"
+}
+
+// Discover doesn't return locations, the quota of all regions is retrieved per service in one call
+func (s *serviceUsageQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *serviceUsageQuotaSource) Permissions() []string {
+	return []string{"serviceusage.services.list", "serviceusage.quotas.get", "monitoring.timeSeries.list"}
+}
+
+// serviceQuotaKey identifies the usage of a quota metric of a service in a region
+type serviceQuotaKey struct {
+	service     string
+	quotaMetric string
+	region      string
+}
+
+func (s *serviceUsageQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	services := s.services
+	if len(services) == 0 {
+		services, err = listEnabledServices(ctx, httpClient, project)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a single service or the usage lookup failing, e.g. because the api rejects the call, shouldn't hide the quota of all other
+	// services; without usage the limits are still exported
+	logger := loggerFromContext(ctx)
+	usage, err := listAllocationUsage(ctx, httpClient, project)
+	if err != nil {
+		logger.Warn().Err(err).Msgf("Retrieving allocation quota usage for project %v failed, exporting limits only", project)
+		serviceQuotaErrorsTotal.WithLabelValues(project, "monitoring.googleapis.com").Inc()
+		usage = map[serviceQuotaKey]float64{}
+	}
+
+	var lastErr error
+	failed := 0
+	for _, service := range services {
+		buckets, err := listConsumerQuotaBuckets(ctx, httpClient, project, service)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Retrieving quota of service %v for project %v failed, skipping it", service, project)
+			serviceQuotaErrorsTotal.WithLabelValues(project, service).Inc()
+			lastErr = err
+			failed++
+			continue
+		}
+
+		for _, bucket := range buckets {
+			region, ok := quotaDimensionsRegion(bucket.Dimensions, s.regions)
+			if !ok {
+				continue
+			}
+
+			value, ok := sanitizeQuotaValue("gcloud", project, bucket.Metric, "limit", float64(bucket.EffectiveLimit))
+			if !ok {
+				continue
+			}
+			setGauge(ctx, serviceQuotaLimit, value, project, service, bucket.Metric, bucket.Unit, region)
+
+			if used, ok := usage[serviceQuotaKey{service: service, quotaMetric: bucket.Metric, region: region}]; ok {
+				setGauge(ctx, serviceQuotaUsage, used, project, service, bucket.Metric, bucket.Unit, region)
+			}
+		}
+	}
+
+	// only fail the project if no service could be retrieved at all, which is rather a problem with the project than a service
+	if len(services) > 0 && failed == len(services) {
+		return nil, lastErr
+	}
+
+	// the quota of other services isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
+
+// consumerQuotaBucket is the effective limit of a quota metric of a service for a set of dimensions, like a region
+type consumerQuotaBucket struct {
+	Metric         string
+	Unit           string
+	EffectiveLimit int64
+	Dimensions     map[string]string
+}
+
+// listConsumerQuotaBuckets returns the effective limits of all quota metrics of a service in the project
+func listConsumerQuotaBuckets(ctx context.Context, httpClient *http.Client, project, service string) ([]consumerQuotaBucket, error) {
+
+	buckets := []consumerQuotaBucket{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("view", "BASIC")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Metrics []struct {
+				Metric              string `json:"metric"`
+				ConsumerQuotaLimits []struct {
+					Unit         string `json:"unit"`
+					QuotaBuckets []struct {
+						EffectiveLimit int64             `json:"effectiveLimit,string"`
+						Dimensions     map[string]string `json:"dimensions"`
+					} `json:"quotaBuckets"`
+				} `json:"consumerQuotaLimits"`
+			} `json:"metrics"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://serviceusage.googleapis.com/v1beta1/projects/%v/services/%v/consumerQuotaMetrics?%v", project, service, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, metric := range response.Metrics {
+			for _, limit := range metric.ConsumerQuotaLimits {
+				for _, bucket := range limit.QuotaBuckets {
+					buckets = append(buckets, consumerQuotaBucket{Metric: metric.Metric, Unit: limit.Unit, EffectiveLimit: bucket.EffectiveLimit, Dimensions: bucket.Dimensions})
+				}
+			}
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing quota of service %v failed: %v", service, err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	return buckets, nil
+}
+
+// quotaDimensionsRegion returns the region the dimensions of a quota value apply to, global if there are none; values for other
+// dimensions, like per gpu family overrides, and regions that aren't configured are skipped to keep the number of series bounded
+func quotaDimensionsRegion(dimensions map[string]string, regions []string) (string, bool) {
+
+	region := "global"
+	for key, value := range dimensions {
+		if key != "region" {
+			return "", false
+		}
+		region = value
+	}
+
+	if region != "global" && len(regions) > 0 && !stringInSlice(regions, region) {
+		return "", false
+	}
+
+	return region, true
+}
+
+// listEnabledServices returns the names of all services enabled in the project
+func listEnabledServices(ctx context.Context, httpClient *http.Client, project string) ([]string, error) {
+
+	services := []string{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("filter", "state:ENABLED")
+		query.Set("fields", "services/config/name,nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Services []struct {
+				Config struct {
+					Name string `json:"name"`
+				} `json:"config"`
+			} `json:"services"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://serviceusage.googleapis.com/v1/projects/%v/services?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range response.Services {
+			services = append(services, service.Config.Name)
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing enabled services failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	return services, nil
+}
+
+// listAllocationUsage returns the latest usage of all allocation quota in the project from cloud monitoring, by service, quota metric
+// and region
+func listAllocationUsage(ctx context.Context, httpClient *http.Client, project string) (map[serviceQuotaKey]float64, error) {
+
+	end := time.Now().UTC()
+	start := end.Add(-serviceUsageUsageWindow)
+
+	usage := map[serviceQuotaKey]float64{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("filter", `metric.type="serviceruntime.googleapis.com/quota/allocation/usage" AND resource.type="consumer_quota"`)
+		query.Set("interval.startTime", start.Format(time.RFC3339))
+		query.Set("interval.endTime", end.Format(time.RFC3339))
+		// a single aligned point per series, with the latest value in the window
+		query.Set("aggregation.alignmentPeriod", fmt.Sprintf("%vs", int(serviceUsageUsageWindow.Seconds())))
+		query.Set("aggregation.perSeriesAligner", "ALIGN_NEXT_OLDER")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			TimeSeries []struct {
+				Metric struct {
+					Labels map[string]string `json:"labels"`
+				} `json:"metric"`
+				Resource struct {
+					Labels map[string]string `json:"labels"`
+				} `json:"resource"`
+				Points []struct {
+					Value struct {
+						Int64Value string `json:"int64Value"`
+					} `json:"value"`
+				} `json:"points"`
+			} `json:"timeSeries"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://monitoring.googleapis.com/v3/projects/%v/timeSeries?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, series := range response.TimeSeries {
+			if len(series.Points) == 0 {
+				continue
+			}
+			// points are returned newest first
+			value, err := strconv.ParseInt(series.Points[0].Value.Int64Value, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			region := series.Resource.Labels["location"]
+			if region == "" {
+				region = "global"
+			}
+			usage[serviceQuotaKey{service: series.Resource.Labels["service"], quotaMetric: series.Metric.Labels["quota_metric"], region: region}] = float64(value)
+		}
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing allocation quota usage failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	return usage, nil
+}
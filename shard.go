@@ -0,0 +1,50 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// create gauge for the number of projects assigned to this shard, to check the projects are spread evenly across replicas
+	shardProjectsCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_shard_projects",
+		Help: "The number of projects assigned to this shard per collector, out of all configured or discovered projects.",
+	}, []string{"shard", "total_shards", "collector"})
+)
+
+func init() {
+	prometheus.MustRegister(shardProjectsCount)
+}
+
+// shardOwnsProject returns whether the project is assigned to the shard, by the hash of its id modulo the total number of shards, so
+// every replica picks the same split without coordinating
+func shardOwnsProject(project string, shard, totalShards int) bool {
+
+	if totalShards <= 1 {
+		return true
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(project))
+
+	return hash.Sum64()%uint64(totalShards) == uint64(shard)
+}
+
+// shardProjects returns the projects assigned to the configured shard
+func shardProjects(projects []string) []string {
+
+	if *totalShards <= 1 {
+		return projects
+	}
+
+	owned := []string{}
+	for _, project := range projects {
+		if shardOwnsProject(project, *shard, *totalShards) {
+			owned = append(owned, project)
+		}
+	}
+
+	return owned
+}
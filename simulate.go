@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// simulatedRegions are used in order for the simulated regional quota
+var simulatedRegions = []string{"europe-west1", "europe-west4", "us-central1", "us-east1", "us-west1", "asia-east1", "asia-northeast1", "australia-southeast1", "southamerica-east1", "northamerica-northeast1"}
+
+// simulatedGlobalQuota and simulatedRegionalQuota have the limits of a typical project
+var simulatedGlobalQuota = map[string]float64{
+	"SNAPSHOTS":                 5000,
+	"NETWORKS":                  15,
+	"FIREWALLS":                 200,
+	"IMAGES":                    2000,
+	"STATIC_ADDRESSES":          8,
+	"ROUTES":                    250,
+	"FORWARDING_RULES":          15,
+	"TARGET_POOLS":              50,
+	"HEALTH_CHECKS":             75,
+	"IN_USE_ADDRESSES":          69,
+	"TARGET_INSTANCES":          50,
+	"TARGET_HTTP_PROXIES":       10,
+	"URL_MAPS":                  10,
+	"BACKEND_SERVICES":          50,
+	"INSTANCE_TEMPLATES":        100,
+	"SUBNETWORKS":               100,
+	"SSL_CERTIFICATES":          15,
+	"CPUS_ALL_REGIONS":          32,
+	"GPUS_ALL_REGIONS":          0,
+	"SECURITY_POLICIES":         10,
+	"TARGET_HTTPS_PROXIES":      10,
+	"GLOBAL_INTERNAL_ADDRESSES": 5000,
+}
+
+var simulatedRegionalQuota = map[string]float64{
+	"CPUS":                             72,
+	"DISKS_TOTAL_GB":                   4096,
+	"STATIC_ADDRESSES":                 8,
+	"IN_USE_ADDRESSES":                 8,
+	"SSD_TOTAL_GB":                     500,
+	"LOCAL_SSD_TOTAL_GB":               3000,
+	"INSTANCE_GROUPS":                  100,
+	"INSTANCE_GROUP_MANAGERS":          50,
+	"INSTANCES":                        720,
+	"AUTOSCALERS":                      50,
+	"REGIONAL_AUTOSCALERS":             50,
+	"REGIONAL_INSTANCE_GROUP_MANAGERS": 50,
+	"PREEMPTIBLE_CPUS":                 0,
+	"NVIDIA_T4_GPUS":                   0,
+	"COMMITTED_CPUS":                   0,
+	"INTERNAL_ADDRESSES":               200,
+}
+
+// simulatedComputeClient is a fake compute api with synthetic quota whose usage drifts every time a project is retrieved, which
+// happens once per fetch cycle
+type simulatedComputeClient struct {
+	*fakeComputeClient
+
+	regions []string
+	drift   float64
+	random  *rand.Rand
+}
+
+// newSimulatedComputeClient creates projects simulated-project-1 to simulated-project-n with quota in the first regionCount
+// simulated regions, numbering any regions beyond them; drift is the maximum change in usage per cycle as a fraction of the limit
+func newSimulatedComputeClient(projectCount, regionCount int, drift float64) (*simulatedComputeClient, []string, []string, error) {
+
+	if projectCount < 1 {
+		return nil, nil, nil, fmt.Errorf("The number of simulated projects should be at least 1, but is %v", projectCount)
+	}
+	if regionCount < 0 {
+		return nil, nil, nil, fmt.Errorf("The number of simulated regions can't be negative, but is %v", regionCount)
+	}
+
+	// beyond the real region names, like when benchmarking, regions are numbered
+	regions := simulatedRegions
+	if regionCount <= len(regions) {
+		regions = regions[:regionCount]
+	}
+	for i := len(regions) + 1; i <= regionCount; i++ {
+		regions = append(regions, fmt.Sprintf("simulated-region%v", i))
+	}
+
+	c := &simulatedComputeClient{
+		fakeComputeClient: newFakeComputeClient(),
+		regions:           regions,
+		drift:             drift,
+		random:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	projects := []string{}
+	for i := 1; i <= projectCount; i++ {
+		project := fmt.Sprintf("simulated-project-%v", i)
+		projects = append(projects, project)
+
+		c.SetProjectQuota(project, c.initialQuota(simulatedGlobalQuota))
+		for _, region := range c.regions {
+			c.SetRegionQuota(project, region, c.initialQuota(simulatedRegionalQuota))
+		}
+	}
+
+	return c, projects, c.regions, nil
+}
+
+func (c *simulatedComputeClient) GetProject(ctx context.Context, project string) (*compute.Project, bool, error) {
+
+	c.driftUsage(project)
+
+	return c.fakeComputeClient.GetProject(ctx, project)
+}
+
+// initialQuota starts usage somewhere between empty and 3/4 of the limit, so some quota gets near its limit while drifting
+func (c *simulatedComputeClient) initialQuota(limits map[string]float64) []*compute.Quota {
+
+	quotas := []*compute.Quota{}
+	for metric, limit := range limits {
+		quotas = append(quotas, &compute.Quota{
+			Metric: metric,
+			Limit:  limit,
+			Usage:  math.Round(c.random.Float64() * 0.75 * limit),
+		})
+	}
+
+	return quotas
+}
+
+// driftUsage moves the usage of all quota of the project up or down, within the limit
+func (c *simulatedComputeClient) driftUsage(project string) {
+
+	// the random source is only used while holding the lock of the fake
+	c.fakeComputeClient.mutex.Lock()
+	defer c.fakeComputeClient.mutex.Unlock()
+
+	p, ok := c.projects[project]
+	if !ok {
+		return
+	}
+	c.driftQuota(p.Quotas)
+
+	for _, region := range c.fakeComputeClient.regions[project] {
+		c.driftQuota(region.Quotas)
+	}
+}
+
+func (c *simulatedComputeClient) driftQuota(quotas []*compute.Quota) {
+	for _, quota := range quotas {
+		change := math.Round((c.random.Float64()*2 - 1) * c.drift * quota.Limit)
+		quota.Usage = math.Max(0, math.Min(quota.Limit, quota.Usage+change))
+	}
+}
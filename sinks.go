@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+// metricSink pushes the gathered metrics to a system that doesn't scrape the prometheus endpoint
+type metricSink interface {
+	Name() string
+	Push(ctx context.Context, families []*dto.MetricFamily) error
+}
+
+// initSinks starts pushing metrics to all configured outputs besides the prometheus endpoint
+func initSinks(ctx context.Context) {
+
+	if *otlpEndpoint != "" {
+		sink, err := newOTLPSink(*otlpEndpoint, *otlpHeaders)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating otlp sink failed")
+		}
+		startSink(ctx, sink, *otlpPushInterval)
+	}
+
+	if *remoteWriteURL != "" {
+		startSink(ctx, newRemoteWriteSink(*remoteWriteURL, *remoteWriteUsername, *remoteWritePassword, *remoteWriteBearerToken), *remoteWriteInterval)
+	}
+
+	if *statsdAddress != "" {
+		startSink(ctx, newStatsdSink(*statsdAddress, *statsdPrefix, *statsdDogstatsdTags), *statsdInterval)
+	}
+
+	if *datadogAPIKey != "" {
+		sink, err := newDatadogSink(*datadogAPIKey, *datadogSite, *datadogPrefix, *datadogTags, *datadogTagMapping)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating datadog sink failed")
+		}
+		startSink(ctx, sink, *datadogInterval)
+	}
+
+	if *graphiteAddress != "" {
+		err := startGraphiteBridge(ctx, *graphiteAddress, *graphitePrefix, *graphiteInterval)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating graphite bridge failed")
+		}
+	}
+}
+
+// startSink pushes all registered metrics to the sink at the interval until the context is done
+func startSink(ctx context.Context, sink metricSink, interval time.Duration) {
+
+	log.Info().Msgf("Pushing metrics to %v every %v", sink.Name(), interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pushToSink(ctx, sink)
+			}
+		}
+	}()
+}
+
+func pushToSink(ctx context.Context, sink metricSink) {
+
+	families, err := metricsGatherer.Gather()
+	if err != nil {
+		log.Warn().Err(err).Msgf("Gathering metrics for %v failed", sink.Name())
+		return
+	}
+
+	if err := sink.Push(ctx, families); err != nil {
+		log.Warn().Err(err).Msgf("Pushing metrics to %v failed", sink.Name())
+	}
+}
+
+// labelMap returns the labels of a metric as map
+func labelMap(metric *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+// flatSeries is a single sample with its labels, including __name__
+type flatSeries struct {
+	labels map[string]string
+	value  float64
+}
+
+// flattenFamilies turns the metric families into individual series the way the prometheus text format does, splitting histograms in _bucket, _sum and _count series
+func flattenFamilies(families []*dto.MetricFamily) (series []flatSeries) {
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+
+			withName := func(name string, extra ...string) map[string]string {
+				labels := labelMap(m)
+				labels["__name__"] = name
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels[extra[i]] = extra[i+1]
+				}
+				return labels
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				series = append(series, flatSeries{withName(family.GetName()), m.GetGauge().GetValue()})
+			case dto.MetricType_COUNTER:
+				series = append(series, flatSeries{withName(family.GetName()), m.GetCounter().GetValue()})
+			case dto.MetricType_UNTYPED:
+				series = append(series, flatSeries{withName(family.GetName()), m.GetUntyped().GetValue()})
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					series = append(series, flatSeries{withName(family.GetName()+"_bucket", "le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)), float64(bucket.GetCumulativeCount())})
+				}
+				series = append(series, flatSeries{withName(family.GetName()+"_bucket", "le", "+Inf"), float64(histogram.GetSampleCount())})
+				series = append(series, flatSeries{withName(family.GetName() + "_sum"), histogram.GetSampleSum()})
+				series = append(series, flatSeries{withName(family.GetName() + "_count"), float64(histogram.GetSampleCount())})
+			}
+		}
+	}
+
+	return series
+}
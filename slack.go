@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// slackMaxRequestAge rejects replayed requests, as recommended by slack
+	slackMaxRequestAge = 5 * time.Minute
+
+	slackMaxLines = 25
+)
+
+// slackCommandHandler answers slash commands like /quota project-x cpus europe-west1 from the quota of the last fetch cycle
+type slackCommandHandler struct {
+	signingSecret []byte
+}
+
+func newSlackCommandHandler(signingSecret string) *slackCommandHandler {
+	return &slackCommandHandler{signingSecret: []byte(signingSecret)}
+}
+
+func (h *slackCommandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "Reading request failed", http.StatusBadRequest)
+		return
+	}
+
+	if !h.validSignature(r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body, time.Now()) {
+		log.Warn().Str("remoteAddr", r.RemoteAddr).Msg("Rejected slack command with invalid signature")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "Parsing request failed", http.StatusBadRequest)
+		return
+	}
+
+	text := formatSlackQuota(quotaUpdates.snapshot(), strings.Fields(form.Get("text")), form.Get("command"))
+
+	writeJSON(w, map[string]string{
+		"response_type": "ephemeral",
+		"text":          text,
+	})
+}
+
+// validSignature checks the v0 signature slack computes over the timestamp and body with the app's signing secret
+func (h *slackCommandHandler) validSignature(timestamp, signature string, body []byte, now time.Time) bool {
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > slackMaxRequestAge.Seconds() {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, h.signingSecret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// formatSlackQuota lists the quota matching the project, metric and region arguments, most utilized first; metric and region are
+// optional and the metric matches on a substring, so cpus finds both cpus and cpus_all_regions
+func formatSlackQuota(updates []quotaUpdate, args []string, command string) string {
+
+	if command == "" {
+		command = "/quota"
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("Usage: `%v <project> [metric] [region]`, e.g. `%v my-project cpus europe-west1`", command, command)
+	}
+	if len(updates) == 0 {
+		return "No quota has been retrieved yet, try again after the first fetch cycle."
+	}
+
+	project := args[0]
+	metric := ""
+	if len(args) > 1 {
+		metric = strings.ToLower(args[1])
+	}
+	region := ""
+	if len(args) > 2 {
+		region = args[2]
+	}
+
+	matches := []quotaUpdate{}
+	for _, update := range updates {
+		if update.Project != project {
+			continue
+		}
+		if metric != "" && !strings.Contains(update.Metric, metric) {
+			continue
+		}
+		if region != "" && update.Region != region {
+			continue
+		}
+		matches = append(matches, update)
+	}
+
+	if len(matches) == 0 {
+		return fmt.Sprintf("No quota found for `%v`.", strings.Join(args, " "))
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return utilization(matches[i]) > utilization(matches[j])
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Quota for *%v* as of %v:\n```\n", project, matches[0].Timestamp.UTC().Format(time.RFC3339))
+	for i, update := range matches {
+		if i == slackMaxLines {
+			fmt.Fprintf(&b, "... and %v more, narrow down with a metric or region\n", len(matches)-slackMaxLines)
+			break
+		}
+
+		scope := update.Region
+		if scope == "" {
+			scope = "global"
+		}
+		if update.Limit < 0 {
+			fmt.Fprintf(&b, "%-35v %-24v %8v / unlimited\n", update.Metric, scope, update.Usage)
+			continue
+		}
+		fmt.Fprintf(&b, "%-35v %-24v %8v / %-8v %5.1f%%  headroom %v\n", update.Metric, scope, update.Usage, update.Limit, 100*utilization(update), update.Limit-update.Usage)
+	}
+	b.WriteString("```")
+
+	return b.String()
+}
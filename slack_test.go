@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSlackCommandHandlerValidSignature(t *testing.T) {
+
+	h := newSlackCommandHandler("signing-secret")
+	now := time.Unix(1531420618, 0)
+	body := []byte("command=%2Fquota&text=project-x+cpus")
+
+	sign := func(secret, timestamp string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":"))
+		mac.Write(body)
+		return "v0=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	timestamp := func(at time.Time) string {
+		return strconv.FormatInt(at.Unix(), 10)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		valid     bool
+	}{
+		{name: "AcceptsValidSignature", timestamp: timestamp(now), signature: sign("signing-secret", timestamp(now), body), body: body, valid: true},
+		{name: "AcceptsSlightlyOlderRequest", timestamp: timestamp(now.Add(-time.Minute)), signature: sign("signing-secret", timestamp(now.Add(-time.Minute)), body), body: body, valid: true},
+		{name: "RejectsStaleTimestamp", timestamp: timestamp(now.Add(-10 * time.Minute)), signature: sign("signing-secret", timestamp(now.Add(-10*time.Minute)), body), body: body},
+		{name: "RejectsTimestampInTheFuture", timestamp: timestamp(now.Add(10 * time.Minute)), signature: sign("signing-secret", timestamp(now.Add(10*time.Minute)), body), body: body},
+		{name: "RejectsMalformedTimestamp", timestamp: "yesterday", signature: sign("signing-secret", "yesterday", body), body: body},
+		{name: "RejectsHMACWithOtherSecret", timestamp: timestamp(now), signature: sign("other-secret", timestamp(now), body), body: body},
+		{name: "RejectsHMACOfOtherBody", timestamp: timestamp(now), signature: sign("signing-secret", timestamp(now), []byte("command=%2Fquota&text=project-y")), body: body},
+		{name: "RejectsMissingSignature", timestamp: timestamp(now), signature: "", body: body},
+		{name: "RejectsOtherSignatureVersion", timestamp: timestamp(now), signature: "v1=" + sign("signing-secret", timestamp(now), body)[3:], body: body},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := h.validSignature(tt.timestamp, tt.signature, tt.body, now); valid != tt.valid {
+				t.Errorf("Validating signature returned %v, expected %v", valid, tt.valid)
+			}
+		})
+	}
+}
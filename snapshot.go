@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// snapshotMutex is held for writing while a fetch cycle's gauges are applied, and for reading while metrics are gathered,
+	// so a scrape sees either all of the previous cycle or all of the new one
+	snapshotMutex sync.RWMutex
+
+	// metricsGatherer gathers the default registry without interleaving with a cycle being applied; use it instead of
+	// prometheus.DefaultGatherer everywhere metrics leave the exporter
+	metricsGatherer prometheus.Gatherer = snapshotGatherer{Gatherer: prometheus.DefaultGatherer}
+)
+
+type snapshotGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g snapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	snapshotMutex.RLock()
+	defer snapshotMutex.RUnlock()
+
+	return g.Gatherer.Gather()
+}
+
+type gaugeSnapshotKey struct{}
+
+// gaugeSnapshot collects the gauge values of a fetch cycle, to be applied at once when the cycle is done
+type gaugeSnapshot struct {
+	sets  []stagedGauge
+	mutex sync.Mutex
+
+	// which collectors and projects were listed and collected successfully in the cycle, to tell stale series apart
+	listed             map[seriesOwner]bool
+	collected          map[seriesOwner]bool
+	unlistedCollectors map[string]bool
+
+	// the series kept as if they were set again, because the response they were set from didn't change
+	kept []keptSeries
+}
+
+type keptSeries struct {
+	owner seriesOwner
+	gauge *prometheus.GaugeVec
+}
+
+type stagedGauge struct {
+	owner  seriesOwner
+	owned  bool
+	gauge  *prometheus.GaugeVec
+	value  float64
+	labels []string
+}
+
+// withGaugeSnapshot returns a context in which setGauge stages values in the returned snapshot instead of setting them right away
+func withGaugeSnapshot(ctx context.Context) (context.Context, *gaugeSnapshot) {
+	snapshot := &gaugeSnapshot{
+		sets:               newStagedGauges(),
+		listed:             map[seriesOwner]bool{},
+		collected:          map[seriesOwner]bool{},
+		unlistedCollectors: map[string]bool{},
+	}
+	return context.WithValue(ctx, gaugeSnapshotKey{}, snapshot), snapshot
+}
+
+// setGauge sets the gauge with the labels, or stages it if the context belongs to a fetch cycle; the series is only created once
+// it's applied, so a scrape doesn't see new series at 0 either
+func setGauge(ctx context.Context, gauge *prometheus.GaugeVec, value float64, labels ...string) {
+
+	snapshot, ok := ctx.Value(gaugeSnapshotKey{}).(*gaugeSnapshot)
+	if !ok {
+		gauge.WithLabelValues(labels...).Set(value)
+		return
+	}
+
+	snapshot.mutex.Lock()
+	defer snapshot.mutex.Unlock()
+
+	owner, owned := seriesOwnerFromContext(ctx)
+	snapshot.sets = append(snapshot.sets, stagedGauge{owner: owner, owned: owned, gauge: gauge, value: value, labels: labels})
+}
+
+// keepSeries keeps the series of the gauges that the collector and project in the context set in an earlier cycle, as if they were
+// set again with the same values; it returns false if there are none or the context doesn't belong to a fetch cycle, in which case
+// the caller has to set them
+func keepSeries(ctx context.Context, gauges ...*prometheus.GaugeVec) bool {
+
+	snapshot, ok := ctx.Value(gaugeSnapshotKey{}).(*gaugeSnapshot)
+	if !ok {
+		return false
+	}
+	owner, ok := seriesOwnerFromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	snapshotMutex.RLock()
+	for _, gauge := range gauges {
+		if len(activeSeries[owner][gauge]) == 0 {
+			snapshotMutex.RUnlock()
+			return false
+		}
+	}
+	snapshotMutex.RUnlock()
+
+	snapshot.mutex.Lock()
+	defer snapshot.mutex.Unlock()
+
+	for _, gauge := range gauges {
+		snapshot.kept = append(snapshot.kept, keptSeries{owner: owner, gauge: gauge})
+	}
+
+	return true
+}
+
+// listProjects records the projects a collector fetches in this cycle; series of projects that aren't listed are removed
+func (s *gaugeSnapshot) listProjects(collector string, projects []string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, project := range projects {
+		s.listed[seriesOwner{collector: collector, project: project}] = true
+	}
+}
+
+// keepCollector keeps all series of a collector whose projects couldn't be listed in this cycle
+func (s *gaugeSnapshot) keepCollector(collector string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.unlistedCollectors[collector] = true
+}
+
+// markCollected records that the collector retrieved all quota for the project, so series it didn't set again are stale
+func (s *gaugeSnapshot) markCollected(collector, project string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.collected[seriesOwner{collector: collector, project: project}] = true
+}
+
+// apply sets all staged gauges and removes stale series while no metrics are being gathered; series that already exist are updated in
+// place through their tracked gauge, so a cycle doesn't rebuild the label index of every series
+func (s *gaugeSnapshot) apply() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	seriesCycle++
+
+	key := make([]byte, 0, 256)
+	for _, set := range s.sets {
+		if !set.owned {
+			set.gauge.WithLabelValues(set.labels...).Set(set.value)
+			continue
+		}
+
+		key = appendSeriesKey(key[:0], set.labels)
+		trackSeries(set.owner, set.gauge, set.labels, key).Set(set.value)
+	}
+
+	releaseStagedGauges(s.sets)
+	s.sets = nil
+
+	for _, kept := range s.kept {
+		refreshSeries(kept.owner, kept.gauge)
+	}
+	s.kept = nil
+
+	removeStaleSeries(s.listed, s.collected, s.unlistedCollectors, *metricTTL)
+}
+
+var (
+	// stagedGaugesBuffer keeps the staging buffer of the last applied cycle, so the next cycle doesn't have to grow a new one
+	// from scratch for every gauge of every project
+	stagedGaugesBuffer      []stagedGauge
+	stagedGaugesBufferMutex sync.Mutex
+)
+
+// newStagedGauges returns an empty staging buffer, reusing the one of the last applied cycle if it's available
+func newStagedGauges() []stagedGauge {
+	stagedGaugesBufferMutex.Lock()
+	defer stagedGaugesBufferMutex.Unlock()
+
+	sets := stagedGaugesBuffer
+	stagedGaugesBuffer = nil
+
+	return sets[:0]
+}
+
+// releaseStagedGauges hands a staging buffer back for reuse, clearing it so it doesn't keep the label values of the cycle alive
+func releaseStagedGauges(sets []stagedGauge) {
+	for i := range sets {
+		sets[i] = stagedGauge{}
+	}
+
+	stagedGaugesBufferMutex.Lock()
+	defer stagedGaugesBufferMutex.Unlock()
+
+	if cap(sets) > cap(stagedGaugesBuffer) {
+		stagedGaugesBuffer = sets[:0]
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// spannerProcessingUnitsPerNode is the number of processing units a spanner node consists of
+const spannerProcessingUnitsPerNode = 1000
+
+var (
+	// create gauges for the processing units of spanner instances per instance configuration and the quota they count against
+	spannerProcessingUnits = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_spanner_processing_units",
+		Help: "The processing units of all spanner instances per instance configuration, like regional-europe-west1; a node is 1000 processing units.",
+	}, []string{"project", "config"})
+
+	spannerProcessingUnitsLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_spanner_processing_units_limit",
+		Help: "The processing unit quota per instance configuration, as set with --spanner-node-limit in nodes.",
+	}, []string{"project", "config"})
+
+	// create gauge for the processing units of each instance, to see which instance consumes the quota
+	spannerInstanceProcessingUnits = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_spanner_instance_processing_units",
+		Help: "The processing units of a spanner instance; a node is 1000 processing units.",
+	}, []string{"project", "config", "instance"})
+)
+
+func init() {
+	prometheus.MustRegister(spannerProcessingUnits)
+	prometheus.MustRegister(spannerProcessingUnitsLimit)
+	prometheus.MustRegister(spannerInstanceProcessingUnits)
+
+	registerQuotaSource("spanner", func(clients clientProvider, regions []string) quotaSource {
+		return &spannerQuotaSource{clients: clients, nodeLimit: *spannerNodeLimit}
+	})
+}
+
+// spannerInstance is the part of an instance of the spanner api that counts against the node quota
+type spannerInstance struct {
+	Name            string `json:"name"`
+	Config          string `json:"config"`
+	NodeCount       int64  `json:"nodeCount"`
+	ProcessingUnits int64  `json:"processingUnits"`
+}
+
+// spannerQuotaSource exports the processing units of spanner instances against the node quota per instance configuration; the spanner
+// api doesn't report the quota, so it's taken from a flag
+type spannerQuotaSource struct {
+	clients clientProvider
+
+	// the node quota per instance configuration; the limit isn't exported if it's 0
+	nodeLimit int
+}
+
+func (s *spannerQuotaSource) Name() string {
+	return "spanner"
+}
+
+// Discover doesn't return locations, instances of all configurations are listed at once
+func (s *spannerQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *spannerQuotaSource) Permissions() []string {
+	return []string{"spanner.instances.list"}
+}
+
+func (s *spannerQuotaSource) Collect(ctx context.Context, project string, locations []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve all pages before updating gauges, so a failing page doesn't leave a partial set
+	instances := []spannerInstance{}
+	tokens := pageTokens{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("fields", "instances(name,config,nodeCount,processingUnits),nextPageToken")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var response struct {
+			Instances     []spannerInstance `json:"instances"`
+			NextPageToken string            `json:"nextPageToken"`
+		}
+		err = getGoogleJSON(ctx, httpClient, fmt.Sprintf("https://spanner.googleapis.com/v1/projects/%v/instances?%v", project, query.Encode()), &response)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, response.Instances...)
+
+		more, err := tokens.next(response.NextPageToken)
+		if err != nil {
+			return nil, fmt.Errorf("Listing spanner instances failed: %v", err)
+		}
+		if !more {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+
+	perConfig := map[string]float64{}
+	for _, instance := range instances {
+		config := instance.Config[strings.LastIndex(instance.Config, "/")+1:]
+		name := instance.Name[strings.LastIndex(instance.Name, "/")+1:]
+
+		// instances created with a node count report processing units as well, but older responses may only have the node count
+		processingUnits := float64(instance.ProcessingUnits)
+		if processingUnits == 0 {
+			processingUnits = float64(instance.NodeCount * spannerProcessingUnitsPerNode)
+		}
+
+		perConfig[config] += processingUnits
+		setGauge(ctx, spannerInstanceProcessingUnits, processingUnits, project, config, name)
+	}
+
+	for config, processingUnits := range perConfig {
+		setGauge(ctx, spannerProcessingUnits, processingUnits, project, config)
+		if s.nodeLimit > 0 {
+			setGauge(ctx, spannerProcessingUnitsLimit, float64(s.nodeLimit*spannerProcessingUnitsPerNode), project, config)
+		}
+	}
+
+	// spanner capacity isn't compute quota, so it's only exported as metrics
+	return nil, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// thresholdCrossing is sent when the utilization of a quota moves above or below one of the configured thresholds
+type thresholdCrossing struct {
+	Provider    string    `json:"provider,omitempty"`
+	Project     string    `json:"project"`
+	Region      string    `json:"region,omitempty"`
+	Metric      string    `json:"metric"`
+	Threshold   float64   `json:"threshold"`
+	Utilization float64   `json:"utilization"`
+	Direction   string    `json:"direction"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+func utilization(update quotaUpdate) float64 {
+	if update.Limit <= 0 {
+		return 0
+	}
+	return update.Usage / update.Limit
+}
+
+// detectThresholdCrossings compares the utilization of each quota in the current cycle with the previous cycle
+func detectThresholdCrossings(previous, current []quotaUpdate, thresholds []float64) (crossings []thresholdCrossing) {
+
+	type key struct{ provider, project, region, metric string }
+	previousUtilization := map[key]float64{}
+	for _, update := range previous {
+		previousUtilization[key{update.Provider, update.Project, update.Region, update.Metric}] = utilization(update)
+	}
+
+	for _, update := range current {
+		before, ok := previousUtilization[key{update.Provider, update.Project, update.Region, update.Metric}]
+		if !ok {
+			continue
+		}
+		after := utilization(update)
+
+		for _, threshold := range thresholds {
+			direction := ""
+			if before < threshold && after >= threshold {
+				direction = "up"
+			} else if before >= threshold && after < threshold {
+				direction = "down"
+			}
+			if direction == "" {
+				continue
+			}
+
+			crossings = append(crossings, thresholdCrossing{
+				Provider:    update.Provider,
+				Project:     update.Project,
+				Region:      update.Region,
+				Metric:      update.Metric,
+				Threshold:   threshold,
+				Utilization: after,
+				Direction:   direction,
+				Timestamp:   update.Timestamp,
+			})
+		}
+	}
+
+	return crossings
+}
+
+// handleEvents streams each fetch cycle as quota event and threshold crossings as threshold events using server-sent events;
+// the project and metric query parameters filter the stream
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	projects := r.URL.Query()["project"]
+	metrics := r.URL.Query()["metric"]
+
+	updates, latest := quotaUpdates.subscribe()
+	defer quotaUpdates.unsubscribe(updates)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(event string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			log.Warn().Err(err).Msg("Marshalling server-sent event failed")
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	previous := filterQuotaUpdates(latest, projects, metrics)
+	if len(previous) > 0 && !writeEvent("quota", previous) {
+		return
+	}
+
+	// keep idle connections from being closed by proxies
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case cycle := <-updates:
+			current := filterQuotaUpdates(cycle, projects, metrics)
+			if len(current) == 0 {
+				continue
+			}
+			if !writeEvent("quota", current) {
+				return
+			}
+			for _, crossing := range detectThresholdCrossings(previous, current, *eventThresholds) {
+				if !writeEvent("threshold", crossing) {
+					return
+				}
+			}
+			previous = current
+		}
+	}
+}
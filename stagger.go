@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// staggerWindowFraction is the part of the fetch interval project fetches are spread across, leaving headroom for slow calls
+const staggerWindowFraction = 0.9
+
+type staggerWindowKey struct{}
+
+// withStaggerWindow returns a context in which fetchQuota spreads the project fetches of a cycle evenly across the window
+func withStaggerWindow(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, staggerWindowKey{}, window)
+}
+
+func staggerWindowFromContext(ctx context.Context) time.Duration {
+	window, _ := ctx.Value(staggerWindowKey{}).(time.Duration)
+	return window
+}
+
+// staggerPacer hands out evenly spaced start times, so fetches don't all hit the apis at once
+type staggerPacer struct {
+	next  time.Time
+	step  time.Duration
+	mutex sync.Mutex
+}
+
+// newStaggerPacer spreads count starts across the window; it returns nil, which doesn't wait at all, if there's nothing to spread
+func newStaggerPacer(window time.Duration, count int) *staggerPacer {
+	if window <= 0 || count < 2 {
+		return nil
+	}
+	return &staggerPacer{
+		next: time.Now(),
+		step: window / time.Duration(count),
+	}
+}
+
+// wait blocks until the next start time or until the context is done
+func (p *staggerPacer) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	start := p.next
+	p.next = p.next.Add(p.step)
+	p.mutex.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
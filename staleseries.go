@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// create counter for series removed because their project, region or quota is gone
+	staleSeriesRemovedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_stale_series_removed_total",
+		Help: "The number of quota series removed because their project is no longer configured or discovered, or a successful fetch no longer reported them.",
+	})
+
+	// create counter for series removed because they weren't refreshed within the ttl
+	expiredSeriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_expired_series_total",
+		Help: "The number of quota series removed because they weren't refreshed within --metric-ttl cycles.",
+	})
+
+	// activeSeries has the series set per collector and project and gauge; guarded by snapshotMutex
+	activeSeries = map[seriesOwner]map[*prometheus.GaugeVec]map[string]*trackedSeries{}
+
+	// seriesCycle counts applied fetch cycles, to expire series by age; guarded by snapshotMutex
+	seriesCycle int
+)
+
+// trackedSeries has the label values and gauge of a series and the cycle it was last set in
+type trackedSeries struct {
+	labels    []string
+	gauge     prometheus.Gauge
+	lastCycle int
+}
+
+func init() {
+	prometheus.MustRegister(staleSeriesRemovedTotal)
+	prometheus.MustRegister(expiredSeriesTotal)
+}
+
+type seriesOwnerKey struct{}
+
+// seriesOwner is the collector and project that set a series, so it can be removed once they no longer report it
+type seriesOwner struct {
+	collector string
+	project   string
+}
+
+// withSeriesOwner returns a context in which the gauges staged by setGauge are owned by the collector and project
+func withSeriesOwner(ctx context.Context, collector, project string) context.Context {
+	return context.WithValue(ctx, seriesOwnerKey{}, seriesOwner{collector: collector, project: project})
+}
+
+func seriesOwnerFromContext(ctx context.Context) (seriesOwner, bool) {
+	owner, ok := ctx.Value(seriesOwnerKey{}).(seriesOwner)
+	return owner, ok
+}
+
+// appendSeriesKey appends the key of a series with the label values to the buffer, so looking up known series doesn't allocate
+func appendSeriesKey(key []byte, labels []string) []byte {
+	for i, label := range labels {
+		if i > 0 {
+			key = append(key, 0)
+		}
+		key = append(key, label...)
+	}
+	return key
+}
+
+// trackSeries returns the gauge of a series set in the current cycle, creating and tracking it the first time it's set; known series
+// reuse their label values and gauge; it has to be called with snapshotMutex held for writing
+func trackSeries(owner seriesOwner, gauge *prometheus.GaugeVec, labels []string, key []byte) prometheus.Gauge {
+
+	gauges, ok := activeSeries[owner]
+	if !ok {
+		gauges = map[*prometheus.GaugeVec]map[string]*trackedSeries{}
+		activeSeries[owner] = gauges
+	}
+	series, ok := gauges[gauge]
+	if !ok {
+		series = map[string]*trackedSeries{}
+		gauges[gauge] = series
+	}
+
+	tracked, ok := series[string(key)]
+	if !ok {
+		tracked = &trackedSeries{labels: labels, gauge: gauge.WithLabelValues(labels...)}
+		series[string(key)] = tracked
+	}
+	tracked.lastCycle = seriesCycle
+
+	return tracked.gauge
+}
+
+// refreshSeries marks all series of the gauge set by the owner as set in the current cycle, keeping their values; it has to be called
+// with snapshotMutex held for writing
+func refreshSeries(owner seriesOwner, gauge *prometheus.GaugeVec) {
+	for _, tracked := range activeSeries[owner][gauge] {
+		tracked.lastCycle = seriesCycle
+	}
+}
+
+// removeStaleSeries deletes the series of owners that were collected successfully but didn't set them in the current cycle, and all
+// series of owners whose project is no longer listed; owners that failed or were skipped keep their previous series, until they're
+// older than ttl cycles if ttl is positive; it has to be called with snapshotMutex held for writing, after the series of the current
+// cycle have been tracked
+func removeStaleSeries(listed, collected map[seriesOwner]bool, unlistedCollectors map[string]bool, ttl int) {
+
+	removed, expired := 0, 0
+	for owner, gauges := range activeSeries {
+
+		unlisted := !collected[owner] && !listed[owner] && !unlistedCollectors[owner.collector]
+
+		remaining, ownerRemoved := 0, 0
+		for gauge, series := range gauges {
+			for key, tracked := range series {
+				if tracked.lastCycle == seriesCycle {
+					remaining++
+					continue
+				}
+				switch {
+				case unlisted, collected[owner]:
+					ownerRemoved++
+				case ttl > 0 && seriesCycle-tracked.lastCycle >= ttl:
+					expired++
+				default:
+					remaining++
+					continue
+				}
+				gauge.DeleteLabelValues(tracked.labels...)
+				delete(series, key)
+			}
+			if len(series) == 0 {
+				delete(gauges, gauge)
+			}
+		}
+
+		removed += ownerRemoved
+		if remaining == 0 {
+			delete(activeSeries, owner)
+		}
+		if unlisted && ownerRemoved > 0 {
+			log.Info().Str("collector", owner.collector).Str("project", owner.project).Msgf("Removed %v quota series of project %v, it's no longer configured or discovered", owner.collector, owner.project)
+		}
+	}
+
+	if removed > 0 {
+		staleSeriesRemovedTotal.Add(float64(removed))
+		log.Debug().Msgf("Removed %v stale quota series", removed)
+	}
+	if expired > 0 {
+		expiredSeriesTotal.Add(float64(expired))
+		log.Info().Msgf("Removed %v quota series that weren't refreshed in the last %v cycles", expired, ttl)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// notModifiedComputeClient reports the list of regions as unchanged while notModified is set, like the api answering with 304 not
+// modified
+type notModifiedComputeClient struct {
+	*fakeComputeClient
+	notModified bool
+}
+
+func (c *notModifiedComputeClient) ListRegions(ctx context.Context, project string) ([]*compute.Region, bool, error) {
+	regions, _, err := c.fakeComputeClient.ListRegions(ctx, project)
+	return regions, c.notModified && err == nil, err
+}
+
+func TestRemoveStaleSeries(t *testing.T) {
+
+	cpus := func(project string) expectedSeries {
+		return expectedSeries{gauge: regionalQuotaLimit, labels: prometheus.Labels{"project": project, "region": "europe-west1", "metric": "cpus"}}
+	}
+	instances := func(project string) expectedSeries {
+		return expectedSeries{gauge: regionalQuotaLimit, labels: prometheus.Labels{"project": project, "region": "europe-west1", "metric": "instances"}}
+	}
+
+	type cycle struct {
+		projects    []string
+		notModified bool
+		setup       func(c *fakeComputeClient)
+	}
+
+	tests := []struct {
+		name    string
+		cycles  []cycle
+		present []expectedSeries
+		absent  []expectedSeries
+	}{
+		{
+			name: "RemovesQuotaNoLongerReported",
+			cycles: []cycle{
+				{projects: []string{"stale-project"}, setup: func(c *fakeComputeClient) {
+					c.SetRegionQuota("stale-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}, "INSTANCES": {100, 4}}))
+				}},
+				{projects: []string{"stale-project"}, setup: func(c *fakeComputeClient) {
+					c.SetRegionQuota("stale-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+				}},
+			},
+			present: []expectedSeries{cpus("stale-project")},
+			absent:  []expectedSeries{instances("stale-project")},
+		},
+		{
+			name: "KeepsSeriesOfFailingProject",
+			cycles: []cycle{
+				{projects: []string{"stale-project"}, setup: func(c *fakeComputeClient) {
+					c.SetRegionQuota("stale-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}, "INSTANCES": {100, 4}}))
+				}},
+				{projects: []string{"stale-project"}, setup: func(c *fakeComputeClient) {
+					c.SetError("stale-project", errors.New("backend error"))
+				}},
+			},
+			present: []expectedSeries{cpus("stale-project"), instances("stale-project")},
+		},
+		{
+			name: "RemovesSeriesOfProjectNoLongerListed",
+			cycles: []cycle{
+				{projects: []string{"stale-project", "other-project"}, setup: func(c *fakeComputeClient) {
+					c.SetRegionQuota("stale-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+					c.SetRegionQuota("other-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}}))
+				}},
+				{projects: []string{"stale-project"}},
+			},
+			present: []expectedSeries{cpus("stale-project")},
+			absent:  []expectedSeries{cpus("other-project")},
+		},
+		{
+			name: "KeepsSeriesOfUnchangedRegions",
+			cycles: []cycle{
+				{projects: []string{"stale-project"}, setup: func(c *fakeComputeClient) {
+					c.SetRegionQuota("stale-project", "europe-west1", testQuotas(map[string][2]float64{"CPUS": {24, 8}, "INSTANCES": {100, 4}}))
+				}},
+				{projects: []string{"stale-project"}, notModified: true},
+			},
+			present: []expectedSeries{cpus("stale-project"), instances("stale-project")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resetTestState(globalQuotaLimit, globalQuotaUsage, regionalQuotaLimit, regionalQuotaUsage)
+			client := newFakeComputeClient()
+			for _, project := range []string{"stale-project", "other-project"} {
+				client.SetProjectQuota(project, testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+			}
+			notModifiedClient := &notModifiedComputeClient{fakeComputeClient: client}
+			source := &computeQuotaSource{clients: newStaticClientManager(notModifiedClient), regions: []string{"europe-west1"}, projectRegions: map[string][]string{}}
+
+			for _, c := range tt.cycles {
+				if c.setup != nil {
+					c.setup(client)
+				}
+				notModifiedClient.notModified = c.notModified
+				runTestCycle(t, source, c.projects...)
+			}
+
+			for _, series := range tt.present {
+				if !hasSeries(series.gauge, series.labels) {
+					t.Errorf("Series %v was removed", series.labels)
+				}
+			}
+			for _, series := range tt.absent {
+				if hasSeries(series.gauge, series.labels) {
+					t.Errorf("Series %v wasn't removed", series.labels)
+				}
+			}
+		})
+	}
+}
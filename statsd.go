@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdMaxPacketSize keeps packets below the usual mtu so they don't get fragmented
+const statsdMaxPacketSize = 1432
+
+// statsdSink sends gauges over udp in statsd format, with labels as dogstatsd tags or appended to the metric name
+type statsdSink struct {
+	address   string
+	prefix    string
+	dogstatsd bool
+}
+
+func newStatsdSink(address, prefix string, dogstatsd bool) *statsdSink {
+	return &statsdSink{
+		address:   address,
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+	}
+}
+
+func (s *statsdSink) Name() string {
+	return "statsd " + s.address
+}
+
+func (s *statsdSink) Push(ctx context.Context, families []*dto.MetricFamily) error {
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	for _, series := range flattenFamilies(families) {
+		line := s.line(series)
+
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdMaxPacketSize {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		if _, err := conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// line formats a series as gauge, e.g. estafette_gcloud_regional_quota_usage:12|g|#project:my-project,region:europe-west1,metric:cpus
+// for dogstatsd or estafette_gcloud_regional_quota_usage.my-project.europe-west1.cpus:12|g for plain statsd
+func (s *statsdSink) line(series flatSeries) string {
+
+	name := s.prefix + series.labels["__name__"]
+
+	names := []string{}
+	for label := range series.labels {
+		if label != "__name__" {
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+
+	if s.dogstatsd {
+		tags := []string{}
+		for _, label := range names {
+			tags = append(tags, statsdSanitize(label)+":"+statsdSanitize(series.labels[label]))
+		}
+		line := fmt.Sprintf("%v:%v|g", name, series.value)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		return line
+	}
+
+	for _, label := range names {
+		name += "." + strings.Replace(statsdSanitize(series.labels[label]), ".", "_", -1)
+	}
+
+	return fmt.Sprintf("%v:%v|g", name, series.value)
+}
+
+// statsdSanitize replaces the characters that have a meaning in the statsd line format
+func statsdSanitize(s string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_").Replace(s)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+var (
+	// create gauges for the number and size of snapshots, custom images and disks, with the quota they count against
+	storageResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_storage_resources",
+		Help: "The number of snapshots, custom images and disks per value of the --storage-group-label label, with the quota metric they count against.",
+	}, []string{"project", "region", "quota", "group"})
+	storageBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_storage_bytes",
+		Help: "The size of snapshots, custom images and disks per value of the --storage-group-label label, with the quota metric they count against.",
+	}, []string{"project", "region", "quota", "group"})
+)
+
+func init() {
+	prometheus.MustRegister(storageResources)
+	prometheus.MustRegister(storageBytes)
+
+	registerQuotaSource("storage", func(clients clientProvider, regions []string) quotaSource {
+		return &storageQuotaSource{clients: clients, regions: regions, groupLabel: *storageGroupLabel}
+	})
+}
+
+// storageQuotaSource counts snapshots, custom images and disks grouped by a label like team, since the coarse quota usage hides
+// whose backups are eating the limit; the quota label matches the metric label of the global and regional quota
+type storageQuotaSource struct {
+	clients    clientProvider
+	regions    []string
+	groupLabel string
+}
+
+func (s *storageQuotaSource) Name() string {
+	return "storage"
+}
+
+func (s *storageQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return s.regions, nil
+}
+
+func (s *storageQuotaSource) Permissions() []string {
+	return []string{"compute.snapshots.list", "compute.images.list", "compute.disks.list"}
+}
+
+func (s *storageQuotaSource) Collect(ctx context.Context, project string, regions []string) ([]quotaUpdate, error) {
+
+	httpClient, err := s.clients.httpClient(project)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := compute.New(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ region, quota, group string }
+	counts := map[key]float64{}
+	sizes := map[key]float64{}
+	add := func(region, quota string, labels map[string]string, bytes float64) {
+		k := key{region: region, quota: quota, group: labels[s.groupLabel]}
+		counts[k]++
+		sizes[k] += bytes
+	}
+
+	err = computeService.Snapshots.List(project).Fields("items/labels", "items/storageBytes", "nextPageToken").Pages(ctx, func(page *compute.SnapshotList) error {
+		for _, snapshot := range page.Items {
+			add("", "snapshots", snapshot.Labels, float64(snapshot.StorageBytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// only custom images are listed for a project, public images live in their own projects
+	err = computeService.Images.List(project).Fields("items/labels", "items/diskSizeGb", "nextPageToken").Pages(ctx, func(page *compute.ImageList) error {
+		for _, image := range page.Items {
+			add("", "images", image.Labels, float64(image.DiskSizeGb)*(1<<30))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = computeService.Disks.AggregatedList(project).Fields("items/*/disks/labels", "items/*/disks/sizeGb", "items/*/disks/type", "nextPageToken").Pages(ctx, func(page *compute.DiskAggregatedList) error {
+		for scope, list := range page.Items {
+			region := regionFromScope(scope)
+			if !stringInSlice(regions, region) {
+				continue
+			}
+			for _, disk := range list.Disks {
+				quota := "disks_total_gb"
+				if strings.HasSuffix(disk.Type, "/pd-ssd") {
+					quota = "ssd_total_gb"
+				}
+				add(region, quota, disk.Labels, float64(disk.SizeGb)*(1<<30))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for k, count := range counts {
+		setGauge(ctx, storageResources, count, project, k.region, k.quota, k.group)
+		setGauge(ctx, storageBytes, sizes[k], project, k.region, k.quota, k.group)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemd passes activated sockets starting at this file descriptor, see sd_listen_fds(3)
+const systemdListenFdsStart = 3
+
+// systemdListener returns the listener handed over by systemd socket activation with the given FileDescriptorName=, falling back
+// to the first passed socket if no names are set; it returns nil if the process isn't socket activated
+func systemdListener(name string) (net.Listener, error) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+
+	index := 0
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		index = -1
+		for i, fdName := range strings.Split(fdNames, ":") {
+			if fdName == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 || index >= count {
+			return nil, fmt.Errorf("No socket named %v passed by systemd, got %v", name, fdNames)
+		}
+	}
+
+	file := os.NewFile(uintptr(systemdListenFdsStart+index), name)
+	defer file.Close()
+
+	return net.FileListener(file)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxRetryAfter caps the delay asked for by a Retry-After header, so a bogus value can't stall a project forever
+const maxRetryAfter = 5 * time.Minute
+
+var (
+	// create counter for rate limited api calls
+	throttledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_throttled_total",
+		Help: "The number of google cloud api calls that were rate limited, after which calls for the project back off.",
+	}, []string{"service", "project"})
+
+	// projectThrottles holds off calls for projects that were rate limited
+	projectThrottles = &projectThrottle{until: map[string]time.Time{}}
+)
+
+func init() {
+	prometheus.MustRegister(throttledTotal)
+}
+
+// projectThrottle tracks until when calls for each project back off after being rate limited, since the limits are per project
+type projectThrottle struct {
+	until map[string]time.Time
+	mutex sync.Mutex
+}
+
+// backOff makes calls for the project wait for the delay, unless they already wait longer
+func (t *projectThrottle) backOff(project string, delay time.Duration) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	until := time.Now().Add(delay)
+	if until.After(t.until[project]) {
+		t.until[project] = until
+	}
+}
+
+// wait blocks until the project's back off is over or the context is done
+func (t *projectThrottle) wait(ctx context.Context, project string) error {
+
+	t.mutex.Lock()
+	delay := time.Until(t.until[project])
+	t.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// isRateLimited checks for a 429 or a 403 with reason rateLimitExceeded or userRateLimitExceeded, as the compute api returns; the body
+// of a 403 is read to find the reason and replaced so the caller can still read it
+func isRateLimited(resp *http.Response) bool {
+
+	if resp == nil {
+		return false
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(body, []byte(`"rateLimitExceeded"`)) || bytes.Contains(body, []byte(`"userRateLimitExceeded"`))
+}
+
+// retryAfter parses the Retry-After header in seconds or as http date; it returns 0 if absent or invalid
+func retryAfter(resp *http.Response) time.Duration {
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+
+	return delay
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// topView holds the interactive state of the terminal dashboard
+type topView struct {
+	sortByAbsoluteHeadroom bool
+	filter                 string
+	rows                   int
+}
+
+// runTop fetches quota continuously and redraws a top-style table after every cycle; commands are read line by line from stdin
+// so it works in any terminal without raw mode
+func runTop(ctx context.Context, clients clientProvider, quotaSources []quotaSource, projects []string, rows int) {
+
+	// logs would garble the table, so they go to a file instead
+	logPath := filepath.Join(os.TempDir(), "estafette-gcloud-quota-exporter-top.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Opening log file %v failed", logPath)
+	}
+	defer logFile.Close()
+	log.Logger = log.Output(logFile)
+
+	updates, latest := quotaUpdates.subscribe()
+	defer quotaUpdates.unsubscribe(updates)
+
+	go func() {
+		for {
+			fetchQuota(ctx, clients, quotaSources, projects)
+
+			sleepTime := applyJitter(*fetchInterval, *fetchJitterPercent)
+			log.Info().Msgf("Sleeping for %v...", sleepTime)
+			time.Sleep(sleepTime)
+		}
+	}()
+
+	commands := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			commands <- strings.TrimSpace(scanner.Text())
+		}
+		close(commands)
+	}()
+
+	view := &topView{rows: rows}
+	for {
+		view.render(os.Stdout, latest, logPath)
+
+		select {
+		case latest = <-updates:
+		case command, ok := <-commands:
+			if !ok || command == "q" {
+				return
+			}
+			view.handle(command)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle applies a command typed by the user: q quits, h sorts by utilization, a by absolute headroom and /text filters
+func (v *topView) handle(command string) {
+	switch {
+	case command == "h":
+		v.sortByAbsoluteHeadroom = false
+	case command == "a":
+		v.sortByAbsoluteHeadroom = true
+	case strings.HasPrefix(command, "/"):
+		v.filter = strings.TrimPrefix(command, "/")
+	}
+}
+
+func (v *topView) render(w io.Writer, updates []quotaUpdate, logPath string) {
+
+	rows := []quotaUpdate{}
+	for _, update := range updates {
+		// unlimited quota has no headroom to watch
+		if update.Limit < 0 {
+			continue
+		}
+		if v.filter != "" && !strings.Contains(update.Project+" "+update.Region+" "+update.Metric, v.filter) {
+			continue
+		}
+		rows = append(rows, update)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if v.sortByAbsoluteHeadroom {
+			return rows[i].Limit-rows[i].Usage < rows[j].Limit-rows[j].Usage
+		}
+		return utilization(rows[i]) > utilization(rows[j])
+	})
+
+	// clear the screen and move the cursor home
+	fmt.Fprint(w, "\033[H\033[2J")
+
+	sortedBy := "utilization"
+	if v.sortByAbsoluteHeadroom {
+		sortedBy = "absolute headroom"
+	}
+	updatedAt := "waiting for first fetch cycle..."
+	if len(updates) > 0 {
+		updatedAt = updates[0].Timestamp.Format("15:04:05")
+	}
+	fmt.Fprintf(w, "estafette-gcloud-quota-exporter - %v quota, sorted by %v, filter %q - updated %v\n\n", len(rows), sortedBy, v.filter, updatedAt)
+	fmt.Fprintf(w, "%-30v %-24v %-35v %10v %10v %7v %10v\n", "PROJECT", "REGION", "METRIC", "USAGE", "LIMIT", "UTIL", "HEADROOM")
+
+	for i, update := range rows {
+		if i == v.rows {
+			break
+		}
+		region := update.Region
+		if region == "" {
+			region = "global"
+		}
+
+		// highlight quota that's close to its limit
+		color, reset := "", ""
+		switch u := utilization(update); {
+		case u >= 0.9:
+			color, reset = "\033[31m", "\033[0m"
+		case u >= 0.8:
+			color, reset = "\033[33m", "\033[0m"
+		}
+
+		fmt.Fprintf(w, "%v%-30v %-24v %-35v %10v %10v %6.1f%% %10v%v\n", color, update.Project, region, update.Metric, update.Usage, update.Limit, 100*utilization(update), update.Limit-update.Usage, reset)
+	}
+
+	fmt.Fprintf(w, "\ncommands (followed by enter): q quit, h sort by utilization, a sort by absolute headroom, /text filter, / clear filter - logs in %v\n", logPath)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+
+	// maxQueuedSpans bounds memory if the collector is unreachable; spans beyond it are dropped
+	maxQueuedSpans = 10000
+)
+
+type spanContextKey struct{}
+
+// span is a single timed operation of a trace; all methods are no-ops on a nil span, which is what startSpan returns when tracing is
+// disabled
+type span struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	status     int
+	message    string
+}
+
+// spanExporter batches finished spans and sends them to an otlp/http traces endpoint in json encoding
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	spans    []*span
+	mutex    sync.Mutex
+}
+
+// tracer is nil when tracing is disabled
+var tracer *spanExporter
+
+// initTracing exports spans to the endpoint, like http://otel-collector:4318/v1/traces, every interval
+func initTracing(ctx context.Context, endpoint string, headers []string, interval time.Duration) error {
+
+	// the otlp sink parses the headers the same way
+	sink, err := newOTLPSink(endpoint, headers)
+	if err != nil {
+		return err
+	}
+
+	tracer = &spanExporter{
+		endpoint: endpoint,
+		headers:  sink.headers,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	log.Info().Msgf("Exporting traces to %v every %v", endpoint, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := tracer.flush(ctx); err != nil {
+					log.Warn().Err(err).Msgf("Exporting traces to %v failed", endpoint)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// startSpan starts a span as child of the span in the context, or a new trace if there is none
+func startSpan(ctx context.Context, name string, kind int, attributes map[string]string) (context.Context, *span) {
+
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	s := &span{
+		spanID:     randomHex(8),
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	for key, value := range attributes {
+		s.attributes[key] = value
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+func (s *span) setAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// finish ends the span, marking it as failed if err is set, and queues it for export
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.status = spanStatusOK
+	if err != nil {
+		s.status = spanStatusError
+		s.message = err.Error()
+	}
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	if len(tracer.spans) < maxQueuedSpans {
+		tracer.spans = append(tracer.spans, s)
+	}
+}
+
+func (e *spanExporter) flush(ctx context.Context) error {
+
+	e.mutex.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mutex.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpTracesRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Otlp traces endpoint returned status %v: %v", resp.StatusCode, string(message))
+	}
+
+	return nil
+}
+
+// otlpTracesRequest converts the spans into an ExportTraceServiceRequest in its json mapping, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+func otlpTracesRequest(spans []*span) map[string]interface{} {
+
+	otlpSpans := []map[string]interface{}{}
+	for _, s := range spans {
+		otlpSpan := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+			"status": map[string]interface{}{
+				"code":    s.status,
+				"message": s.message,
+			},
+		}
+		if s.parentID != "" {
+			otlpSpan["parentSpanId"] = s.parentID
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{
+			{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{
+						"service.name":    "estafette-gcloud-quota-exporter",
+						"service.version": version,
+					}),
+				},
+				"scopeSpans": []map[string]interface{}{
+					{
+						"scope": map[string]interface{}{
+							"name":    "estafette-gcloud-quota-exporter",
+							"version": version,
+						},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// tracingTransport records a client span for every api call, as child of the span in the request context
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	_, s := startSpan(req.Context(), fmt.Sprintf("%v %v", req.Method, serviceFromRequest(req)), spanKindClient, map[string]string{
+		"http.method": req.Method,
+		"http.host":   req.URL.Host,
+		"http.target": req.URL.Path,
+		"project":     projectFromAPIPath(req.URL.Path),
+	})
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		s.finish(err)
+		return resp, err
+	}
+
+	s.setAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		s.finish(fmt.Errorf("Status %v", resp.StatusCode))
+	} else {
+		s.finish(nil)
+	}
+
+	return resp, err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// unitConversions maps the unit suffixes of quota metrics to the base unit prometheus conventions prefer; disk sizes in google cloud
+// are binary gigabytes
+var unitConversions = []struct {
+	suffix     string
+	baseSuffix string
+	multiplier float64
+}{
+	{"_GB", "_BYTES", 1 << 30},
+	{"_TB", "_BYTES", 1 << 40},
+	{"_MBPS", "_BITS_PER_SECOND", 1e6},
+	{"_GBPS", "_BITS_PER_SECOND", 1e9},
+}
+
+// normalizeUnit converts a value of a quota metric like DISKS_TOTAL_GB into its base unit with a matching metric like DISKS_TOTAL_BYTES
+// if --normalize-units is enabled; the -1 of unlimited quota is left as is
+func normalizeUnit(metric string, value float64) (string, float64) {
+
+	if !*normalizeUnits {
+		return metric, value
+	}
+
+	for _, conversion := range unitConversions {
+		if strings.HasSuffix(strings.ToUpper(metric), conversion.suffix) {
+			metric = metric[:len(metric)-len(conversion.suffix)] + conversion.baseSuffix
+			if value != -1 {
+				value *= conversion.multiplier
+			}
+			return metric, value
+		}
+	}
+
+	return metric, value
+}
+
+// normalizeQuotaUnits returns the quotas with their limit and usage converted to base units
+func normalizeQuotaUnits(quotas []*compute.Quota) []*compute.Quota {
+
+	if !*normalizeUnits {
+		return quotas
+	}
+
+	normalized := make([]*compute.Quota, 0, len(quotas))
+	for _, quota := range quotas {
+		normalizedQuota := *quota
+		normalizedQuota.Metric, normalizedQuota.Limit = normalizeUnit(quota.Metric, quota.Limit)
+		_, normalizedQuota.Usage = normalizeUnit(quota.Metric, quota.Usage)
+		normalized = append(normalized, &normalizedQuota)
+	}
+
+	return normalized
+}
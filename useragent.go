@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// userAgentTransport prefixes the user agent of every outbound call so api traffic can be attributed to this exporter instance
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	// clone the request, a RoundTripper shouldn't modify the original
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+
+	userAgent := t.userAgent
+	if existing := req.Header.Get("User-Agent"); existing != "" {
+		userAgent += " " + existing
+	}
+	clone.Header.Set("User-Agent", userAgent)
+
+	return t.base.RoundTrip(clone)
+}
+
+// buildUserAgent returns the configured user agent or one identifying the exporter version and deployment
+func buildUserAgent(configuredUserAgent, deploymentName string) string {
+
+	if configuredUserAgent != "" {
+		return configuredUserAgent
+	}
+
+	userAgent := fmt.Sprintf("%v/%v", app, version)
+	if app == "" {
+		userAgent = fmt.Sprintf("estafette-gcloud-quota-exporter/%v", version)
+	}
+	if deploymentName != "" {
+		userAgent += fmt.Sprintf(" (deployment %v)", deploymentName)
+	}
+
+	return userAgent
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// create counter for vpc service controls perimeter violations
+	vpcServiceControlsViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "estafette_gcloud_quota_vpc_sc_violations_total",
+		Help: "The number of api calls rejected by a VPC Service Controls perimeter.",
+	}, []string{"project"})
+
+	// create gauge indicating whether a project is currently skipped because of a perimeter violation
+	vpcServiceControlsBlocked = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_vpc_sc_blocked",
+		Help: "Whether fetching quota for the project is suspended because of a VPC Service Controls perimeter violation.",
+	}, []string{"project"})
+
+	// projects blocked by a perimeter and the time until which they're skipped
+	vpcServiceControlsBlockedUntil      = map[string]time.Time{}
+	vpcServiceControlsBlockedUntilMutex sync.Mutex
+)
+
+func init() {
+	prometheus.MustRegister(vpcServiceControlsViolations)
+	prometheus.MustRegister(vpcServiceControlsBlocked)
+}
+
+// isVPCServiceControlsError checks whether an api error is caused by a VPC Service Controls perimeter
+func isVPCServiceControlsError(err error) bool {
+
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Code != 403 {
+		return false
+	}
+
+	for _, marker := range []string{"vpcServiceControlsUniqueIdentifier", "VPC_SERVICE_CONTROLS", "SECURITY_POLICY_VIOLATED"} {
+		if strings.Contains(apiErr.Body, marker) || strings.Contains(apiErr.Message, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleVPCServiceControlsViolation records the violation and suspends the project so the perimeter isn't hammered with calls that can't succeed
+func handleVPCServiceControlsViolation(project string, err error) {
+
+	log.Error().Err(err).Msgf("Api call for project %v is blocked by a VPC Service Controls perimeter; this is a perimeter configuration issue, skipping project for %v", project, *vpcServiceControlsBackoff)
+
+	vpcServiceControlsViolations.WithLabelValues(project).Inc()
+	vpcServiceControlsBlocked.WithLabelValues(project).Set(1)
+
+	vpcServiceControlsBlockedUntilMutex.Lock()
+	defer vpcServiceControlsBlockedUntilMutex.Unlock()
+
+	vpcServiceControlsBlockedUntil[project] = time.Now().Add(*vpcServiceControlsBackoff)
+}
+
+// isBlockedByVPCServiceControls checks whether the project is still suspended after an earlier perimeter violation
+func isBlockedByVPCServiceControls(project string) bool {
+
+	vpcServiceControlsBlockedUntilMutex.Lock()
+	defer vpcServiceControlsBlockedUntilMutex.Unlock()
+
+	blockedUntil, ok := vpcServiceControlsBlockedUntil[project]
+	if !ok {
+		return false
+	}
+
+	if time.Now().Before(blockedUntil) {
+		return true
+	}
+
+	// backoff expired, give the project another chance
+	delete(vpcServiceControlsBlockedUntil, project)
+	vpcServiceControlsBlocked.WithLabelValues(project).Set(0)
+
+	return false
+}
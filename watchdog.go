@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// lastCycleCompleted holds the unix nanoseconds at which the last fetch cycle completed, or the exporter started
+	lastCycleCompleted = time.Now().UnixNano()
+
+	// watchdogEnabled is only set while fetching in a loop, so one-off commands don't report themselves as wedged
+	watchdogEnabled int32
+)
+
+// enableCycleWatchdog makes /liveness fail once no fetch cycle completes for the configured number of intervals
+func enableCycleWatchdog() {
+	atomic.StoreInt64(&lastCycleCompleted, time.Now().UnixNano())
+	atomic.StoreInt32(&watchdogEnabled, 1)
+}
+
+// recordCycleCompleted resets the watchdog at the end of each fetch cycle
+func recordCycleCompleted() {
+	atomic.StoreInt64(&lastCycleCompleted, time.Now().UnixNano())
+}
+
+// checkCycleWatchdog returns an error if the collection loop looks wedged, so kubernetes restarts the pod instead of it serving stale data
+func checkCycleWatchdog() error {
+
+	if *livenessMaxMissedCycles <= 0 || atomic.LoadInt32(&watchdogEnabled) == 0 {
+		return nil
+	}
+
+	since := time.Since(time.Unix(0, atomic.LoadInt64(&lastCycleCompleted)))
+	if since > time.Duration(*livenessMaxMissedCycles)*maxCycleInterval() {
+		return fmt.Errorf("No fetch cycle completed in the last %v", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// maxCycleInterval is the longest time between the start of two fetch cycles, the fetch interval plus the maximum jitter
+func maxCycleInterval() time.Duration {
+	return *fetchInterval + time.Duration(float64(*fetchInterval)*float64(*fetchJitterPercent)/100)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// websocketSubscription is sent by clients to select the quota they want to receive updates for; empty lists match everything
+type websocketSubscription struct {
+	Type     string   `json:"type"`
+	Projects []string `json:"projects"`
+	Metrics  []string `json:"metrics"`
+}
+
+// websocketMessage is sent to clients, with quotas for type quota and crossing for type threshold
+type websocketMessage struct {
+	Type     string             `json:"type"`
+	Quotas   []quotaUpdate      `json:"quotas,omitempty"`
+	Crossing *thresholdCrossing `json:"crossing,omitempty"`
+}
+
+var websocketUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// handleWebsocket pushes the quota of each fetch cycle to the client, filtered by the last subscribe message the client sent
+func handleWebsocket(w http.ResponseWriter, r *http.Request) {
+
+	conn, err := websocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug().Err(err).Msg("Upgrading to websocket failed")
+		return
+	}
+	defer conn.Close()
+
+	subscriptions := make(chan websocketSubscription)
+	done := make(chan struct{})
+
+	// read subscription changes until the client disconnects
+	go func() {
+		defer close(done)
+		for {
+			var subscription websocketSubscription
+			if err := conn.ReadJSON(&subscription); err != nil {
+				return
+			}
+			if subscription.Type != "subscribe" {
+				continue
+			}
+			select {
+			case subscriptions <- subscription:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	updates, latest := quotaUpdates.subscribe()
+	defer quotaUpdates.unsubscribe(updates)
+
+	write := func(message websocketMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(message) == nil
+	}
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	subscription := websocketSubscription{}
+	previous := latest
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+
+		case subscription = <-subscriptions:
+			// answer a (changed) subscription with the current state
+			if !write(websocketMessage{Type: "quota", Quotas: filterQuotaUpdates(previous, subscription.Projects, subscription.Metrics)}) {
+				return
+			}
+
+		case cycle := <-updates:
+			current := filterQuotaUpdates(cycle, subscription.Projects, subscription.Metrics)
+			if len(current) > 0 && !write(websocketMessage{Type: "quota", Quotas: current}) {
+				return
+			}
+			for _, crossing := range detectThresholdCrossings(filterQuotaUpdates(previous, subscription.Projects, subscription.Metrics), current, *eventThresholds) {
+				crossing := crossing
+				if !write(websocketMessage{Type: "threshold", Crossing: &crossing}) {
+					return
+				}
+			}
+			previous = cycle
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+var (
+	// create gauge for the number of instances per zone
+	zoneInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_zone_instances",
+		Help: "The number of instances per zone, to break regional quota usage down by zone.",
+	}, []string{"project", "region", "zone"})
+
+	// create gauge for the vcpus of running instances per zone
+	zoneCPUs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_zone_cpus",
+		Help: "The number of vcpus of instances that aren't terminated per zone, to break regional cpu quota usage down by zone.",
+	}, []string{"project", "region", "zone"})
+
+	// create gauge for the disk size per zone and disk type
+	zoneDiskGB = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_zone_disk_gb",
+		Help: "The total size in GB of disks per zone and disk type, to break regional disk quota usage down by zone.",
+	}, []string{"project", "region", "zone", "type"})
+
+	// create gauges for the vcpus per machine family and the limit of the cpu quota that family counts against
+	machineFamilyCPUs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_machine_family_cpus",
+		Help: "The number of vcpus of instances that aren't terminated per region and machine family, with the cpu quota the family counts against.",
+	}, []string{"project", "region", "family", "quota"})
+	machineFamilyCPUsLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "estafette_gcloud_quota_machine_family_cpus_limit",
+		Help: "The limit of the cpu quota a machine family counts against, like n2_cpus or cpus for families without a quota of their own.",
+	}, []string{"project", "region", "family", "quota"})
+)
+
+func init() {
+	prometheus.MustRegister(zoneInstances)
+	prometheus.MustRegister(zoneCPUs)
+	prometheus.MustRegister(zoneDiskGB)
+	prometheus.MustRegister(machineFamilyCPUs)
+	prometheus.MustRegister(machineFamilyCPUsLimit)
+
+	registerQuotaSource("zonal", func(clients clientProvider, regions []string) quotaSource {
+		return &zonalQuotaSource{clients: clients, regions: regions, machineTypeCPUs: map[string]int64{}}
+	})
+}
+
+// zonalQuotaSource exports the instances, vcpus and disk size per zone using aggregated lists, so regional usage can be balanced
+// across zones, and the vcpus per machine family next to the family's cpu quota, to show which family is the actual bottleneck
+type zonalQuotaSource struct {
+	clients clientProvider
+	regions []string
+
+	// machineTypeCPUs caches the vcpus per project, zone and machine type, since they never change
+	machineTypeCPUs map[string]int64
+	mutex           sync.Mutex
+}
+
+func (s *zonalQuotaSource) Name() string {
+	return "zonal"
+}
+
+func (s *zonalQuotaSource) Discover(ctx context.Context, project string) ([]string, error) {
+	return s.regions, nil
+}
+
+func (s *zonalQuotaSource) Permissions() []string {
+	return []string{"compute.instances.list", "compute.disks.list", "compute.machineTypes.get", "compute.regions.get"}
+}
+
+func (s *zonalQuotaSource) Collect(ctx context.Context, project string, regions []string) ([]quotaUpdate, error) {
+
+	computeClient := s.clients.compute(project)
+
+	scopedInstances, err := computeClient.ListInstances(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]float64{}
+	cpus := map[string]float64{}
+	familyCPUs := map[string]map[string]float64{}
+	for scope, list := range scopedInstances {
+		if !strings.HasPrefix(scope, "zones/") || !stringInSlice(regions, regionFromScope(scope)) {
+			continue
+		}
+		zone := strings.TrimPrefix(scope, "zones/")
+
+		for _, instance := range list {
+			instances[zone]++
+			if instance.Status == "TERMINATED" {
+				continue
+			}
+
+			machineType := instance.MachineType[strings.LastIndex(instance.MachineType, "/")+1:]
+			guestCPUs, err := s.guestCPUs(ctx, computeClient, project, zone, machineType)
+			if err != nil {
+				return nil, err
+			}
+			cpus[zone] += float64(guestCPUs)
+
+			region := regionFromScope(scope)
+			if familyCPUs[region] == nil {
+				familyCPUs[region] = map[string]float64{}
+			}
+			familyCPUs[region][machineFamily(machineType)] += float64(guestCPUs)
+		}
+	}
+
+	scopedDisks, err := computeClient.ListDisks(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	diskGB := map[string]map[string]float64{}
+	for scope, list := range scopedDisks {
+		if !strings.HasPrefix(scope, "zones/") || !stringInSlice(regions, regionFromScope(scope)) {
+			continue
+		}
+		zone := strings.TrimPrefix(scope, "zones/")
+
+		for _, disk := range list {
+			if diskGB[zone] == nil {
+				diskGB[zone] = map[string]float64{}
+			}
+			diskGB[zone][disk.Type[strings.LastIndex(disk.Type, "/")+1:]] += float64(disk.SizeGb)
+		}
+	}
+
+	for zone, count := range instances {
+		setGauge(ctx, zoneInstances, count, project, regionFromScope("zones/"+zone), zone)
+		setGauge(ctx, zoneCPUs, cpus[zone], project, regionFromScope("zones/"+zone), zone)
+	}
+	for zone, types := range diskGB {
+		for diskType, size := range types {
+			setGauge(ctx, zoneDiskGB, size, project, regionFromScope("zones/"+zone), zone, diskType)
+		}
+	}
+
+	for region, families := range familyCPUs {
+		r, err := computeClient.GetRegion(ctx, project, region)
+		if err != nil {
+			return nil, err
+		}
+		quotas := map[string]*compute.Quota{}
+		for _, quota := range r.Quotas {
+			quotas[quota.Metric] = quota
+		}
+
+		for family, count := range families {
+			// families without a quota of their own, like n1 and e2, count against the generic cpus quota
+			quota, ok := quotas[strings.ToUpper(family)+"_CPUS"]
+			if !ok {
+				quota, ok = quotas["CPUS"]
+			}
+			if !ok {
+				continue
+			}
+			quotaName := quotaMetricName(quota.Metric)
+			setGauge(ctx, machineFamilyCPUs, count, project, region, family, quotaName)
+			setGauge(ctx, machineFamilyCPUsLimit, quota.Limit, project, region, family, quotaName)
+		}
+	}
+
+	return nil, nil
+}
+
+// machineFamily returns the family of machine types like n2-standard-4 or e2-custom-2-4096; the legacy custom-2-4096 types are n1
+func machineFamily(machineType string) string {
+
+	family := strings.SplitN(machineType, "-", 2)[0]
+	if family == "custom" {
+		return "n1"
+	}
+
+	return family
+}
+
+// guestCPUs returns the vcpus of the machine type in the zone, retrieving them only once
+func (s *zonalQuotaSource) guestCPUs(ctx context.Context, computeClient computeClient, project, zone, machineType string) (int64, error) {
+
+	key := project + "/" + zone + "/" + machineType
+
+	s.mutex.Lock()
+	guestCPUs, ok := s.machineTypeCPUs[key]
+	s.mutex.Unlock()
+	if ok {
+		return guestCPUs, nil
+	}
+
+	m, err := computeClient.GetMachineType(ctx, project, zone, machineType)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	s.machineTypeCPUs[key] = m.GuestCpus
+	s.mutex.Unlock()
+
+	return m.GuestCpus, nil
+}
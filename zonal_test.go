@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pinzolo/casee"
+	"github.com/prometheus/client_golang/prometheus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestZonalQuotaSourceCollect(t *testing.T) {
+
+	instance := func(machineType, status string) *compute.Instance {
+		return &compute.Instance{MachineType: "https://www.googleapis.com/compute/v1/projects/zonal-project/zones/europe-west1-b/machineTypes/" + machineType, Status: status}
+	}
+	disk := func(diskType string, sizeGb int64) *compute.Disk {
+		return &compute.Disk{Type: "https://www.googleapis.com/compute/v1/projects/zonal-project/zones/europe-west1-b/diskTypes/" + diskType, SizeGb: sizeGb}
+	}
+	zone := func(zone string) prometheus.Labels {
+		return prometheus.Labels{"project": "zonal-project", "region": "europe-west1", "zone": zone}
+	}
+	family := func(family, quota string) prometheus.Labels {
+		return prometheus.Labels{"project": "zonal-project", "region": "europe-west1", "family": family, "quota": quota}
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(c *fakeComputeClient)
+		expected []expectedSeries
+		absent   []expectedSeries
+		err      bool
+	}{
+		{
+			name: "CountsInstancesAndCPUsPerZone",
+			setup: func(c *fakeComputeClient) {
+				c.SetInstances("zonal-project", "zones/europe-west1-b", []*compute.Instance{instance("n2-standard-4", "RUNNING"), instance("e2-medium", "RUNNING"), instance("n2-standard-4", "TERMINATED")})
+				c.SetInstances("zonal-project", "zones/europe-west1-c", []*compute.Instance{instance("n2-standard-4", "RUNNING")})
+			},
+			expected: []expectedSeries{
+				{gauge: zoneInstances, labels: zone("europe-west1-b"), value: 3},
+				{gauge: zoneInstances, labels: zone("europe-west1-c"), value: 1},
+				{gauge: zoneCPUs, labels: zone("europe-west1-b"), value: 6},
+				{gauge: zoneCPUs, labels: zone("europe-west1-c"), value: 4},
+			},
+		},
+		{
+			name: "SkipsZonesOfRegionsThatArentConfigured",
+			setup: func(c *fakeComputeClient) {
+				c.SetInstances("zonal-project", "zones/europe-west1-b", []*compute.Instance{instance("n2-standard-4", "RUNNING")})
+				c.SetInstances("zonal-project", "zones/us-central1-a", []*compute.Instance{instance("n2-standard-4", "RUNNING")})
+			},
+			expected: []expectedSeries{
+				{gauge: zoneInstances, labels: zone("europe-west1-b"), value: 1},
+			},
+			absent: []expectedSeries{
+				{gauge: zoneInstances, labels: prometheus.Labels{"project": "zonal-project", "region": "us-central1", "zone": "us-central1-a"}},
+			},
+		},
+		{
+			name: "SumsCPUsPerMachineFamilyAgainstItsQuota",
+			setup: func(c *fakeComputeClient) {
+				c.SetInstances("zonal-project", "zones/europe-west1-b", []*compute.Instance{instance("n2-standard-4", "RUNNING"), instance("e2-medium", "RUNNING"), instance("custom-2-4096", "RUNNING")})
+				c.SetInstances("zonal-project", "zones/europe-west1-c", []*compute.Instance{instance("n2-standard-4", "RUNNING")})
+			},
+			expected: []expectedSeries{
+				{gauge: machineFamilyCPUs, labels: family("n2", casee.ToSnakeCase("N2_CPUS")), value: 8},
+				{gauge: machineFamilyCPUsLimit, labels: family("n2", casee.ToSnakeCase("N2_CPUS")), value: 24},
+				{gauge: machineFamilyCPUs, labels: family("e2", "cpus"), value: 2},
+				{gauge: machineFamilyCPUs, labels: family("n1", "cpus"), value: 2},
+				{gauge: machineFamilyCPUsLimit, labels: family("n1", "cpus"), value: 72},
+			},
+		},
+		{
+			name: "SumsDiskSizePerZoneAndType",
+			setup: func(c *fakeComputeClient) {
+				c.SetDisks("zonal-project", "zones/europe-west1-b", []*compute.Disk{disk("pd-ssd", 100), disk("pd-ssd", 50), disk("pd-standard", 500)})
+				c.SetDisks("zonal-project", "regions/europe-west1", []*compute.Disk{disk("pd-ssd", 200)})
+			},
+			expected: []expectedSeries{
+				{gauge: zoneDiskGB, labels: prometheus.Labels{"project": "zonal-project", "region": "europe-west1", "zone": "europe-west1-b", "type": "pd-ssd"}, value: 150},
+				{gauge: zoneDiskGB, labels: prometheus.Labels{"project": "zonal-project", "region": "europe-west1", "zone": "europe-west1-b", "type": "pd-standard"}, value: 500},
+			},
+			absent: []expectedSeries{
+				{gauge: zoneDiskGB, labels: prometheus.Labels{"project": "zonal-project", "region": "europe-west1", "zone": "europe-west1", "type": "pd-ssd"}},
+			},
+		},
+		{
+			name: "FailsForUnknownMachineType",
+			setup: func(c *fakeComputeClient) {
+				c.SetInstances("zonal-project", "zones/europe-west1-b", []*compute.Instance{instance("n4-standard-4", "RUNNING")})
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resetTestState(zoneInstances, zoneCPUs, zoneDiskGB, machineFamilyCPUs, machineFamilyCPUsLimit)
+			client := newFakeComputeClient()
+			client.SetProjectQuota("zonal-project", testQuotas(map[string][2]float64{"NETWORKS": {15, 3}}))
+			client.SetRegionQuota("zonal-project", "europe-west1", testQuotas(map[string][2]float64{"N2_CPUS": {24, 8}, "CPUS": {72, 12}}))
+			client.SetMachineType("n2-standard-4", 4)
+			client.SetMachineType("e2-medium", 2)
+			client.SetMachineType("custom-2-4096", 2)
+			tt.setup(client)
+			source := &zonalQuotaSource{clients: newStaticClientManager(client), regions: []string{"europe-west1"}, machineTypeCPUs: map[string]int64{}}
+
+			errs := runTestCycle(t, source, "zonal-project")
+
+			assertTestCycle(t, errs["zonal-project"], tt.err, tt.expected, tt.absent)
+		})
+	}
+}